@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/otlp"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// config is the exporter configuration read from the SLURM_EXPORTER_* env vars,
+// the config file and the command line flags. It is read again on reload.
+type config struct {
+	listenAddress   string
+	apiURL          string
+	apiUser         string
+	apiToken        string
+	tlsEnable       bool
+	tlsCert         string
+	tlsKey          string
+	perJobMetrics   bool
+	metricSchema    types.MetricSchema
+	recordDir       string
+	replayDir       string
+	anonymize       bool
+	webConfigFile   string
+	bearerTokenFile string
+	enableLifecycle bool
+	userLabels      *types.LabelTransform
+	accountLabels   *types.LabelTransform
+	seriesFilters   map[string]*types.SeriesFilter
+	otlp            *otlp.Config
+	// minRefreshInterval is how long a poll is reused by later scrapes
+	minRefreshInterval time.Duration
+	// incremental fetches only the jobs and nodes changed since the last
+	// poll, with everything fetched again every fullResyncInterval
+	incremental        bool
+	fullResyncInterval time.Duration
+
+	// settings holds every SLURM_EXPORTER_* value that was set, for the
+	// status page
+	settings map[string]string
+}
+
+// settings holds the SLURM_EXPORTER_* values from the command line flags and
+// the config file, which take precedence over the environment in that order
+type settings struct {
+	flags map[string]string
+	file  map[string]string
+}
+
+// newSettings reads the config file, if any. It is called again on reload so
+// changes to the config file are picked up.
+func newSettings(configFile string, flags map[string]string) (*settings, error) {
+	s := &settings{flags: flags, file: make(map[string]string)}
+	if configFile != "" {
+		var err error
+		s.file, err = readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// lookup returns the value of a setting and whether it was set, like
+// os.LookupEnv
+func (s *settings) lookup(key string) (string, bool) {
+	if v, found := s.flags[key]; found {
+		return v, true
+	}
+	if v, found := s.file[key]; found {
+		return v, true
+	}
+	return os.LookupEnv(key)
+}
+
+// all returns every SLURM_EXPORTER_* setting that was set
+func (s *settings) all() map[string]string {
+	all := make(map[string]string)
+	for _, env := range os.Environ() {
+		k, v, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(k, "SLURM_EXPORTER_") {
+			all[k] = v
+		}
+	}
+	for k, v := range s.file {
+		all[k] = v
+	}
+	for k, v := range s.flags {
+		all[k] = v
+	}
+	return all
+}
+
+// readConfigFile reads a file of KEY=value lines, in the format used by the
+// systemd EnvironmentFile directive
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		s = strings.TrimPrefix(s, "export ")
+		k, v, found := strings.Cut(s, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid line %d in config file %s, expected KEY=value", line, path)
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		values[strings.TrimSpace(k)] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	return values, nil
+}
+
+// parseBool parses an optional boolean setting
+func parseBool(s *settings, key string) (bool, error) {
+	v, found := s.lookup(key)
+	if !found {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s.  Please set to 1, t, T, TRUE, true, True, 0, f, F, FALSE, false, or False", key)
+	}
+	return b, nil
+}
+
+// loadConfig reads the configuration from the settings. It returns an error instead
+// of exiting so a bad config file doesn't stop a running exporter on reload.
+func loadConfig(s *settings) (*config, error) {
+	c := &config{}
+	var err error
+	var found bool
+
+	c.listenAddress, found = s.lookup("SLURM_EXPORTER_LISTEN_ADDRESS")
+	if !found {
+		c.listenAddress = "0.0.0.0:8080"
+	}
+
+	c.replayDir, _ = s.lookup("SLURM_EXPORTER_REPLAY_DIR")
+	c.recordDir, _ = s.lookup("SLURM_EXPORTER_RECORD_DIR")
+	if c.recordDir != "" && c.replayDir != "" {
+		return nil, fmt.Errorf("only one of --record-dir and --replay-dir can be set")
+	}
+	c.anonymize, err = parseBool(s, "SLURM_EXPORTER_RECORD_ANONYMIZE")
+	if err != nil {
+		return nil, err
+	}
+
+	c.apiURL, found = s.lookup("SLURM_EXPORTER_API_URL")
+	if !found && c.replayDir == "" {
+		return nil, fmt.Errorf("you must set SLURM_EXPORTER_API_URL. Example: localhost:6820")
+	}
+
+	// we only need these values if the endpoint is not unix://
+	if c.replayDir != "" {
+		// replaying recorded responses, slurmrestd isn't queried
+	} else if strings.HasPrefix(c.apiURL, "http://") || strings.HasPrefix(c.apiURL, "https://") {
+		c.apiUser, found = s.lookup("SLURM_EXPORTER_API_USER")
+		if !found {
+			return nil, fmt.Errorf("you must set SLURM_EXPORTER_API_USER")
+		}
+		c.apiToken, found = s.lookup("SLURM_EXPORTER_API_TOKEN")
+		if !found {
+			return nil, fmt.Errorf("you must set SLURM_EXPORTER_API_TOKEN")
+		}
+		// default to false, do not break existing conf files
+		c.tlsEnable, err = parseBool(s, "SLURM_EXPORTER_ENABLE_TLS")
+		if err != nil {
+			return nil, err
+		}
+		if c.tlsEnable { // require tlsCert and tlsKey only if tlsEnable is true
+			c.tlsCert, found = s.lookup("SLURM_EXPORTER_TLS_CERT_PATH")
+			if !found {
+				return nil, fmt.Errorf("you must set SLURM_EXPORTER_TLS_CERT_PATH to the path of your cert")
+			}
+			c.tlsKey, found = s.lookup("SLURM_EXPORTER_TLS_KEY_PATH")
+			if !found {
+				return nil, fmt.Errorf("you must set SLURM_EXPORTER_TLS_KEY_PATH to the path of your key")
+			}
+		}
+	} else if !strings.HasPrefix(c.apiURL, "unix://") {
+		return nil, fmt.Errorf("SLURM_EXPORTER_API_URL must start with unix://, http://, or https://\nGot: %s", c.apiURL)
+	}
+
+	c.perJobMetrics, err = parseBool(s, "SLURM_EXPORTER_PER_JOB_METRICS")
+	if err != nil {
+		return nil, err
+	}
+
+	c.metricSchema = types.MetricSchemaV1
+	metricSchemaString, found := s.lookup("SLURM_EXPORTER_METRIC_SCHEMA")
+	if found {
+		c.metricSchema, err = types.ParseMetricSchema(metricSchemaString)
+		if err != nil {
+			return nil, fmt.Errorf("SLURM_EXPORTER_METRIC_SCHEMA must be one of v1, v2, or both\nGot: %s", metricSchemaString)
+		}
+	}
+
+	c.webConfigFile, _ = s.lookup("SLURM_EXPORTER_WEB_CONFIG_FILE")
+	c.bearerTokenFile, _ = s.lookup("SLURM_EXPORTER_WEB_BEARER_TOKEN_FILE")
+	c.enableLifecycle, err = parseBool(s, "SLURM_EXPORTER_WEB_ENABLE_LIFECYCLE")
+	if err != nil {
+		return nil, err
+	}
+	if c.webConfigFile != "" && c.tlsEnable {
+		return nil, fmt.Errorf("SLURM_EXPORTER_ENABLE_TLS can't be used with a web config file, set the certificate in the web config file instead")
+	}
+
+	c.userLabels, err = newLabelTransform(s, "SLURM_EXPORTER_USER_LABEL")
+	if err != nil {
+		return nil, err
+	}
+	c.accountLabels, err = newLabelTransform(s, "SLURM_EXPORTER_ACCOUNT_LABEL")
+	if err != nil {
+		return nil, err
+	}
+
+	c.seriesFilters = make(map[string]*types.SeriesFilter)
+	for _, collector := range []string{"users", "accounts", "nodes", "partitions"} {
+		f, err := newSeriesFilter(s, collector)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			c.seriesFilters[collector] = f
+		}
+	}
+
+	c.otlp, err = newOTLPConfig(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval, found := s.lookup("SLURM_EXPORTER_MIN_REFRESH_INTERVAL"); found {
+		c.minRefreshInterval, err = time.ParseDuration(interval)
+		if err != nil || c.minRefreshInterval < 0 {
+			return nil, fmt.Errorf("failed to parse SLURM_EXPORTER_MIN_REFRESH_INTERVAL, it must be a duration like 30s")
+		}
+	}
+
+	c.incremental, err = parseBool(s, "SLURM_EXPORTER_INCREMENTAL_FETCH")
+	if err != nil {
+		return nil, err
+	}
+	c.fullResyncInterval = 5 * time.Minute
+	if interval, found := s.lookup("SLURM_EXPORTER_FULL_RESYNC_INTERVAL"); found {
+		c.fullResyncInterval, err = time.ParseDuration(interval)
+		if err != nil || c.fullResyncInterval <= 0 {
+			return nil, fmt.Errorf("failed to parse SLURM_EXPORTER_FULL_RESYNC_INTERVAL, it must be a duration like 5m")
+		}
+	}
+
+	c.settings = s.all()
+	return c, nil
+}
+
+// newOTLPConfig returns the OTLP push configured by the
+// SLURM_EXPORTER_OTLP_* settings, or nil if no endpoint is set
+func newOTLPConfig(s *settings) (*otlp.Config, error) {
+	endpoint, found := s.lookup("SLURM_EXPORTER_OTLP_ENDPOINT")
+	if !found || endpoint == "" {
+		return nil, nil
+	}
+	c := &otlp.Config{
+		Endpoint: endpoint,
+		Protocol: otlp.ProtocolHTTP,
+		Interval: 60 * time.Second,
+	}
+	if protocol, found := s.lookup("SLURM_EXPORTER_OTLP_PROTOCOL"); found {
+		if protocol != otlp.ProtocolHTTP && protocol != otlp.ProtocolGRPC {
+			return nil, fmt.Errorf("SLURM_EXPORTER_OTLP_PROTOCOL must be one of http/protobuf or grpc\nGot: %s", protocol)
+		}
+		c.Protocol = protocol
+	}
+	if interval, found := s.lookup("SLURM_EXPORTER_OTLP_INTERVAL"); found {
+		var err error
+		c.Interval, err = time.ParseDuration(interval)
+		if err != nil || c.Interval <= 0 {
+			return nil, fmt.Errorf("failed to parse SLURM_EXPORTER_OTLP_INTERVAL, it must be a duration like 60s")
+		}
+	}
+	if headers, found := s.lookup("SLURM_EXPORTER_OTLP_HEADERS"); found {
+		var err error
+		c.Headers, err = otlp.ParseHeaders(headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SLURM_EXPORTER_OTLP_HEADERS: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// status returns the effective configuration for the status page, with the
+// secrets redacted
+func (c *config) status() map[string]string {
+	status := map[string]string{
+		"listen_address":        c.listenAddress,
+		"api_url":               c.apiURL,
+		"api_user":              c.apiUser,
+		"api_token":             redact(c.apiToken),
+		"enable_tls":            strconv.FormatBool(c.tlsEnable),
+		"tls_cert_path":         c.tlsCert,
+		"tls_key_path":          c.tlsKey,
+		"per_job_metrics":       strconv.FormatBool(c.perJobMetrics),
+		"metric_schema":         string(c.metricSchema),
+		"record_dir":            c.recordDir,
+		"replay_dir":            c.replayDir,
+		"record_anonymize":      strconv.FormatBool(c.anonymize),
+		"web_config_file":       c.webConfigFile,
+		"web_bearer_token_file": c.bearerTokenFile,
+		"web_enable_lifecycle":  strconv.FormatBool(c.enableLifecycle),
+		"min_refresh_interval":  c.minRefreshInterval.String(),
+		"incremental_fetch":     strconv.FormatBool(c.incremental),
+		"full_resync_interval":  c.fullResyncInterval.String(),
+	}
+	// the label and filter settings are reported as they were set
+	for k, v := range c.settings {
+		k = strings.ToLower(strings.TrimPrefix(k, "SLURM_EXPORTER_"))
+		if _, found := status[k]; found {
+			continue
+		}
+		if strings.Contains(k, "secret") || strings.Contains(k, "token") || strings.Contains(k, "headers") {
+			v = redact(v)
+		}
+		status[k] = v
+	}
+	return status
+}
+
+// redact hides a secret in the status page, while still showing if it is set
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+// newLabelTransform returns the label transform configured by the setting
+// modeKey, or nil if the names should be kept. The hash secret and lookup
+// file are shared by the user and account labels.
+func newLabelTransform(s *settings, modeKey string) (*types.LabelTransform, error) {
+	modeString, found := s.lookup(modeKey)
+	if !found {
+		return nil, nil
+	}
+	mode, err := types.ParseLabelMode(modeString)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be one of keep, hash, map, or drop\nGot: %s", modeKey, modeString)
+	}
+	if mode == types.LabelModeKeep {
+		return nil, nil
+	}
+
+	var secret []byte
+	if path, found := s.lookup("SLURM_EXPORTER_LABEL_HASH_SECRET_FILE"); found {
+		secret, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SLURM_EXPORTER_LABEL_HASH_SECRET_FILE: %v", err)
+		}
+		secret = []byte(strings.TrimSpace(string(secret)))
+	} else {
+		v, _ := s.lookup("SLURM_EXPORTER_LABEL_HASH_SECRET")
+		secret = []byte(v)
+	}
+
+	var mapping map[string]string
+	if mode == types.LabelModeMap {
+		path, found := s.lookup("SLURM_EXPORTER_LABEL_MAP_FILE")
+		if !found {
+			return nil, fmt.Errorf("you must set SLURM_EXPORTER_LABEL_MAP_FILE when %s is map", modeKey)
+		}
+		mapping, err = types.ReadLabelMap(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := types.NewLabelTransform(mode, secret, mapping)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", modeKey, err)
+	}
+	return t, nil
+}
+
+// newSeriesFilter returns the series filter configured for the collector by
+// the SLURM_EXPORTER_<COLLECTOR>_{INCLUDE,EXCLUDE,LIMIT} settings, or nil if
+// none of them are set.
+func newSeriesFilter(s *settings, collector string) (*types.SeriesFilter, error) {
+	prefix := "SLURM_EXPORTER_" + strings.ToUpper(collector)
+	include, includeFound := s.lookup(prefix + "_INCLUDE")
+	exclude, excludeFound := s.lookup(prefix + "_EXCLUDE")
+	limitString, limitFound := s.lookup(prefix + "_LIMIT")
+	if !includeFound && !excludeFound && !limitFound {
+		return nil, nil
+	}
+	var limit int
+	if limitFound {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s_LIMIT, it must be a number", prefix)
+		}
+	}
+	f, err := types.NewSeriesFilter(include, exclude, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", prefix, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "env.conf")
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `# slurmrestd
+SLURM_EXPORTER_API_URL="http://head.domain.edu:6820"
+export SLURM_EXPORTER_API_USER=root
+
+SLURM_EXPORTER_API_TOKEN='my token'
+SLURM_EXPORTER_USERS_INCLUDE=a=b
+`)
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatalf("failed to read config file: %v", err)
+	}
+	expected := map[string]string{
+		"SLURM_EXPORTER_API_URL":       "http://head.domain.edu:6820",
+		"SLURM_EXPORTER_API_USER":      "root",
+		"SLURM_EXPORTER_API_TOKEN":     "my token",
+		"SLURM_EXPORTER_USERS_INCLUDE": "a=b",
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d values, got %v", len(expected), values)
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("expected %s=%q, got %q", k, v, values[k])
+		}
+	}
+
+	_, err = readConfigFile(writeConfigFile(t, "SLURM_EXPORTER_API_URL\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected an error for a line without a value, got %v", err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	t.Setenv("SLURM_EXPORTER_API_URL", "unix:///env.sock")
+	t.Setenv("SLURM_EXPORTER_LISTEN_ADDRESS", "127.0.0.1:9000")
+	t.Setenv("SLURM_EXPORTER_RECORD_DIR", "/env")
+	path := writeConfigFile(t, "SLURM_EXPORTER_API_URL=unix:///file.sock\nSLURM_EXPORTER_RECORD_DIR=/file\n")
+
+	s, err := newSettings(path, map[string]string{"SLURM_EXPORTER_RECORD_DIR": "/flag"})
+	if err != nil {
+		t.Fatalf("failed to read settings: %v", err)
+	}
+	c, err := loadConfig(s)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if c.listenAddress != "127.0.0.1:9000" {
+		t.Errorf("expected the listen address from the environment, got %s", c.listenAddress)
+	}
+	if c.apiURL != "unix:///file.sock" {
+		t.Errorf("expected the api url from the config file, got %s", c.apiURL)
+	}
+	if c.recordDir != "/flag" {
+		t.Errorf("expected the record dir from the flag, got %s", c.recordDir)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"SLURM_EXPORTER_API_URL=localhost:6820":                                             "must start with",
+		"SLURM_EXPORTER_API_URL=http://localhost:6820":                                      "SLURM_EXPORTER_API_USER",
+		"SLURM_EXPORTER_API_URL=unix:///slurm.sock\nSLURM_EXPORTER_PER_JOB_METRICS=yes":     "SLURM_EXPORTER_PER_JOB_METRICS",
+		"SLURM_EXPORTER_API_URL=unix:///slurm.sock\nSLURM_EXPORTER_METRIC_SCHEMA=v3":        "SLURM_EXPORTER_METRIC_SCHEMA",
+		"SLURM_EXPORTER_API_URL=unix:///slurm.sock\nSLURM_EXPORTER_USERS_LIMIT=ten":         "SLURM_EXPORTER_USERS_LIMIT",
+		"SLURM_EXPORTER_API_URL=unix:///slurm.sock\nSLURM_EXPORTER_FULL_RESYNC_INTERVAL=0s": "SLURM_EXPORTER_FULL_RESYNC_INTERVAL",
+	}
+	for content, expected := range tests {
+		s, err := newSettings(writeConfigFile(t, content), nil)
+		if err != nil {
+			t.Fatalf("failed to read settings: %v", err)
+		}
+		_, err = loadConfig(s)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error mentioning %s for %q, got %v", expected, content, err)
+		}
+	}
+}
+
+func TestLoadConfigSeriesFilters(t *testing.T) {
+	overrides := map[string]string{"SLURM_EXPORTER_API_URL": "unix:///slurm.sock"}
+	collectors := []string{"users", "accounts", "nodes", "partitions"}
+	for _, c := range collectors {
+		overrides["SLURM_EXPORTER_"+strings.ToUpper(c)+"_LIMIT"] = "10"
+	}
+	s, err := newSettings("", overrides)
+	if err != nil {
+		t.Fatalf("failed to read settings: %v", err)
+	}
+	c, err := loadConfig(s)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	for _, collector := range collectors {
+		if c.seriesFilters[collector] == nil {
+			t.Errorf("expected a series filter for the %s collector, got %v", collector, c.seriesFilters)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/slurm"
+	"github.com/prometheus/common/expfmt"
+)
+
+// runDump handles the `dump` subcommand and returns the exit code. It polls
+// slurmrestd once, with the same configuration as the exporter, and prints
+// the metrics or a summary of the cluster.
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	format := fs.String("format", "prom", "output format, prom for the metrics, json or table for a summary")
+	textfile := fs.String("textfile", "", "write the metrics to this file for the node_exporter textfile collector instead of stdout")
+	configFile := fs.String("config.file", os.Getenv("SLURM_EXPORTER_CONFIG_FILE"), "path to a file of SLURM_EXPORTER_* settings")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "prom" && *format != "json" && *format != "table" {
+		fmt.Fprintln(os.Stderr, "-format must be one of prom, json, or table")
+		return 2
+	}
+	if *textfile != "" && *format != "prom" {
+		fmt.Fprintln(os.Stderr, "-textfile can only be used with -format prom")
+		return 2
+	}
+
+	// the output goes to stdout, so log to stderr
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	s := newServer(*configFile, nil)
+	cfg, err := s.loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	e, err := s.newExporter(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	write := func(w io.Writer) error { return dump(e, *format, w) }
+	if *textfile != "" {
+		err = writeTextfile(*textfile, write)
+	} else {
+		err = write(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// the output of the endpoints that succeeded is still written, but
+	// cron should know the poll wasn't complete
+	if !s.status.Ready() {
+		fmt.Fprintln(os.Stderr, "Failed to poll every slurmrestd endpoint")
+		return 1
+	}
+	return 0
+}
+
+// dump polls slurmrestd once and writes the output in format
+func dump(e *exporter, format string, w io.Writer) error {
+	err := api.PopulateCache(e.ctx)
+	if err != nil {
+		slog.Error("error populating request cache", "error", err)
+	}
+	defer api.WipeCache(e.ctx)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e.snapshots.Snapshot())
+	case "table":
+		return writeTable(w, e.snapshots.Snapshot())
+	}
+	mfs, err := e.registry.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, mf := range mfs {
+		err := enc.Encode(mf)
+		if err != nil {
+			return fmt.Errorf("failed to encode metrics: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeTextfile writes to a temporary file next to path and renames it over
+// path, so the textfile collector never reads a partially written file
+func writeTextfile(path string, write func(w io.Writer) error) error {
+	// the textfile collector only reads *.prom files, so the temporary
+	// file is ignored until it's renamed
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create textfile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set textfile permissions: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write textfile: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write textfile: %v", err)
+	}
+	return nil
+}
+
+// writeTable writes the snapshot as tables for reading in a terminal
+func writeTable(w io.Writer, snapshot slurm.Snapshot) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if n := snapshot.Nodes; n != nil {
+		fmt.Fprintln(tw, "NODE STATE\tNODES")
+		for _, state := range sortedKeys(n.States) {
+			fmt.Fprintf(tw, "%s\t%.0f\n", state, n.States[state])
+		}
+		fmt.Fprintln(tw)
+	}
+	if p := snapshot.Partitions; p != nil {
+		fmt.Fprintln(tw, "PARTITION\tCPUS ALLOC\tCPUS IDLE\tCPUS OTHER\tCPUS TOTAL\tJOBS PENDING")
+		for _, name := range sortedKeys(p.Partitions) {
+			pm := p.Partitions[name]
+			fmt.Fprintf(tw, "%s\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\n", name, pm.CPUsAlloc, pm.CPUsIdle, pm.CPUsOther, pm.CPUsTotal, pm.JobsPending)
+		}
+		fmt.Fprintln(tw)
+	}
+	if q := snapshot.Queue; q != nil {
+		fmt.Fprintln(tw, "JOB STATE\tJOBS")
+		for _, state := range sortedKeys(q.Jobs) {
+			fmt.Fprintf(tw, "%s\t%.0f\n", state, q.Jobs[state])
+		}
+		fmt.Fprintf(tw, "pending (dependency)\t%.0f\n", q.PendingDependency)
+		fmt.Fprintln(tw)
+	}
+	if sc := snapshot.Scheduler; sc != nil {
+		fmt.Fprintln(tw, "SCHEDULER\tVALUE")
+		fmt.Fprintf(tw, "threads\t%.0f\n", sc.Threads)
+		fmt.Fprintf(tw, "agent queue size\t%.0f\n", sc.AgentQueueSize)
+		fmt.Fprintf(tw, "dbd agent queue size\t%.0f\n", sc.DbdAgentQueueSize)
+		fmt.Fprintf(tw, "last cycle\t%.6fs\n", sc.CycleLast)
+		fmt.Fprintf(tw, "mean cycle\t%.6fs\n", sc.CycleMean)
+		fmt.Fprintf(tw, "last backfill cycle\t%.6fs\n", sc.BackfillCycleLast)
+		fmt.Fprintf(tw, "backfill active\t%t\n", sc.BackfillActive)
+	}
+	return tw.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/slurm"
+)
+
+// newDumpExporter builds the exporter the dump subcommand uses, against a
+// fake slurmrestd
+func newDumpExporter(t *testing.T) (*server, *exporter, *fakeslurm.Server) {
+	fake := fakeslurm.NewServer("slurm", "secret")
+	t.Cleanup(fake.Close)
+	t.Setenv("SLURM_EXPORTER_API_URL", fake.URL)
+	t.Setenv("SLURM_EXPORTER_API_USER", "slurm")
+	t.Setenv("SLURM_EXPORTER_API_TOKEN", "secret")
+	s := newServer("", nil)
+	cfg, err := s.loadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	e, err := s.newExporter(cfg)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+	return s, e, fake
+}
+
+func TestDumpFormats(t *testing.T) {
+	_, e, _ := newDumpExporter(t)
+
+	var prom bytes.Buffer
+	if err := dump(e, "prom", &prom); err != nil {
+		t.Fatalf("failed to dump metrics: %v", err)
+	}
+	if !strings.Contains(prom.String(), "\nslurm_cpus_total ") {
+		t.Fatalf("expected the metrics in the prom output, got %s", prom.String())
+	}
+
+	var js bytes.Buffer
+	if err := dump(e, "json", &js); err != nil {
+		t.Fatalf("failed to dump json: %v", err)
+	}
+	var snapshot slurm.Snapshot
+	if err := json.Unmarshal(js.Bytes(), &snapshot); err != nil {
+		t.Fatalf("failed to decode json output: %v", err)
+	}
+	if snapshot.Nodes == nil || snapshot.Queue == nil {
+		t.Fatalf("expected the summary in the json output, got %s", js.String())
+	}
+
+	var table bytes.Buffer
+	if err := dump(e, "table", &table); err != nil {
+		t.Fatalf("failed to dump table: %v", err)
+	}
+	for _, header := range []string{"NODE STATE", "PARTITION", "JOB STATE", "SCHEDULER"} {
+		if !strings.Contains(table.String(), header) {
+			t.Errorf("expected %s in the table output, got %s", header, table.String())
+		}
+	}
+}
+
+func TestDumpTextfile(t *testing.T) {
+	_, e, _ := newDumpExporter(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "slurm.prom")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("failed to write old textfile: %v", err)
+	}
+
+	err := writeTextfile(path, func(w io.Writer) error { return dump(e, "prom", w) })
+	if err != nil {
+		t.Fatalf("failed to write textfile: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read textfile: %v", err)
+	}
+	if !strings.Contains(string(b), "\nslurm_cpus_total ") {
+		t.Fatalf("expected the metrics in the textfile, got %s", b)
+	}
+	// the temporary file is renamed over the textfile
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected only the textfile to be left, got %v", entries)
+	}
+
+	// a failed write leaves the previous textfile in place
+	err = writeTextfile(path, func(w io.Writer) error { return fmt.Errorf("failed") })
+	if err == nil {
+		t.Fatalf("expected the write error to be returned")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, b) {
+		t.Fatalf("expected the textfile to be left alone on error")
+	}
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected the temporary file to be removed, got %v", entries)
+	}
+}
+
+func TestRunDumpExitCode(t *testing.T) {
+	_, _, fake := newDumpExporter(t)
+	path := filepath.Join(t.TempDir(), "slurm.prom")
+	if code := runDump([]string{"-textfile", path}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	fake.SetFault("diag", fakeslurm.Fault{StatusCode: 500})
+	if code := runDump([]string{"-textfile", path}); code != 1 {
+		t.Fatalf("expected exit code 1 when an endpoint fails, got %d", code)
+	}
+	if code := runDump([]string{"-format", "xml"}); code != 2 {
+		t.Fatalf("expected exit code 2 for an invalid format, got %d", code)
+	}
+}
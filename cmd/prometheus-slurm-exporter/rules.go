@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/rules"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// runRules handles the `rules` subcommand and returns the exit code
+func runRules(args []string) int {
+	if len(args) == 0 || args[0] != "generate" {
+		fmt.Println("Usage: prometheus-slurm-exporter rules generate [flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("rules generate", flag.ContinueOnError)
+	schemaString := fs.String("schema", string(types.MetricSchemaV1), "metric schema the rules are written for (v1 or v2)")
+	job := fs.String("job", "slurm_exporter", "prometheus job name the exporter is scraped as")
+	outputDir := fs.String("output-dir", ".", "directory to write the rules file and dashboard to")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	schema, err := types.ParseMetricSchema(*schemaString)
+	if err != nil || schema == types.MetricSchemaBoth {
+		fmt.Println("-schema must be one of v1 or v2")
+		return 2
+	}
+
+	groups, err := rules.Groups(schema, *job)
+	if err != nil {
+		fmt.Println("Failed to generate rules:", err)
+		return 1
+	}
+
+	rulesPath := filepath.Join(*outputDir, "slurm-exporter.rules.yml")
+	if err := writeFile(rulesPath, func(f *os.File) error { return rules.WriteRules(f, groups) }); err != nil {
+		fmt.Println("Failed to write rules:", err)
+		return 1
+	}
+	dashboardPath := filepath.Join(*outputDir, "slurm-exporter-dashboard.json")
+	if err := writeFile(dashboardPath, func(f *os.File) error { return rules.WriteDashboard(f, groups) }); err != nil {
+		fmt.Println("Failed to write dashboard:", err)
+		return 1
+	}
+	fmt.Println("Wrote", rulesPath)
+	fmt.Println("Wrote", dashboardPath)
+	return 0
+}
+
+func writeFile(path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
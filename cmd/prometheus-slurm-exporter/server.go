@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/otlp"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/slurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/sys/unix"
+)
+
+// shutdownTimeout is how long in-flight scrapes are given to finish on
+// shutdown, it is well below the 90 seconds systemd waits by default.
+const shutdownTimeout = 30 * time.Second
+
+// server serves the exporter. On reload the config is read again and a new
+// handler with the new collectors is swapped in, so the listener is never
+// closed and scrapes in flight finish with the handler they started with.
+type server struct {
+	configFile string
+	flags      map[string]string
+
+	// ctx is cancelled on shutdown, stopping any slurmrestd requests
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+
+	// the cache and status are kept across reloads
+	apiCache *api.Cache
+	status   *api.Status
+
+	mu       sync.Mutex // serializes reloads
+	cfg      *config
+	handler  atomic.Pointer[http.Handler]
+	stopPush context.CancelFunc
+}
+
+// exporter is the handler and background work built from a config
+type exporter struct {
+	handler http.Handler
+	// ctx is the context the collectors poll slurmrestd with
+	ctx       context.Context
+	registry  *prometheus.Registry
+	snapshots *slurm.SnapshotStore
+	pusher    *otlp.Pusher
+}
+
+func newServer(configFile string, flags map[string]string) *server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &server{
+		configFile: configFile,
+		flags:      flags,
+		ctx:        ctx,
+		cancel:     cancel,
+		apiCache:   api.NewCache(),
+		status:     api.NewStatus(),
+	}
+}
+
+// loadConfig reads the settings and the config file
+func (s *server) loadConfig() (*config, error) {
+	settings, err := newSettings(s.configFile, s.flags)
+	if err != nil {
+		return nil, err
+	}
+	return loadConfig(settings)
+}
+
+// newExporter builds the collectors, the handlers and the OTLP pusher for a
+// config
+func (s *server) newExporter(cfg *config) (*exporter, error) {
+	// Set up the context to pass around
+	ctx := s.ctx
+	ctx = context.WithValue(ctx, types.ApiUserKey, cfg.apiUser)
+	ctx = context.WithValue(ctx, types.ApiTokenKey, cfg.apiToken)
+	ctx = context.WithValue(ctx, types.ApiURLKey, cfg.apiURL)
+	ctx = context.WithValue(ctx, types.ApiCacheKey, s.apiCache)
+	ctx = context.WithValue(ctx, types.ApiCacheTimeoutKey, cfg.minRefreshInterval)
+	ctx = context.WithValue(ctx, types.PerJobMetricsKey, cfg.perJobMetrics)
+	ctx = context.WithValue(ctx, types.MetricSchemaKey, cfg.metricSchema)
+	ctx = context.WithValue(ctx, types.UserLabelTransformKey, cfg.userLabels)
+	ctx = context.WithValue(ctx, types.AccountLabelTransformKey, cfg.accountLabels)
+	ctx = context.WithValue(ctx, types.SeriesFiltersKey, cfg.seriesFilters)
+	ctx = context.WithValue(ctx, types.ApiStatusKey, s.status)
+	if cfg.incremental {
+		ctx = context.WithValue(ctx, types.ApiIncrementalKey, api.NewIncremental(cfg.fullResyncInterval))
+	}
+
+	// Keep the parsed cluster state of the last poll for the snapshot api
+	snapshots := slurm.NewSnapshotStore()
+	ctx = context.WithValue(ctx, types.ApiPollObserverKey, snapshots)
+
+	if cfg.recordDir != "" {
+		recorder, err := api.NewRecorder(cfg.recordDir, cfg.anonymize)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("recording slurmrestd responses", "dir", cfg.recordDir)
+		ctx = context.WithValue(ctx, types.ApiRecorderKey, recorder)
+	}
+	if cfg.replayDir != "" {
+		replayer, err := api.NewReplayer(cfg.replayDir)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("replaying slurmrestd responses", "dir", cfg.replayDir)
+		ctx = context.WithValue(ctx, types.ApiReplayerKey, replayer)
+	}
+
+	// Register all the endpoints
+	ctx = api.RegisterEndpoints(ctx)
+
+	// Register all the collectors
+	collectors := slurm.NewCollectors(ctx)
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors...)
+	// every scrape and push gathers the collectors bound to a single poll
+	gatherer := api.NewGatherer(ctx, collectors)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", api.MetricsHandler(gatherer))
+	mux.Handle("/-/healthy", api.HealthyHandler())
+	mux.Handle("/-/ready", api.ReadyHandler(ctx))
+	// without the lifecycle api the config is only reloaded on SIGHUP
+	var reload func() error
+	if cfg.enableLifecycle {
+		reload = s.reload
+	}
+	mux.Handle("/-/reload", api.ReloadHandler(reload))
+	mux.Handle("/debug/status", api.StatusHandler(ctx, cfg.status()))
+	mux.Handle("/api/v1/snapshot", slurm.SnapshotHandler(snapshots, ""))
+	mux.Handle("/api/v1/nodes", slurm.SnapshotHandler(snapshots, "nodes"))
+	mux.Handle("/api/v1/partitions", slurm.SnapshotHandler(snapshots, "partitions"))
+	mux.Handle("/api/v1/queue", slurm.SnapshotHandler(snapshots, "queue"))
+	mux.Handle("/api/v1/scheduler", slurm.SnapshotHandler(snapshots, "scheduler"))
+	mux.Handle("/", api.LandingPageHandler(ctx, version, collectors))
+
+	var handler http.Handler = mux
+	if cfg.bearerTokenFile != "" {
+		handler = api.BearerAuthHandler(handler, cfg.bearerTokenFile)
+	}
+
+	e := &exporter{handler: handler, ctx: ctx, registry: r, snapshots: snapshots}
+	if cfg.otlp != nil {
+		var err error
+		e.pusher, err = otlp.NewPusher(ctx, *cfg.otlp, gatherer, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// start serves the exporter and starts its background work, stopping the
+// OTLP push of the previous exporter
+func (s *server) start(e *exporter) {
+	s.handler.Store(&e.handler)
+	if s.stopPush != nil {
+		s.stopPush()
+		s.stopPush = nil
+	}
+	if e.pusher == nil {
+		s.checkConnection(e.ctx)
+		return
+	}
+	// the first push polls slurmrestd straight away
+	ctx, cancel := context.WithCancel(e.ctx)
+	s.stopPush = cancel
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		e.pusher.Run(ctx)
+	}()
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, r)
+}
+
+// checkConnection polls slurmrestd in the background, so the readiness
+// endpoint is accurate before the first scrape
+func (s *server) checkConnection(ctx context.Context) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		err := api.CheckConnection(ctx)
+		if err != nil && s.ctx.Err() == nil {
+			slog.Error("failed to poll slurmrestd", "error", err)
+		}
+	}()
+}
+
+// reload reads the config again and swaps in a new handler. If the config is
+// invalid the exporter keeps running with the previous one.
+func (s *server) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notifyReloading()
+	defer notify(daemon.SdNotifyReady)
+
+	cfg, err := s.loadConfig()
+	if err != nil {
+		slog.Error("failed to reload config", "error", err)
+		return err
+	}
+	// the listener is kept open, so these only change on restart
+	if cfg.listenAddress != s.cfg.listenAddress || cfg.tlsEnable != s.cfg.tlsEnable ||
+		cfg.tlsCert != s.cfg.tlsCert || cfg.tlsKey != s.cfg.tlsKey || cfg.webConfigFile != s.cfg.webConfigFile {
+		slog.Warn("the listen address, TLS and web config file settings are only changed by a restart")
+		cfg.listenAddress = s.cfg.listenAddress
+		cfg.tlsEnable = s.cfg.tlsEnable
+		cfg.tlsCert = s.cfg.tlsCert
+		cfg.tlsKey = s.cfg.tlsKey
+		cfg.webConfigFile = s.cfg.webConfigFile
+	}
+	e, err := s.newExporter(cfg)
+	if err != nil {
+		slog.Error("failed to reload config", "error", err)
+		return err
+	}
+
+	s.cfg = cfg
+	s.start(e)
+	slog.Info("reloaded config")
+	return nil
+}
+
+// run serves the exporter until it receives SIGTERM or SIGINT, reloading the
+// config on SIGHUP
+func (s *server) run() error {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.webConfigFile != "" {
+		err := web.Validate(cfg.webConfigFile)
+		if err != nil {
+			return fmt.Errorf("invalid web config file: %v", err)
+		}
+	}
+	e, err := s.newExporter(cfg)
+	if err != nil {
+		return err
+	}
+	s.cfg = cfg
+	s.start(e)
+
+	// a SIGHUP sent as soon as systemd sees the exporter is ready must not
+	// kill it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	// listen before notifying systemd, so the exporter is reachable once
+	// the unit is started
+	l, err := net.Listen("tcp", cfg.listenAddress)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s}
+	errc := make(chan error, 1)
+	slog.Info("starting server", "address", cfg.listenAddress)
+	go func() {
+		if cfg.tlsEnable {
+			errc <- server.ServeTLS(l, cfg.tlsCert, cfg.tlsKey)
+			return
+		}
+		// the web config file is read again for every connection, so
+		// certificates and users can be changed without a restart
+		errc <- web.Serve(l, server, &web.FlagConfig{WebConfigFile: &cfg.webConfigFile}, slog.Default())
+	}()
+	notify(daemon.SdNotifyReady)
+
+	for {
+		select {
+		case err := <-errc:
+			s.cancel()
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				slog.Info("received SIGHUP, reloading config")
+				// errors are logged, the previous config is kept
+				_ = s.reload()
+				continue
+			}
+			slog.Info("shutting down", "signal", sig)
+			return s.shutdown(server, errc)
+		}
+	}
+}
+
+// shutdown stops accepting connections, waits for the scrapes in flight to
+// finish and then stops the background slurmrestd polls
+func (s *server) shutdown(server *http.Server, errc chan error) error {
+	notify(daemon.SdNotifyStopping)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	s.cancel()
+	s.background.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to shut down cleanly: %v", err)
+	}
+	err = <-errc
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// notify sends a state to systemd, it does nothing when the exporter isn't
+// run by a Type=notify unit
+func notify(state string) {
+	_, err := daemon.SdNotify(false, state)
+	if err != nil {
+		slog.Debug("failed to notify systemd", "state", state, "error", err)
+	}
+}
+
+// notifyReloading tells systemd a reload started, Type=notify-reload units
+// also need the time it started at
+func notifyReloading() {
+	var ts unix.Timespec
+	err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	if err != nil {
+		notify(daemon.SdNotifyReloading)
+		return
+	}
+	notify(fmt.Sprintf("%s\nMONOTONIC_USEC=%d", daemon.SdNotifyReloading, ts.Nano()/1000))
+}
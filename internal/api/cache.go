@@ -3,57 +3,375 @@ package api
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 )
 
-// PopulateCache is used to populate the cache with data from the slurm api
+// ClusterSnapshot is the cluster state parsed from one poll of slurmrestd.
+// Every response is parsed once, when it is polled, and the collectors all
+// read the same parsed data. A snapshot is never modified once it is cached,
+// so it can be read by any number of collectors at once.
+type ClusterSnapshot struct {
+	Time       time.Time
+	Diag       *DiagData
+	Jobs       *JobsData
+	Nodes      *NodesData
+	Partitions *PartitionsData
+	Shares     *SharesData
+	// Errors holds the error polling or parsing each endpoint that failed,
+	// keyed by endpoint name. The data of a failed endpoint is nil.
+	Errors map[string]error
+}
+
+// Err returns the reason the data of an endpoint is missing from the
+// snapshot, or nil if it was polled successfully
+func (s *ClusterSnapshot) Err(name string) error {
+	if err, ok := s.Errors[name]; ok {
+		return err
+	}
+	if s.Time.IsZero() {
+		return fmt.Errorf("slurmrestd has not been polled")
+	}
+	return nil
+}
+
+// set parses the response of an endpoint into the snapshot
+func (s *ClusterSnapshot) set(name string, data []byte) error {
+	var err error
+	switch name {
+	case "diag":
+		s.Diag, err = ProcessDiagResponse(data)
+	case "jobs":
+		s.Jobs, err = ProcessJobsResponse(data)
+	case "nodes":
+		s.Nodes, err = ProcessNodesResponse(data)
+	case "partitions":
+		s.Partitions, err = ProcessPartitionsResponse(data)
+	case "shares":
+		s.Shares, err = ProcessSharesResponse(data)
+	default:
+		err = fmt.Errorf("unknown endpoint %s", name)
+	}
+	return err
+}
+
+// decodeErrors returns the fields that couldn't be decoded in the parsed
+// response of an endpoint
+func (s *ClusterSnapshot) decodeErrors(name string) DecodeErrors {
+	switch name {
+	case "jobs":
+		return s.Jobs.DecodeErrors
+	case "nodes":
+		return s.Nodes.DecodeErrors
+	case "partitions":
+		return s.Partitions.DecodeErrors
+	case "shares":
+		return s.Shares.DecodeErrors
+	}
+	return nil
+}
+
+// merge copies the parsed data set in o into s
+func (s *ClusterSnapshot) merge(o *ClusterSnapshot) {
+	if o.Diag != nil {
+		s.Diag = o.Diag
+	}
+	if o.Jobs != nil {
+		s.Jobs = o.Jobs
+	}
+	if o.Nodes != nil {
+		s.Nodes = o.Nodes
+	}
+	if o.Partitions != nil {
+		s.Partitions = o.Partitions
+	}
+	if o.Shares != nil {
+		s.Shares = o.Shares
+	}
+}
+
+// Cache holds the snapshot of the poll made for the scrapes in flight.
+// Concurrent scrapes share a single poll, and the snapshot is dropped once
+// the last of them finishes, so a failing endpoint is never reported from
+// stale data. With a minimum refresh interval the snapshot is kept and reused
+// by the scrapes made within the interval instead.
+type Cache struct {
+	mu       sync.Mutex
+	snapshot *ClusterSnapshot
+	err      error
+	users    int
+	inflight *pollCall
+}
+
+// pollCall is a poll in progress, which the scrapes arriving during it wait
+// for instead of starting their own
+type pollCall struct {
+	done     chan struct{}
+	snapshot *ClusterSnapshot
+	err      error
+}
+
+func NewCache() *Cache {
+	return &Cache{}
+}
+
+// Snapshot returns the last snapshot, or an empty one with every endpoint
+// missing if slurmrestd hasn't been polled
+func (c *Cache) Snapshot() *ClusterSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshot == nil {
+		return &ClusterSnapshot{}
+	}
+	return c.snapshot
+}
+
+// acquire makes sure the snapshot is fresh, polling slurmrestd with pollCtx
+// unless a poll is already in flight or the snapshot is younger than
+// minRefresh, and counts the scrape using it. The poll is shared by every
+// scrape waiting for it, so it is never cancelled with the scrape that
+// started it. It returns the snapshot the scrape must read, which is nil if
+// ctx is done before the poll finishes.
+func (c *Cache) acquire(ctx context.Context, pollCtx context.Context, minRefresh time.Duration) (*ClusterSnapshot, error) {
+	c.mu.Lock()
+	// the scrape is counted straight away, so a scrape finishing while
+	// this one waits for the poll doesn't drop the snapshot
+	c.users++
+	if c.snapshot != nil && minRefresh > 0 && time.Since(c.snapshot.Time) < minRefresh {
+		snapshot, err := c.snapshot, c.err
+		c.mu.Unlock()
+		return snapshot, err
+	}
+	call := c.inflight
+	if call == nil {
+		call = &pollCall{done: make(chan struct{})}
+		c.inflight = call
+		go c.poll(pollCtx, call, minRefresh)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.snapshot, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// poll polls slurmrestd for the call and caches its snapshot, unless every
+// scrape waiting for it was cancelled and it would be released straight away
+func (c *Cache) poll(ctx context.Context, call *pollCall, minRefresh time.Duration) {
+	snapshot, err := poll(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight = nil
+	if c.users > 0 || minRefresh > 0 {
+		c.snapshot = snapshot
+		c.err = err
+	}
+	call.snapshot = snapshot
+	call.err = err
+	close(call.done)
+}
+
+// release drops the snapshot once no scrape is using it, unless it is kept
+// for the minimum refresh interval
+func (c *Cache) release(minRefresh time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users > 0 {
+		c.users--
+	}
+	if c.users == 0 && minRefresh <= 0 {
+		c.snapshot = nil
+		c.err = nil
+	}
+}
+
+// CurrentSnapshot returns the snapshot bound to ctx by BindSnapshot, or the
+// snapshot cached for the scrapes in flight if none is bound
+func CurrentSnapshot(ctx context.Context) *ClusterSnapshot {
+	if snapshot, ok := ctx.Value(types.ApiSnapshotKey).(*ClusterSnapshot); ok {
+		if snapshot == nil {
+			return &ClusterSnapshot{}
+		}
+		return snapshot
+	}
+	apiCache, ok := ctx.Value(types.ApiCacheKey).(*Cache)
+	if !ok {
+		return &ClusterSnapshot{}
+	}
+	return apiCache.Snapshot()
+}
+
+// PopulateCache polls slurmrestd and caches the parsed snapshot for the
+// collectors. Every call must be followed by a call to WipeCache once the
+// metrics are collected.
 func PopulateCache(ctx context.Context) error {
+	_, err := BindSnapshot(ctx)
+	return err
+}
+
+// BindSnapshot populates the cache like PopulateCache and returns ctx with
+// the snapshot of the poll bound to it, so the collectors reading it all see
+// the same poll even if another scrape polls slurmrestd while they collect.
+// Every call must be followed by a call to WipeCache.
+func BindSnapshot(ctx context.Context) (context.Context, error) {
+	return bindSnapshot(ctx, ctx)
+}
+
+// bindSnapshot is BindSnapshot, polling slurmrestd with pollCtx and waiting
+// for the poll with ctx, so a cancelled scrape doesn't fail the poll shared
+// with the other scrapes
+func bindSnapshot(ctx context.Context, pollCtx context.Context) (context.Context, error) {
 	slog.Debug("populating cache")
-	var data []byte
-	var err error
+	apiCache := ctx.Value(types.ApiCacheKey).(*Cache)
+	minRefresh, _ := ctx.Value(types.ApiCacheTimeoutKey).(time.Duration)
 
-	apiCache := ctx.Value(types.ApiCacheKey).(*cache.Cache)
+	// failed endpoints are missing from the snapshot, so their collectors
+	// report nothing instead of using stale data
+	snapshot, err := apiCache.acquire(ctx, pollCtx, minRefresh)
+	ctx = context.WithValue(ctx, types.ApiSnapshotKey, snapshot)
+	if err != nil {
+		return ctx, err
+	}
+
+	slog.Debug("finished populating cache")
+
+	return ctx, nil
+}
+
+// CheckConnection polls every endpoint without populating the cache, so the
+// readiness of the exporter is known before the first scrape
+func CheckConnection(ctx context.Context) error {
+	_, err := poll(ctx)
+	return err
+}
+
+// PollObserver is given the snapshot of every poll
+type PollObserver interface {
+	Observe(snapshot *ClusterSnapshot)
+}
+
+// poll fetches every endpoint, from slurmrestd or the replayed recording, and
+// parses the responses into a snapshot. The returned error lists every
+// endpoint that failed, the snapshot holds the ones that succeeded.
+func poll(ctx context.Context) (*ClusterSnapshot, error) {
+	recorder, _ := ctx.Value(types.ApiRecorderKey).(*Recorder)
+	replayer, _ := ctx.Value(types.ApiReplayerKey).(*Replayer)
+	incremental, _ := ctx.Value(types.ApiIncrementalKey).(*Incremental)
+	if recorder != nil || replayer != nil {
+		// recordings hold the full responses, so they can be replayed
+		// on their own
+		incremental = nil
+	}
+	status := statusFromContext(ctx)
+	var replayPoll string
+	if replayer != nil {
+		replayPoll = replayer.Next()
+		slog.Debug("replaying recorded poll", "poll", replayPoll)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(len(endpoints))
-	errors := make(chan error, len(endpoints))
+	var mu sync.Mutex
+	responses := make(map[string][]byte)
+	snapshot := &ClusterSnapshot{Errors: make(map[string]error)}
 
 	for _, e := range endpoints {
 		go func(e endpoint) {
 			defer wg.Done()
-			data, err = GetSlurmRestResponse(ctx, e.key)
+			var data []byte
+			var parsed ClusterSnapshot
+			var err error
+			start := time.Now()
+			query, full := incremental.query(e.name, start)
+			streamed := false
+			switch {
+			case replayer != nil:
+				data, err = replayer.Read(replayPoll, e.name)
+			case e.name == "jobs" && recorder == nil:
+				// the jobs response can be hundreds of megabytes on
+				// large clusters, so it is decoded as it is read
+				// unless the raw response has to be recorded
+				streamed = true
+				err = StreamSlurmRestResponse(ctx, e.key, query, func(r io.Reader) error {
+					var err error
+					parsed.Jobs, err = DecodeJobsResponse(r)
+					return err
+				})
+			default:
+				data, err = getSlurmRestResponse(ctx, e.key, query)
+			}
+			if status != nil {
+				status.record(e.name, data, err, time.Since(start))
+			}
 			if err != nil {
-				errors <- fmt.Errorf("failed to get slurmrestd %s response: %v", e.path, err)
+				mu.Lock()
+				snapshot.Errors[e.name] = fmt.Errorf("failed to get slurmrestd %s response: %v", e.path, err)
+				mu.Unlock()
+				return
+			}
+			// each endpoint sets its own field, the parsing is done
+			// concurrently and only the maps need the lock
+			if !streamed {
+				err = parsed.set(e.name, data)
 			}
-			apiCache.Set(e.name, data, 0)
+			if err == nil {
+				if status != nil {
+					status.recordDecodeErrors(e.name, parsed.decodeErrors(e.name))
+				}
+				incremental.merge(e.name, &parsed, full, start)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if data != nil {
+				responses[e.name] = data
+			}
+			if err != nil {
+				snapshot.Errors[e.name] = fmt.Errorf("failed to process slurmrestd %s response: %v", e.path, err)
+				return
+			}
+			snapshot.merge(&parsed)
 		}(e)
 	}
 
 	wg.Wait()
-	close(errors)
+	snapshot.Time = time.Now()
 
-	var errmsgs []string
-	for err := range errors {
-		errmsgs = append(errmsgs, err.Error())
-		return fmt.Errorf("error(s) encountered calling slurm api: [%s]", strings.Join(errmsgs, ", "))
+	if recorder != nil {
+		err := recorder.Record(responses)
+		if err != nil {
+			slog.Error("failed to record slurmrestd responses", "error", err)
+		}
+	}
+	if observer, ok := ctx.Value(types.ApiPollObserverKey).(PollObserver); ok {
+		observer.Observe(snapshot)
 	}
 
-	slog.Debug("finished populating cache")
+	if len(snapshot.Errors) > 0 {
+		var errmsgs []string
+		for _, e := range endpoints {
+			if err, ok := snapshot.Errors[e.name]; ok {
+				errmsgs = append(errmsgs, err.Error())
+			}
+		}
+		return snapshot, fmt.Errorf("error(s) encountered calling slurm api: [%s]", strings.Join(errmsgs, ", "))
+	}
 
-	return nil
+	return snapshot, nil
 }
 
+// WipeCache releases the snapshot cached by PopulateCache
 func WipeCache(ctx context.Context) error {
-	apiCache := ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	apiCache.Delete("diag")
-	apiCache.Delete("nodes")
-	apiCache.Delete("jobs")
-	apiCache.Delete("partitions")
-	apiCache.Delete("shares")
+	apiCache := ctx.Value(types.ApiCacheKey).(*Cache)
+	minRefresh, _ := ctx.Value(types.ApiCacheTimeoutKey).(time.Duration)
+	apiCache.release(minRefresh)
 	return nil
 }
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestPopulateCacheSnapshot(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	s.SetFault("shares", fakeslurm.Fault{StatusCode: 500})
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+
+	if err := CurrentSnapshot(ctx).Err("jobs"); err == nil {
+		t.Fatalf("expected an error before the first poll")
+	}
+	if err := PopulateCache(ctx); err == nil {
+		t.Fatalf("expected an error polling a failing endpoint")
+	}
+	snapshot := CurrentSnapshot(ctx)
+	if snapshot.Jobs == nil || snapshot.Nodes == nil || snapshot.Err("jobs") != nil {
+		t.Fatalf("expected the endpoints that succeeded in the snapshot, got %+v", snapshot)
+	}
+	// a failed endpoint is missing, never stale
+	if snapshot.Shares != nil || snapshot.Err("shares") == nil {
+		t.Fatalf("expected shares to be missing with an error, got %+v", snapshot)
+	}
+	WipeCache(ctx)
+	if CurrentSnapshot(ctx).Jobs != nil {
+		t.Fatalf("expected the snapshot to be dropped after the scrape")
+	}
+}
+
+func TestCacheConcurrentScrapes(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+
+	// a scrape finishing must not drop the snapshot another scrape is
+	// still collecting from
+	PopulateCache(ctx)
+	PopulateCache(ctx)
+	WipeCache(ctx)
+	if CurrentSnapshot(ctx).Jobs == nil {
+		t.Fatalf("expected the snapshot to be kept for the scrape in flight")
+	}
+	WipeCache(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			PopulateCache(ctx)
+			if CurrentSnapshot(ctx).Jobs == nil {
+				t.Errorf("expected jobs in the snapshot of a scrape in flight")
+			}
+			WipeCache(ctx)
+		}()
+	}
+	wg.Wait()
+	if CurrentSnapshot(ctx).Jobs != nil {
+		t.Fatalf("expected the snapshot to be dropped after the last scrape")
+	}
+}
+
+func TestBindSnapshot(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+
+	// a scrape keeps reading its own poll when a concurrent scrape polls
+	// again while it collects
+	first, err := BindSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("failed to poll: %v", err)
+	}
+	second, err := BindSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("failed to poll: %v", err)
+	}
+	if CurrentSnapshot(first) == CurrentSnapshot(second) {
+		t.Fatalf("expected each scrape to be bound to its own poll")
+	}
+	if CurrentSnapshot(first).Jobs == nil {
+		t.Fatalf("expected the bound snapshot to have jobs")
+	}
+	WipeCache(first)
+	WipeCache(second)
+	if CurrentSnapshot(first).Jobs == nil {
+		t.Fatalf("expected the bound snapshot to outlive the cache")
+	}
+}
+
+func TestBindSnapshotCancelled(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	s.SetFault("jobs", fakeslurm.Fault{Delay: 500 * time.Millisecond})
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	apiCache := NewCache()
+	ctx = context.WithValue(ctx, types.ApiCacheKey, apiCache)
+
+	polled := make(chan struct{})
+	go func() {
+		PopulateCache(ctx)
+		WipeCache(ctx)
+		close(polled)
+	}()
+	for {
+		apiCache.mu.Lock()
+		inflight := apiCache.inflight != nil
+		apiCache.mu.Unlock()
+		if inflight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a scrape waiting for the poll of another one gives up when its
+	// context is done
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	bound, err := BindSnapshot(waitCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+	select {
+	case <-polled:
+		t.Fatalf("expected the wait to end before the poll")
+	default:
+	}
+	if CurrentSnapshot(bound).Err("jobs") == nil {
+		t.Fatalf("expected an empty snapshot for a cancelled scrape")
+	}
+	WipeCache(bound)
+	<-polled
+}
+
+func TestBindSnapshotLeaderCancelled(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	s.SetFault("jobs", fakeslurm.Fault{Delay: 100 * time.Millisecond})
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	apiCache := NewCache()
+	ctx = context.WithValue(ctx, types.ApiCacheKey, apiCache)
+
+	// the scrape starting the poll is cancelled while another one waits
+	// for it, which must still get the data
+	leaderCtx, cancel := context.WithCancel(ctx)
+	leader := make(chan error)
+	go func() {
+		bound, err := bindSnapshot(leaderCtx, ctx)
+		WipeCache(bound)
+		leader <- err
+	}()
+	for {
+		apiCache.mu.Lock()
+		inflight := apiCache.inflight != nil
+		apiCache.mu.Unlock()
+		if inflight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled scrape to stop waiting, got %v", err)
+	}
+
+	bound, err := BindSnapshot(ctx)
+	if err != nil {
+		t.Fatalf("expected the shared poll to succeed, got %v", err)
+	}
+	if CurrentSnapshot(bound).Jobs == nil {
+		t.Fatalf("expected jobs in the snapshot of the waiting scrape")
+	}
+	if n := s.Requests("jobs"); n != 1 {
+		t.Fatalf("expected the waiting scrape to share the poll, got %d jobs requests", n)
+	}
+	WipeCache(bound)
+}
@@ -7,25 +7,74 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
-func beforeCollect(ctx context.Context) {
-	err := PopulateCache(ctx)
-	if err != nil {
-		slog.Error("error populating request cache", "error", err)
-	}
+// ScrapeCollector is a collector that reads the cluster state from the
+// snapshot bound to its context, so it can be bound to a single scrape
+type ScrapeCollector interface {
+	prometheus.Collector
+	// WithContext returns a copy of the collector using ctx
+	WithContext(ctx context.Context) prometheus.Collector
+}
+
+// Gatherer gathers the collectors once per scrape. Every gather polls
+// slurmrestd, or reuses the cached poll, and binds the snapshot to every
+// collector, so a scrape never mixes the data of two polls when a concurrent
+// scrape polls slurmrestd while it is collected.
+type Gatherer struct {
+	ctx        context.Context
+	collectors []prometheus.Collector
+}
+
+// NewGatherer returns a Gatherer of the collectors, polling slurmrestd with
+// the exporter values in ctx
+func NewGatherer(ctx context.Context, collectors []prometheus.Collector) *Gatherer {
+	return &Gatherer{ctx: ctx, collectors: collectors}
 }
 
-func afterCollect(ctx context.Context) {
-	WipeCache(ctx)
+// Gather polls slurmrestd and gathers the collectors with the exporter
+// context
+func (g *Gatherer) Gather() ([]*dto.MetricFamily, error) {
+	return g.gather(g.ctx)
 }
 
-func MetricsHandler(r *prometheus.Registry, ctx context.Context) http.HandlerFunc {
-	h := promhttp.HandlerFor(r, promhttp.HandlerOpts{})
+// gather waits for a poll of slurmrestd with ctx, which must carry the
+// exporter values, and gathers the collectors bound to the snapshot of the
+// poll. The poll itself is made with the exporter context, as it is shared
+// with the concurrent scrapes.
+func (g *Gatherer) gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	ctx, err := bindSnapshot(ctx, g.ctx)
+	defer WipeCache(ctx)
+	if err != nil {
+		// the collectors of the endpoints that succeeded still report
+		slog.Error("error populating request cache", "error", err)
+	}
+	r := prometheus.NewRegistry()
+	for _, c := range g.collectors {
+		if sc, ok := c.(ScrapeCollector); ok {
+			c = sc.WithContext(ctx)
+		}
+		if err := r.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return r.Gather()
+}
 
+// MetricsHandler serves the metrics of the gatherer. A scrape stops waiting
+// for a poll once its request is cancelled.
+func MetricsHandler(g *Gatherer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		beforeCollect(ctx)
-		h.ServeHTTP(w, r)
-		afterCollect(ctx)
+		// the scrape context carries the exporter values, and is
+		// cancelled with the request or the exporter
+		ctx, cancel := context.WithCancel(g.ctx)
+		defer cancel()
+		stop := context.AfterFunc(r.Context(), cancel)
+		defer stop()
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return g.gather(ctx)
+		})
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 	}
 }
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"log/slog"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Incremental keeps the jobs and nodes from the previous poll, and fetches
+// them with the update_time parameter of the jobs and nodes endpoints.
+// slurmctld answers all or nothing: no records when nothing changed since
+// update_time, so the kept ones are reused, or else every record, which
+// replaces them. Everything is fetched without update_time every resync
+// interval, in case a change isn't reported.
+type Incremental struct {
+	resync time.Duration
+
+	mu    sync.Mutex
+	jobs  *incrementalTable[JobData]
+	nodes *incrementalTable[NodeData]
+}
+
+// NewIncremental returns an Incremental making a full fetch every resync
+func NewIncremental(resync time.Duration) *Incremental {
+	return &Incremental{
+		resync: resync,
+		jobs:   newIncrementalTable[JobData](),
+		nodes:  newIncrementalTable[NodeData](),
+	}
+}
+
+// incrementalTable is the last full list of the records of an endpoint. The
+// records are never modified in place, as they may still be read by the
+// collectors.
+type incrementalTable[V any] struct {
+	records []V
+	// lastUpdate is the update_time of the next incremental fetch
+	lastUpdate int64
+	lastFull   time.Time
+}
+
+func newIncrementalTable[V any]() *incrementalTable[V] {
+	return &incrementalTable[V]{}
+}
+
+// query returns the query for the next fetch and whether it is a full fetch
+func (t *incrementalTable[V]) query(now time.Time, resync time.Duration) (url.Values, bool) {
+	if t.records == nil || t.lastUpdate == 0 || now.Sub(t.lastFull) >= resync {
+		return nil, true
+	}
+	return url.Values{"update_time": {strconv.FormatInt(t.lastUpdate, 10)}}, false
+}
+
+// merge returns the records of the endpoint. The fetched records replace the
+// table unless an incremental fetch returned none, when nothing changed since
+// the last one and the kept records are returned.
+func (t *incrementalTable[V]) merge(records []V, lastUpdate int64, full bool, start time.Time) []V {
+	if !full && len(records) == 0 {
+		return t.records
+	}
+	// fall back to the time the fetch started when slurmrestd doesn't
+	// report the last update, changes made during the fetch are fetched
+	// again rather than missed
+	if lastUpdate > 0 {
+		t.lastUpdate = lastUpdate
+	} else {
+		t.lastUpdate = start.Unix()
+	}
+	if records == nil {
+		records = []V{}
+	}
+	t.records = records
+	if full {
+		t.lastFull = start
+	}
+	return records
+}
+
+// query returns the query for the next fetch of an endpoint and whether it is
+// a full fetch. Only the jobs and nodes are fetched incrementally.
+func (inc *Incremental) query(name string, now time.Time) (url.Values, bool) {
+	if inc == nil {
+		return nil, true
+	}
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	switch name {
+	case "jobs":
+		return inc.jobs.query(now, inc.resync)
+	case "nodes":
+		return inc.nodes.query(now, inc.resync)
+	}
+	return nil, true
+}
+
+// merge merges the records fetched for an endpoint with the query returned
+// by query, and replaces them in the snapshot with the kept records when an
+// incremental fetch returned none
+func (inc *Incremental) merge(name string, parsed *ClusterSnapshot, full bool, start time.Time) {
+	if inc == nil {
+		return
+	}
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	switch name {
+	case "jobs":
+		jobs := *parsed.Jobs
+		jobs.Jobs = inc.jobs.merge(parsed.Jobs.Jobs, parsed.Jobs.LastUpdate, full, start)
+		slog.Debug("merged jobs", "full", full, "fetched", len(parsed.Jobs.Jobs), "total", len(jobs.Jobs))
+		parsed.Jobs = &jobs
+	case "nodes":
+		nodes := *parsed.Nodes
+		nodes.Nodes = inc.nodes.merge(parsed.Nodes.Nodes, parsed.Nodes.LastUpdate, full, start)
+		slog.Debug("merged nodes", "full", full, "fetched", len(parsed.Nodes.Nodes), "total", len(nodes.Nodes))
+		parsed.Nodes = &nodes
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestIncrementalTableMerge(t *testing.T) {
+	table := newIncrementalTable[JobData]()
+	start := time.Unix(1000, 0)
+	if _, full := table.query(start, time.Hour); !full {
+		t.Fatalf("expected the first fetch to be full")
+	}
+
+	first := table.merge([]JobData{
+		{JobId: 1, JobState: types.JobStatePending},
+		{JobId: 2, JobState: types.JobStatePending},
+		{JobId: 3, JobState: types.JobStateRunning},
+	}, 900, true, start)
+
+	query, full := table.query(start.Add(time.Minute), time.Hour)
+	if full || query.Get("update_time") != "900" {
+		t.Fatalf("expected an incremental fetch from the last update, got %v full=%t", query, full)
+	}
+	// nothing changed, the kept jobs are reused
+	merged := table.merge(nil, 0, false, start.Add(time.Minute))
+	if len(merged) != 3 || &merged[0] != &first[0] {
+		t.Fatalf("expected the kept jobs when nothing changed, got %+v", merged)
+	}
+	if query, _ := table.query(start.Add(time.Minute), time.Hour); query.Get("update_time") != "900" {
+		t.Fatalf("expected update_time to be kept when nothing changed, got %v", query)
+	}
+
+	// something changed, slurmctld returns every job, dropping the purged ones
+	merged = table.merge([]JobData{
+		{JobId: 2, JobState: types.JobStateRunning},
+		{JobId: 4, JobState: types.JobStatePending},
+	}, 0, false, start.Add(time.Minute))
+	if len(merged) != 2 || merged[0].JobState != types.JobStateRunning || merged[1].JobId != 4 {
+		t.Fatalf("expected the fetched jobs to replace the kept ones, got %+v", merged)
+	}
+	// the previous view may still be read by a scrape
+	if first[1].JobState != types.JobStatePending {
+		t.Fatalf("expected the previous view not to be modified")
+	}
+	// without a last update from slurmrestd the fetch start time is used
+	if query, _ := table.query(start.Add(2*time.Minute), time.Hour); query.Get("update_time") != "1060" {
+		t.Fatalf("expected update_time to be the start of the last fetch, got %v", query)
+	}
+
+	if _, full := table.query(start.Add(2*time.Hour), time.Hour); !full {
+		t.Fatalf("expected a full fetch after the resync interval")
+	}
+	merged = table.merge([]JobData{{JobId: 4}}, 0, true, start.Add(2*time.Hour))
+	if len(merged) != 1 {
+		t.Fatalf("expected a full fetch to replace every job, got %+v", merged)
+	}
+}
+
+func TestIncrementalPoll(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	jobs, _ := fakeslurm.SyntheticJobs(5)
+	s.SetBody("jobs", jobs)
+
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+	ctx = context.WithValue(ctx, types.ApiIncrementalKey, NewIncremental(time.Hour))
+	jobCount := func() int {
+		t.Helper()
+		if err := PopulateCache(ctx); err != nil {
+			t.Fatalf("failed to populate cache: %v", err)
+		}
+		defer WipeCache(ctx)
+		return len(CurrentSnapshot(ctx).Jobs.Jobs)
+	}
+
+	if n := jobCount(); n != 5 || s.LastQuery("jobs").Has("update_time") {
+		t.Fatalf("expected a full fetch of 5 jobs, got %d with %v", n, s.LastQuery("jobs"))
+	}
+	// nothing changed, no jobs are returned and the kept ones are reused
+	empty, _ := fakeslurm.SyntheticJobs(0)
+	s.SetBody("jobs", empty)
+	if n := jobCount(); n != 5 || !s.LastQuery("jobs").Has("update_time") || !s.LastQuery("nodes").Has("update_time") {
+		t.Fatalf("expected an incremental fetch reusing 5 jobs, got %d with %v", n, s.LastQuery("jobs"))
+	}
+	if s.LastQuery("partitions").Has("update_time") {
+		t.Fatalf("expected only the jobs and nodes to be fetched incrementally")
+	}
+
+	// something changed, every job is returned and replaces the kept ones
+	changed, _ := fakeslurm.SyntheticJobs(2)
+	s.SetBody("jobs", changed)
+	if n := jobCount(); n != 2 || !s.LastQuery("jobs").Has("update_time") {
+		t.Fatalf("expected an incremental fetch of 2 jobs, got %d with %v", n, s.LastQuery("jobs"))
+	}
+
+	// a full resync doesn't send update_time
+	ctx = context.WithValue(ctx, types.ApiIncrementalKey, NewIncremental(0))
+	if n := jobCount(); n != 2 || s.LastQuery("jobs").Has("update_time") {
+		t.Fatalf("expected a full fetch of 2 jobs, got %d with %v", n, s.LastQuery("jobs"))
+	}
+}
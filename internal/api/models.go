@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -22,6 +22,21 @@ import (
 // models for simplicity. We can add to these models as we want to extract more
 // information
 
+// DecodeErrors counts the fields of a response that couldn't be decoded, by
+// field name. A record missing the field that identifies it is skipped, any
+// other malformed field is left empty or unknown, so one bad record never
+// loses the rest of the response.
+type DecodeErrors map[string]int
+
+// add counts a field that couldn't be decoded
+func (e *DecodeErrors) add(field string, err error) {
+	slog.Debug("failed to decode field in response", "field", field, "error", err)
+	if *e == nil {
+		*e = make(DecodeErrors)
+	}
+	(*e)[field]++
+}
+
 type DiagData struct {
 	ApiVersion             string
 	ServerThreadCount      int32
@@ -36,6 +51,36 @@ type DiagData struct {
 	BfBackfilledJobs       int32
 	BfLastBackfilledJobs   int32
 	BfBackfilledHetJobs    int32
+	ReqTime                int64
+	ReqTimeStart           int64
+	GettimeofdayLatency    int32
+	ScheduleCycleMax       int32
+	ScheduleCycleTotal     int32
+	ScheduleQueueLength    int32
+	JobsSubmitted          int32
+	JobsStarted            int32
+	JobsCompleted          int32
+	JobsCanceled           int32
+	JobsFailed             int32
+	JobsPending            int32
+	JobsRunning            int32
+	BfCycleCounter         int32
+	BfCycleMax             int32
+	BfQueueLen             int32
+	BfTableSize            int32
+	BfWhenLastCycle        int64
+	BfActive               bool
+	RpcsByMessageType      []RpcData
+	RpcsByUser             []RpcData
+	PendingRpcs            []RpcData
+}
+
+// RpcData holds the statistics slurmctld keeps for a single RPC message type
+// or user. TotalTime is in microseconds.
+type RpcData struct {
+	Name      string
+	Count     int64
+	TotalTime int64
 }
 
 func NewDiagData() *DiagData {
@@ -141,6 +186,166 @@ func (d *DiagData) SetBfBackfilledHetJobs(v *int32) error {
 	return nil
 }
 
+// The statistics below were added later and not every version of the api
+// returns all of them, so missing values are left at 0 instead of failing
+// the whole diag response.
+
+func (d *DiagData) SetReqTime(v *int64) {
+	if v != nil {
+		d.ReqTime = *v
+	}
+}
+
+func (d *DiagData) SetReqTimeStart(v *int64) {
+	if v != nil {
+		d.ReqTimeStart = *v
+	}
+}
+
+func (d *DiagData) SetGettimeofdayLatency(v *int32) {
+	if v != nil {
+		d.GettimeofdayLatency = *v
+	}
+}
+
+func (d *DiagData) SetScheduleCycleMax(v *int32) {
+	if v != nil {
+		d.ScheduleCycleMax = *v
+	}
+}
+
+func (d *DiagData) SetScheduleCycleTotal(v *int32) {
+	if v != nil {
+		d.ScheduleCycleTotal = *v
+	}
+}
+
+func (d *DiagData) SetScheduleQueueLength(v *int32) {
+	if v != nil {
+		d.ScheduleQueueLength = *v
+	}
+}
+
+func (d *DiagData) SetJobsSubmitted(v *int32) {
+	if v != nil {
+		d.JobsSubmitted = *v
+	}
+}
+
+func (d *DiagData) SetJobsStarted(v *int32) {
+	if v != nil {
+		d.JobsStarted = *v
+	}
+}
+
+func (d *DiagData) SetJobsCompleted(v *int32) {
+	if v != nil {
+		d.JobsCompleted = *v
+	}
+}
+
+func (d *DiagData) SetJobsCanceled(v *int32) {
+	if v != nil {
+		d.JobsCanceled = *v
+	}
+}
+
+func (d *DiagData) SetJobsFailed(v *int32) {
+	if v != nil {
+		d.JobsFailed = *v
+	}
+}
+
+func (d *DiagData) SetJobsPending(v *int32) {
+	if v != nil {
+		d.JobsPending = *v
+	}
+}
+
+func (d *DiagData) SetJobsRunning(v *int32) {
+	if v != nil {
+		d.JobsRunning = *v
+	}
+}
+
+func (d *DiagData) SetBfCycleCounter(v *int32) {
+	if v != nil {
+		d.BfCycleCounter = *v
+	}
+}
+
+func (d *DiagData) SetBfCycleMax(v *int32) {
+	if v != nil {
+		d.BfCycleMax = *v
+	}
+}
+
+func (d *DiagData) SetBfQueueLen(v *int32) {
+	if v != nil {
+		d.BfQueueLen = *v
+	}
+}
+
+func (d *DiagData) SetBfTableSize(v *int32) {
+	if v != nil {
+		d.BfTableSize = *v
+	}
+}
+
+func (d *DiagData) SetBfWhenLastCycle(v *int64) {
+	if v != nil {
+		d.BfWhenLastCycle = *v
+	}
+}
+
+func (d *DiagData) SetBfActive(v *bool) {
+	if v != nil {
+		d.BfActive = *v
+	}
+}
+
+func (d *DiagData) AddRpcByMessageType(messageType *string, count *int64, totalTime *int64) error {
+	if messageType == nil {
+		return fmt.Errorf("message type not found in rpc statistics")
+	}
+	rd := RpcData{Name: *messageType}
+	if count != nil {
+		rd.Count = *count
+	}
+	if totalTime != nil {
+		rd.TotalTime = *totalTime
+	}
+	d.RpcsByMessageType = append(d.RpcsByMessageType, rd)
+	return nil
+}
+
+func (d *DiagData) AddRpcByUser(user *string, count *int64, totalTime *int64) error {
+	if user == nil {
+		return fmt.Errorf("user not found in rpc statistics")
+	}
+	rd := RpcData{Name: *user}
+	if count != nil {
+		rd.Count = *count
+	}
+	if totalTime != nil {
+		rd.TotalTime = *totalTime
+	}
+	d.RpcsByUser = append(d.RpcsByUser, rd)
+	return nil
+}
+
+func (d *DiagData) AddPendingRpc(messageType *string, count *int32) error {
+	if messageType == nil {
+		return fmt.Errorf("message type not found in pending rpc statistics")
+	}
+	rd := RpcData{Name: *messageType}
+	if count != nil {
+		rd.Count = int64(*count)
+	}
+	d.PendingRpcs = append(d.PendingRpcs, rd)
+	return nil
+}
+
 func (d *DiagData) FromResponse(r DiagResp) error {
 	var err error
 	if err = d.SetServerThreadCount(r.Statistics.ServerThreadCount); err != nil {
@@ -179,18 +384,55 @@ func (d *DiagData) FromResponse(r DiagResp) error {
 	if err = d.SetBfBackfilledHetJobs(r.Statistics.BfBackfilledHetJobs); err != nil {
 		return err
 	}
+	d.SetReqTime(r.Statistics.ReqTime.Number)
+	d.SetReqTimeStart(r.Statistics.ReqTimeStart.Number)
+	d.SetGettimeofdayLatency(r.Statistics.GettimeofdayLatency)
+	d.SetScheduleCycleMax(r.Statistics.ScheduleCycleMax)
+	d.SetScheduleCycleTotal(r.Statistics.ScheduleCycleTotal)
+	d.SetScheduleQueueLength(r.Statistics.ScheduleQueueLength)
+	d.SetJobsSubmitted(r.Statistics.JobsSubmitted)
+	d.SetJobsStarted(r.Statistics.JobsStarted)
+	d.SetJobsCompleted(r.Statistics.JobsCompleted)
+	d.SetJobsCanceled(r.Statistics.JobsCanceled)
+	d.SetJobsFailed(r.Statistics.JobsFailed)
+	d.SetJobsPending(r.Statistics.JobsPending)
+	d.SetJobsRunning(r.Statistics.JobsRunning)
+	d.SetBfCycleCounter(r.Statistics.BfCycleCounter)
+	d.SetBfCycleMax(r.Statistics.BfCycleMax)
+	d.SetBfQueueLen(r.Statistics.BfQueueLen)
+	d.SetBfTableSize(r.Statistics.BfTableSize)
+	d.SetBfWhenLastCycle(r.Statistics.BfWhenLastCycle.Number)
+	d.SetBfActive(r.Statistics.BfActive)
+	for _, rpc := range r.Statistics.RpcsByMessageType {
+		if err = d.AddRpcByMessageType(rpc.MessageType, rpc.Count, rpc.TotalTime); err != nil {
+			return err
+		}
+	}
+	for _, rpc := range r.Statistics.RpcsByUser {
+		if err = d.AddRpcByUser(rpc.User, rpc.Count, rpc.TotalTime); err != nil {
+			return err
+		}
+	}
+	for _, rpc := range r.Statistics.PendingRpcs {
+		if err = d.AddPendingRpc(rpc.MessageType, rpc.Count); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type NodesData struct {
 	ApiVersion string
 	Nodes      []NodeData
+	// LastUpdate is when slurmctld last changed the nodes, as a unix
+	// timestamp, or 0 if it wasn't reported
+	LastUpdate   int64
+	DecodeErrors DecodeErrors
 }
 
 type NodeData struct {
 	Name          string
 	Hostname      string
-	States        []types.NodeState
 	Tres          string
 	TresUsed      string
 	Partitions    []string
@@ -198,10 +440,18 @@ type NodeData struct {
 	RealMemory    int64
 	AllocCpus     int32
 	AllocIdleCpus int32
-	OtherCpus     int32
 	Cpus          int32
 	GPUTotal      int32
 	GPUAllocated  int32
+	// BaseState and Flags are the state of the node as slurm reports it,
+	// State is the compound state sinfo shows for them, such as drained or
+	// idle~
+	BaseState types.NodeState
+	Flags     []types.NodeFlag
+	State     string
+	// LegacyState is the state the v1 metrics label the node with, the
+	// short names of its states joined by |, such as idle|drain
+	LegacyState string
 }
 
 func NewNodesData() *NodesData {
@@ -279,12 +529,6 @@ func (n *NodeData) SetIdleCPUs(idleCPUs *int32) error {
 	return nil
 }
 
-// This isn't in the api so it's always 0
-func (n *NodeData) SetOtherCPUs() error {
-	n.OtherCpus = 0
-	return nil
-}
-
 func (n *NodeData) SetTotalCPUs(totalCPUs *int32) error {
 	if totalCPUs == nil {
 		n.Cpus = 0
@@ -295,6 +539,10 @@ func (n *NodeData) SetTotalCPUs(totalCPUs *int32) error {
 }
 
 func (n *NodeData) SetNodeGPUTotal(tresString *string) error {
+	n.GPUTotal = 0
+	if tresString == nil {
+		return nil
+	}
 	parts := strings.Split(*tresString, ",")
 	for _, p := range parts {
 		if strings.Contains(p, "gres/gpu=") {
@@ -316,6 +564,10 @@ func (n *NodeData) SetNodeGPUTotal(tresString *string) error {
 }
 
 func (n *NodeData) SetNodeGPUAllocated(tresString *string) error {
+	n.GPUAllocated = 0
+	if tresString == nil {
+		return nil
+	}
 	parts := strings.Split(*tresString, ",")
 	for _, p := range parts {
 		if strings.Contains(p, "gres/gpu=") {
@@ -336,139 +588,265 @@ func (n *NodeData) SetNodeGPUAllocated(tresString *string) error {
 	return nil
 }
 
+// nodeBaseStates maps the base states slurm reports for a node. INVALID is
+// reported by slurmrestd for a base state it doesn't know.
+var nodeBaseStates = map[string]types.NodeState{
+	"ALLOCATED": types.NodeStateAlloc,
+	"DOWN":      types.NodeStateDown,
+	"ERROR":     types.NodeStateErr,
+	"FUTURE":    types.NodeStateFuture,
+	"IDLE":      types.NodeStateIdle,
+	"MIXED":     types.NodeStateMix,
+	"UNKNOWN":   types.NodeStateUnknown,
+	"INVALID":   types.NodeStateUnknown,
+}
+
+// nodeFlags maps the flags slurm reports on the state of a node
+var nodeFlags = map[string]types.NodeFlag{
+	"BLOCKED":          types.NodeFlagBlocked,
+	"CLOUD":            types.NodeFlagCloud,
+	"COMPLETING":       types.NodeFlagCompleting,
+	"DRAIN":            types.NodeFlagDrain,
+	"DYNAMIC_FUTURE":   types.NodeFlagDynamicFuture,
+	"DYNAMIC_NORM":     types.NodeFlagDynamicNorm,
+	"EXTERNAL":         types.NodeFlagExternal,
+	"FAIL":             types.NodeFlagFail,
+	"INVALID_REG":      types.NodeFlagInvalidReg,
+	"MAINTENANCE":      types.NodeFlagMaint,
+	"NOT_RESPONDING":   types.NodeFlagNotResponding,
+	"PLANNED":          types.NodeFlagPlanned,
+	"POWER_DOWN":       types.NodeFlagPowerDown,
+	"POWER_DRAIN":      types.NodeFlagPowerDrain,
+	"POWERED_DOWN":     types.NodeFlagPoweredDown,
+	"POWERING_DOWN":    types.NodeFlagPoweringDown,
+	"POWERING_UP":      types.NodeFlagPoweringUp,
+	"POWER_UP":         types.NodeFlagPowerUp,
+	"REBOOT_CANCELED":  types.NodeFlagRebootCanceled,
+	"REBOOT_ISSUED":    types.NodeFlagRebootIssued,
+	"REBOOT_REQUESTED": types.NodeFlagRebootRequested,
+	"RESERVED":         types.NodeFlagReserved,
+	"RESUME":           types.NodeFlagResume,
+	"UNDRAIN":          types.NodeFlagUndrain,
+}
+
+// SetNodeStates splits the state of the node into its base state and flags,
+// and derives the compound state from them. A node without a base state is
+// unknown, and the states that aren't known are left out.
 func (n *NodeData) SetNodeStates(states []string) error {
-	var nodeStates []types.NodeState
-	if states == nil {
+	n.BaseState = types.NodeStateUnknown
+	n.Flags = nil
+	var unknown []string
+	base := false
+	for _, s := range states {
+		s = strings.ToUpper(s)
+		if state, found := nodeBaseStates[s]; found && !base {
+			n.BaseState = state
+			base = true
+			continue
+		}
+		if flag, found := nodeFlags[s]; found {
+			if !slices.Contains(n.Flags, flag) {
+				n.Flags = append(n.Flags, flag)
+			}
+			continue
+		}
+		if _, found := nodeBaseStates[s]; !found {
+			unknown = append(unknown, s)
+		}
+	}
+	n.State = n.compoundState()
+	n.LegacyState = legacyNodeState(states)
+	if len(states) == 0 {
 		// node state is not found in the node response
 		return fmt.Errorf("node state not found in node")
 	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("failed to match node state against known states: %v", unknown)
+	}
+	return nil
+}
+
+// legacyNodeStates are the short names of the node states in the v1 status
+// label, matched by prefix in order, so invalid_reg is invalid and resume is
+// resv as they always were
+var legacyNodeStates = []struct{ prefix, name string }{
+	{"alloc", "alloc"},
+	{"comp", "comp"},
+	{"down", "down"},
+	{"drain", "drain"},
+	{"fail", "fail"},
+	{"err", "err"},
+	{"idle", "idle"},
+	{"maint", "maint"},
+	{"mix", "mix"},
+	{"planned", "planned"},
+	{"res", "resv"},
+	{"not_responding", "not_responding"},
+	{"invalid", "invalid"},
+	{"invalid_reg", "invalid_reg"},
+	{"dynamic_norm", "dynamic_norm"},
+	{"reboot_issued", "reboot_issued"},
+	{"reboot_cancel", "reboot_cancel"},
+	{"reboot", "reboot"},
+	{"powered_down", "powered_down"},
+}
 
+// legacyNodeState joins the short names of the states by |, leaving out the
+// states without one
+func legacyNodeState(states []string) string {
+	var names []string
 	for _, s := range states {
-		state := string(s)
-		state = strings.ToLower(state)
-
-		alloc := regexp.MustCompile(`^alloc`)
-		comp := regexp.MustCompile(`^comp`)
-		down := regexp.MustCompile(`^down`)
-		drain := regexp.MustCompile(`^drain`)
-		fail := regexp.MustCompile(`^fail`)
-		err := regexp.MustCompile(`^err`)
-		idle := regexp.MustCompile(`^idle`)
-		maint := regexp.MustCompile(`^maint`)
-		mix := regexp.MustCompile(`^mix`)
-		planned := regexp.MustCompile(`^planned`)
-		resv := regexp.MustCompile(`^res`)
-		notresp := regexp.MustCompile(`^not_responding`)
-		invalid := regexp.MustCompile(`^invalid`)
-		invalidreg := regexp.MustCompile(`^invalid_reg`)
-		dynnorm := regexp.MustCompile(`^dynamic_norm`)
-		reboot := regexp.MustCompile(`^reboot`)
-		rebootissued := regexp.MustCompile(`^reboot_issued`)
-		rebootcancel := regexp.MustCompile(`^reboot_cancel`)
-		poweredDown := regexp.MustCompile(`^powered_down`)
-
-		var stateUnit types.NodeState
+		s = strings.ToLower(s)
+		for _, l := range legacyNodeStates {
+			if strings.HasPrefix(s, l.prefix) {
+				names = append(names, l.name)
+				break
+			}
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// HasFlag returns true if the flag is set on the state of the node
+func (n *NodeData) HasFlag(flag types.NodeFlag) bool {
+	return slices.Contains(n.Flags, flag)
+}
+
+// CPUStates splits the CPUs of the node the way sinfo -o %C does. All the
+// CPUs of a down, drained or failed node are other, since nothing new can be
+// scheduled on them, and the CPUs of any other node are allocated or idle, so
+// the three always add up to the CPUs of the node.
+func (n *NodeData) CPUStates() (alloc int32, idle int32, other int32) {
+	if n.BaseState == types.NodeStateDown || n.HasFlag(types.NodeFlagDrain) || n.HasFlag(types.NodeFlagFail) {
+		return 0, 0, n.Cpus
+	}
+	alloc = min(n.AllocCpus, n.Cpus)
+	return alloc, n.Cpus - alloc, 0
+}
 
+// compoundState returns the state sinfo shows for the node, in its long
+// lowercase form, following node_state_string in slurm. A drained idle node
+// is drained rather than idle, and a suffix marks the flags that don't change
+// the state, such as ~ for powered down or * for not responding.
+func (n *NodeData) compoundState() string {
+	base := n.BaseState
+	busy := base == types.NodeStateAlloc || base == types.NodeStateMix
+	switch {
+	case n.HasFlag(types.NodeFlagInvalidReg):
+		return "inval"
+	case n.HasFlag(types.NodeFlagMaint) && !n.HasFlag(types.NodeFlagDrain) && !busy && base != types.NodeStateDown:
+		if n.HasFlag(types.NodeFlagNotResponding) {
+			return "maint*"
+		}
+		return "maint"
+	case (n.HasFlag(types.NodeFlagRebootRequested) || n.HasFlag(types.NodeFlagRebootIssued)) && !busy:
+		if n.HasFlag(types.NodeFlagRebootIssued) {
+			return "reboot^"
+		}
+		if n.HasFlag(types.NodeFlagNotResponding) {
+			return "reboot*"
+		}
+		return "reboot"
+	case n.HasFlag(types.NodeFlagDrain):
+		if busy || n.HasFlag(types.NodeFlagCompleting) {
+			return "draining" + n.stateSuffix()
+		}
+		return "drained" + n.stateSuffix()
+	case n.HasFlag(types.NodeFlagFail):
+		if base == types.NodeStateAlloc || n.HasFlag(types.NodeFlagCompleting) {
+			return "failing" + n.stateSuffix()
+		}
+		return "fail" + n.stateSuffix()
+	case base == types.NodeStateDown:
+		return "down" + n.stateSuffix()
+	case base == types.NodeStateAlloc:
+		return "allocated" + n.stateSuffix()
+	case n.HasFlag(types.NodeFlagCompleting):
+		return "completing" + n.stateSuffix()
+	case base == types.NodeStateIdle:
 		switch {
-		case alloc.MatchString(state):
-			stateUnit = types.NodeStateAlloc
-		case comp.MatchString(state):
-			stateUnit = types.NodeStateComp
-		case down.MatchString(state):
-			stateUnit = types.NodeStateDown
-		case drain.MatchString(state):
-			stateUnit = types.NodeStateDrain
-		case fail.MatchString(state):
-			stateUnit = types.NodeStateFail
-		case err.MatchString(state):
-			stateUnit = types.NodeStateErr
-		case idle.MatchString(state):
-			stateUnit = types.NodeStateIdle
-		case maint.MatchString(state):
-			stateUnit = types.NodeStateMaint
-		case mix.MatchString(state):
-			stateUnit = types.NodeStateMix
-		case planned.MatchString(state):
-			stateUnit = types.NodeStatePlanned
-		case resv.MatchString(state):
-			stateUnit = types.NodeStateResv
-		case notresp.MatchString(state):
-			stateUnit = types.NodeStateNotResponding
-		case invalid.MatchString(state):
-			stateUnit = types.NodeStateInvalid
-		case invalidreg.MatchString(state):
-			stateUnit = types.NodeStateInvalidReg
-		case dynnorm.MatchString(state):
-			stateUnit = types.NodeStateDynamicNorm
-		case rebootissued.MatchString(state):
-			stateUnit = types.NodeStateRebootIssued
-		case rebootcancel.MatchString(state):
-			stateUnit = types.NodeStateRebootCancel
-		case reboot.MatchString(state):
-			stateUnit = types.NodeStateReboot
-		case poweredDown.MatchString(state):
-			stateUnit = types.NodeStatePoweredDown
-		default:
-			slog.Info("failed to match cpu state against known states", "state", state)
-		}
-
-		nodeStates = append(nodeStates, stateUnit)
-	}
-	n.States = nodeStates
-	return nil
-}
-
-func (n *NodeData) GetNodeStatesString(delim string) (string, error) {
-	strStates := make([]string, len(n.States))
-	for i, s := range n.States {
-		strStates[i] = string(s)
-	}
-	return strings.Join(strStates, delim), nil
+		case n.HasFlag(types.NodeFlagPlanned):
+			return "planned" + n.stateSuffix()
+		case n.HasFlag(types.NodeFlagReserved):
+			return "reserved" + n.stateSuffix()
+		}
+		return "idle" + n.stateSuffix()
+	case base == types.NodeStateMix:
+		return "mixed" + n.stateSuffix()
+	case base == types.NodeStateErr:
+		return "error" + n.stateSuffix()
+	case base == types.NodeStateFuture:
+		return "future" + n.stateSuffix()
+	}
+	return "unknown" + n.stateSuffix()
+}
+
+// stateSuffix returns the suffix sinfo appends to a state for the flags that
+// don't change it, only the first one that applies is shown
+func (n *NodeData) stateSuffix() string {
+	switch {
+	case n.HasFlag(types.NodeFlagMaint):
+		return "$"
+	case n.HasFlag(types.NodeFlagRebootRequested):
+		return "@"
+	case n.HasFlag(types.NodeFlagPoweringUp):
+		return "#"
+	case n.HasFlag(types.NodeFlagPoweringDown):
+		return "%"
+	case n.HasFlag(types.NodeFlagPoweredDown):
+		return "~"
+	case n.HasFlag(types.NodeFlagPowerDown):
+		return "!"
+	case n.HasFlag(types.NodeFlagNotResponding):
+		return "*"
+	}
+	return ""
 }
 
 func (d *NodesData) FromResponse(r NodesResp) error {
 	var err error
+	if r.LastUpdate.Number != nil {
+		d.LastUpdate = *r.LastUpdate.Number
+	}
 	for _, n := range r.Nodes {
 		nd := NodeData{}
 		if err = nd.SetName(n.Name); err != nil {
-			return err
+			d.DecodeErrors.add("name", err)
+			continue
 		}
 		if err = nd.SetHostname(n.Hostname); err != nil {
-			return err
+			d.DecodeErrors.add("hostname", err)
 		}
 		if err = nd.SetNodeStates(n.State); err != nil {
-			return err
+			d.DecodeErrors.add("state", err)
 		}
 		if err = nd.SetPartitions(n.Partitions); err != nil {
-			return err
+			d.DecodeErrors.add("partitions", err)
 		}
 		nd.SetTres(n.Tres)
 		nd.SetTresUsed(n.TresUsed)
 		if err = nd.SetTotalCPUs(n.Cpus); err != nil {
-			return err
+			d.DecodeErrors.add("cpus", err)
 		}
 		if err = nd.SetAllocCPUs(n.AllocCpus); err != nil {
-			return err
+			d.DecodeErrors.add("alloc_cpus", err)
 		}
 		if err = nd.SetIdleCPUs(n.AllocIdleCpus); err != nil {
-			return err
-		}
-		if err = nd.SetOtherCPUs(); err != nil {
-			return err
+			d.DecodeErrors.add("alloc_idle_cpus", err)
 		}
 
 		if err = nd.SetTotalMemory(n.RealMemory); err != nil {
-			return err
+			d.DecodeErrors.add("real_memory", err)
 		}
 		if err = nd.SetAllocMemory(n.AllocMemory); err != nil {
-			return err
+			d.DecodeErrors.add("alloc_memory", err)
 		}
 
 		if err = nd.SetNodeGPUAllocated(n.TresUsed); err != nil {
-			return err
+			d.DecodeErrors.add("tres_used", err)
 		}
 		if err = nd.SetNodeGPUTotal(n.Tres); err != nil {
-			return err
+			d.DecodeErrors.add("tres", err)
 		}
 
 		d.Nodes = append(d.Nodes, nd)
@@ -480,15 +858,41 @@ func (d *NodesData) FromResponse(r NodesResp) error {
 type JobsData struct {
 	ApiVersion string
 	Jobs       []JobData
+	// LastUpdate is when slurmctld last changed the jobs, as a unix
+	// timestamp, or 0 if it wasn't reported
+	LastUpdate   int64
+	DecodeErrors DecodeErrors
 }
 
 type JobData struct {
+	JobId      int32
 	Account    string
 	UserName   string
 	JobState   types.JobState
 	Cpus       int32
 	Partition  string
 	Dependency string
+	Priority   int64
+	// TresCpus, TresMemory and TresGPUs are the resources the job was
+	// allocated, or requests when it hasn't been allocated any yet, such as a
+	// pending job. The memory is in megabytes.
+	TresCpus   int64
+	TresMemory int64
+	TresGPUs   int64
+	// ArrayJobId is the id of the job array the job is a task of, or 0.
+	// slurm keeps the pending tasks of an array in a single record until
+	// they start, with their ids in ArrayTaskString, such as 1-1000%10, and
+	// ArrayTasks is the number of tasks the record stands for.
+	ArrayJobId      int32
+	ArrayTaskId     int32
+	ArrayTaskString string
+	ArrayMaxTasks   int32
+	ArrayTasks      int32
+	// HetJobId is the id of the heterogeneous job the job is a component
+	// of, or 0, and HetJobOffset is the index of the component. Every
+	// component is a job of its own in the response.
+	HetJobId     int32
+	HetJobOffset int32
 }
 
 func NewJobsData() *JobsData {
@@ -497,6 +901,14 @@ func NewJobsData() *JobsData {
 	}
 }
 
+func (j *JobData) SetJobId(id *int32) error {
+	if id == nil {
+		return fmt.Errorf("failed to find job id in job")
+	}
+	j.JobId = *id
+	return nil
+}
+
 func (j *JobData) SetJobAccount(name *string) error {
 	if name == nil {
 		return fmt.Errorf("failed to find account name in job")
@@ -524,63 +936,68 @@ func (j *JobData) SetJobPartitionName(name *string) error {
 func (j *JobData) SetJobDependency(dependency *string) error {
 	if dependency == nil {
 		j.Dependency = ""
+		return nil
 	}
 	j.Dependency = *dependency
 	return nil
 }
 
+// jobStates maps the states and flags in the job_state of a job to the
+// state it is reported in
+var jobStates = func() map[string]types.JobState {
+	m := make(map[string]types.JobState, len(types.JobStates))
+	for _, s := range types.JobStates {
+		m[strings.ToUpper(string(s))] = s
+	}
+	return m
+}()
+
+// SetJobState sets the state of the job from its base state and flags. The
+// flags in types.JobStateFlags are reported instead of the base state, the
+// same way squeue does, other flags are ignored. A job without a known base
+// state is set to unknown.
 func (j *JobData) SetJobState(states []string) error {
-	if states == nil {
+	j.JobState = types.JobStateUnknown
+	if len(states) == 0 {
 		// job state is not found in the job response
 		return fmt.Errorf("job state not found in job")
 	}
-	state := string((states)[0])
-	state = strings.ToLower(state)
-
-	completed := regexp.MustCompile(`^completed`)
-	pending := regexp.MustCompile(`^pending`)
-	failed := regexp.MustCompile(`^failed`)
-	running := regexp.MustCompile(`^running`)
-	suspended := regexp.MustCompile(`^suspended`)
-	out_of_memory := regexp.MustCompile(`^out_of_memory`)
-	timeout := regexp.MustCompile(`^timeout`)
-	cancelled := regexp.MustCompile(`^cancelled`)
-	completing := regexp.MustCompile(`^completing`)
-	configuring := regexp.MustCompile(`^configuring`)
-	node_fail := regexp.MustCompile(`^node_fail`)
-	preempted := regexp.MustCompile(`^preempted`)
-
-	var stateUnit types.JobState
+	base := types.JobStateUnknown
+	flag := -1
+	for _, s := range states {
+		state, found := jobStates[strings.ToUpper(s)]
+		if !found {
+			continue
+		}
+		if i := slices.Index(types.JobStateFlags, state); i >= 0 {
+			if flag < 0 || i < flag {
+				flag = i
+			}
+			continue
+		}
+		if base == types.JobStateUnknown {
+			base = state
+		}
+	}
+	if flag >= 0 {
+		j.JobState = types.JobStateFlags[flag]
+		return nil
+	}
+	j.JobState = base
+	if base == types.JobStateUnknown {
+		return fmt.Errorf("failed to match job state against known states: %v", states)
+	}
+	return nil
+}
 
-	switch {
-	case completed.MatchString(state):
-		stateUnit = types.JobStateCompleted
-	case pending.MatchString(state):
-		stateUnit = types.JobStatePending
-	case failed.MatchString(state):
-		stateUnit = types.JobStateFailed
-	case running.MatchString(state):
-		stateUnit = types.JobStateRunning
-	case suspended.MatchString(state):
-		stateUnit = types.JobStateSuspended
-	case out_of_memory.MatchString(state):
-		stateUnit = types.JobStateOutOfMemory
-	case timeout.MatchString(state):
-		stateUnit = types.JobStateTimeout
-	case cancelled.MatchString(state):
-		stateUnit = types.JobStateCancelled
-	case completing.MatchString(state):
-		stateUnit = types.JobStateCompleting
-	case configuring.MatchString(state):
-		stateUnit = types.JobStateConfiguring
-	case node_fail.MatchString(state):
-		stateUnit = types.JobStateNodeFail
-	case preempted.MatchString(state):
-		stateUnit = types.JobStatePreempted
-	default:
-		return fmt.Errorf("failed to match job state against known states: %v", state)
-	}
-	j.JobState = stateUnit
+// The priority is only meaningful for pending jobs, slurm reports 0 for
+// held jobs and leaves it set to the last value for everything else.
+func (j *JobData) SetJobPriority(priority *int64) error {
+	if priority == nil {
+		j.Priority = 0
+		return nil
+	}
+	j.Priority = *priority
 	return nil
 }
 
@@ -593,37 +1010,202 @@ func (j *JobData) SetJobCPUs(jobcpus *int32) error {
 	return nil
 }
 
-func (d *JobsData) FromResponse(r JobsResp) error {
-	var err error
-	for _, j := range r.Jobs {
-		jd := JobData{}
-		if err = jd.SetJobAccount(j.Account); err != nil {
-			return err
+// tresMemoryUnits are the suffixes slurm prints the memory of a tres with, in
+// megabytes
+var tresMemoryUnits = map[byte]float64{'K': 1.0 / 1024, 'M': 1, 'G': 1024, 'T': 1024 * 1024, 'P': 1024 * 1024 * 1024}
+
+// parseTresMemory returns the megabytes of a memory tres such as 4G, a value
+// without a suffix is in megabytes
+func parseTresMemory(value string) (int64, error) {
+	unit := 1.0
+	if n := len(value); n > 0 {
+		if u, ok := tresMemoryUnits[value[n-1]]; ok {
+			unit = u
+			value = value[:n-1]
 		}
-		if err = jd.SetJobUserName(j.UserName); err != nil {
-			return err
+	}
+	mem, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(mem * unit), nil
+}
+
+// SetJobTres sets the cpus, memory and gpus of the job from a tres string
+// such as cpu=4,mem=16G,node=1,gres/gpu=2. The other resources are ignored.
+func (j *JobData) SetJobTres(tres *string) error {
+	j.TresCpus, j.TresMemory, j.TresGPUs = 0, 0, 0
+	if tres == nil {
+		return nil
+	}
+	for _, p := range strings.Split(*tres, ",") {
+		name, value, found := strings.Cut(p, "=")
+		if !found {
+			continue
 		}
-		if err = jd.SetJobPartitionName(j.Partition); err != nil {
-			return err
+		var err error
+		switch name {
+		case "cpu":
+			j.TresCpus, err = strconv.ParseInt(value, 10, 64)
+		case "mem":
+			j.TresMemory, err = parseTresMemory(value)
+		case "gres/gpu":
+			j.TresGPUs, err = strconv.ParseInt(value, 10, 64)
 		}
-		if err = jd.SetJobState(j.JobState); err != nil {
-			return err
+		if err != nil {
+			return fmt.Errorf("failed to parse %s from tres: %s", name, p)
 		}
-		if err = jd.SetJobDependency(j.Dependency); err != nil {
-			return err
+	}
+	return nil
+}
+
+// parseArrayTasks returns the number of task ids in an array task string
+// such as 1-3,5,7-15:2%4, and the limit on the tasks that can run at once
+// after the %, or 0 when there is none
+func parseArrayTasks(s string) (tasks int32, maxTasks int32, err error) {
+	s, limit, found := strings.Cut(s, "%")
+	if found {
+		n, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse array task limit: %s", limit)
 		}
-		if err = jd.SetJobCPUs(j.JobResources.Cpus); err != nil {
-			return err
+		maxTasks = int32(n)
+	}
+	for _, r := range strings.Split(s, ",") {
+		r, step, found := strings.Cut(r, ":")
+		inc := int64(1)
+		if found {
+			if inc, err = strconv.ParseInt(step, 10, 32); err != nil || inc < 1 {
+				return 0, 0, fmt.Errorf("failed to parse array task step: %s", step)
+			}
+		}
+		first, last, found := strings.Cut(r, "-")
+		start, err := strconv.ParseInt(first, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse array task id: %s", first)
 		}
-		d.Jobs = append(d.Jobs, jd)
+		end := start
+		if found {
+			if end, err = strconv.ParseInt(last, 10, 32); err != nil || end < start {
+				return 0, 0, fmt.Errorf("failed to parse array task range: %s", r)
+			}
+		}
+		tasks += int32((end-start)/inc + 1)
+	}
+	return tasks, maxTasks, nil
+}
+
+// SetJobArray sets the job array the job is a task of. A record holding
+// several pending tasks of an array counts as that many tasks.
+func (j *JobData) SetJobArray(jobId *int32, taskId *int32, taskString *string, maxTasks *int32) error {
+	j.ArrayJobId, j.ArrayTaskId, j.ArrayTaskString, j.ArrayMaxTasks, j.ArrayTasks = 0, 0, "", 0, 0
+	if jobId == nil || *jobId == 0 {
+		return nil
+	}
+	j.ArrayJobId = *jobId
+	if taskId != nil {
+		j.ArrayTaskId = *taskId
+	}
+	if maxTasks != nil {
+		j.ArrayMaxTasks = *maxTasks
+	}
+	if taskString == nil || *taskString == "" {
+		return nil
+	}
+	j.ArrayTaskString = *taskString
+	tasks, limit, err := parseArrayTasks(*taskString)
+	if err != nil {
+		return err
+	}
+	j.ArrayTasks = tasks
+	if j.ArrayMaxTasks == 0 {
+		j.ArrayMaxTasks = limit
+	}
+	return nil
+}
+
+// Tasks returns the number of jobs the record stands for, which is more than
+// one for the pending tasks of a job array
+func (j *JobData) Tasks() int32 {
+	return max(j.ArrayTasks, 1)
+}
+
+// SetJobHet sets the heterogeneous job the job is a component of
+func (j *JobData) SetJobHet(hetJobId *int32, offset *int32) error {
+	j.HetJobId, j.HetJobOffset = 0, 0
+	if hetJobId == nil || *hetJobId == 0 {
+		return nil
 	}
+	j.HetJobId = *hetJobId
+	if offset == nil {
+		return fmt.Errorf("failed to find het job offset in component of het job %d", *hetJobId)
+	}
+	j.HetJobOffset = *offset
+	return nil
+}
 
+func (d *JobsData) FromResponse(r JobsResp) error {
+	if r.LastUpdate.Number != nil {
+		d.LastUpdate = *r.LastUpdate.Number
+	}
+	for _, j := range r.Jobs {
+		d.addJob(j)
+	}
 	return nil
 }
 
+// addJob converts a job from the response and appends it, the jobs are added
+// one at a time when the response is streamed. Jobs without an id are
+// skipped.
+func (d *JobsData) addJob(j JobResp) {
+	var err error
+	jd := JobData{}
+	if err = jd.SetJobId(j.JobId); err != nil {
+		d.DecodeErrors.add("job_id", err)
+		return
+	}
+	if err = jd.SetJobAccount(j.Account); err != nil {
+		d.DecodeErrors.add("account", err)
+	}
+	if err = jd.SetJobUserName(j.UserName); err != nil {
+		d.DecodeErrors.add("user_name", err)
+	}
+	if err = jd.SetJobPartitionName(j.Partition); err != nil {
+		d.DecodeErrors.add("partition", err)
+	}
+	if err = jd.SetJobState(j.JobState); err != nil {
+		d.DecodeErrors.add("job_state", err)
+	}
+	if err = jd.SetJobDependency(j.Dependency); err != nil {
+		d.DecodeErrors.add("dependency", err)
+	}
+	if err = jd.SetJobCPUs(j.JobResources.Cpus); err != nil {
+		d.DecodeErrors.add("job_resources", err)
+	}
+	if err = jd.SetJobPriority(j.Priority.Number); err != nil {
+		d.DecodeErrors.add("priority", err)
+	}
+	// a job is only allocated resources once it starts
+	tresField, tres := "tres_alloc_str", j.TresAllocStr
+	if tres == nil || *tres == "" {
+		tresField, tres = "tres_req_str", j.TresReqStr
+	}
+	if err = jd.SetJobTres(tres); err != nil {
+		d.DecodeErrors.add(tresField, err)
+	}
+	if err = jd.SetJobArray(j.ArrayJobId.Number, j.ArrayTaskId.Number, j.ArrayTaskString, j.ArrayMaxTasks.Number); err != nil {
+		d.DecodeErrors.add("array_task_string", err)
+	}
+	if err = jd.SetJobHet(j.HetJobId.Number, j.HetJobOffset.Number); err != nil {
+		d.DecodeErrors.add("het_job_offset", err)
+	}
+	d.Jobs = append(d.Jobs, jd)
+}
+
 type PartitionsData struct {
-	ApiVersion string
-	Partitions []PartitionData
+	ApiVersion   string
+	Partitions   []PartitionData
+	DecodeErrors DecodeErrors
 }
 
 type PartitionData struct {
@@ -674,16 +1256,25 @@ func (d *PartitionsData) FromResponse(r PartitionsResp) error {
 	for _, p := range r.Partitions {
 		pd := PartitionData{}
 		if err = pd.SetName(p.Name); err != nil {
-			return err
+			d.DecodeErrors.add("name", err)
+			continue
 		}
-		if err = pd.SetTotalCPUs(p.Cpus.Total); err != nil {
-			return err
+		var totalCPUs *int32
+		if p.Cpus != nil {
+			totalCPUs = p.Cpus.Total
+		}
+		if err = pd.SetTotalCPUs(totalCPUs); err != nil {
+			d.DecodeErrors.add("cpus", err)
 		}
 		if err = pd.SetOtherCPUs(); err != nil {
-			return err
+			d.DecodeErrors.add("other_cpus", err)
 		}
-		if err = pd.SetNodeList(p.Nodes.Configured); err != nil {
-			return err
+		var configuredNodes *string
+		if p.Nodes != nil {
+			configuredNodes = p.Nodes.Configured
+		}
+		if err = pd.SetNodeList(configuredNodes); err != nil {
+			d.DecodeErrors.add("nodes", err)
 		}
 		d.Partitions = append(d.Partitions, pd)
 	}
@@ -692,8 +1283,9 @@ func (d *PartitionsData) FromResponse(r PartitionsResp) error {
 }
 
 type SharesData struct {
-	ApiVersion string
-	Shares     []ShareData
+	ApiVersion   string
+	Shares       []ShareData
+	DecodeErrors DecodeErrors
 }
 
 type ShareData struct {
@@ -717,32 +1309,39 @@ func (s *ShareData) SetName(name *string) error {
 
 func (s *ShareData) SetEffectiveUsage(effectiveUsage any) error {
 	// using any here because they changed it from a float64 to a struct
+	s.EffectiveUsage = 0
 	if effectiveUsage == nil {
-		s.EffectiveUsage = 0
 		return nil
 	}
 
-	// check if effectiveUsage is a float64
-	effectiveUsageFloat, ok := effectiveUsage.(float64)
-	if ok {
-		s.EffectiveUsage = effectiveUsageFloat
-		return nil
-	}
-	// check if effectiveUsage is a struct
+	// check if effectiveUsage is a float64, or a pointer to one
 	v := reflect.ValueOf(effectiveUsage)
 	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
 		v = v.Elem()
 	}
-	if v.Kind() == reflect.Struct {
-		// field Number should be a *float64
-		number := v.FieldByName("Number")
-		if number.Kind() == reflect.Ptr {
-			number = number.Elem()
-		}
-		if number.CanFloat() {
-			s.EffectiveUsage = number.Float()
+	if v.CanFloat() {
+		s.EffectiveUsage = v.Float()
+		return nil
+	}
+	// check if effectiveUsage is a struct
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("unexpected effective usage type in fair share: %s", v.Type())
+	}
+	// field Number should be a *float64
+	number := v.FieldByName("Number")
+	if number.Kind() == reflect.Ptr {
+		if number.IsNil() {
+			return nil
 		}
+		number = number.Elem()
+	}
+	if !number.IsValid() || !number.CanFloat() {
+		return fmt.Errorf("failed to find effective usage number in fair share")
 	}
+	s.EffectiveUsage = number.Float()
 	return nil
 }
 
@@ -751,10 +1350,11 @@ func (d *SharesData) FromResponse(r SharesResp) error {
 	for _, s := range r.Shares.Shares {
 		sd := ShareData{}
 		if err = sd.SetName(s.Name); err != nil {
-			return err
+			d.DecodeErrors.add("name", err)
+			continue
 		}
 		if err = sd.SetEffectiveUsage(s.EffectiveUsage); err != nil {
-			return err
+			d.DecodeErrors.add("effective_usage", err)
 		}
 
 		d.Shares = append(d.Shares, sd)
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestSetNodeStates(t *testing.T) {
+	tests := []struct {
+		states []string
+		base   types.NodeState
+		state  string
+	}{
+		{[]string{"IDLE"}, types.NodeStateIdle, "idle"},
+		{[]string{"ALLOCATED"}, types.NodeStateAlloc, "allocated"},
+		{[]string{"MIXED"}, types.NodeStateMix, "mixed"},
+		{[]string{"IDLE", "DRAIN"}, types.NodeStateIdle, "drained"},
+		{[]string{"MIXED", "DRAIN"}, types.NodeStateMix, "draining"},
+		{[]string{"IDLE", "DRAIN", "COMPLETING"}, types.NodeStateIdle, "draining"},
+		{[]string{"MIXED", "COMPLETING"}, types.NodeStateMix, "completing"},
+		{[]string{"IDLE", "COMPLETING"}, types.NodeStateIdle, "completing"},
+		{[]string{"IDLE", "POWERED_DOWN"}, types.NodeStateIdle, "idle~"},
+		{[]string{"IDLE", "POWERING_UP"}, types.NodeStateIdle, "idle#"},
+		{[]string{"DOWN", "NOT_RESPONDING"}, types.NodeStateDown, "down*"},
+		{[]string{"DOWN", "NOT_RESPONDING", "POWERED_DOWN"}, types.NodeStateDown, "down~"},
+		{[]string{"DOWN", "DRAIN", "NOT_RESPONDING"}, types.NodeStateDown, "drained*"},
+		{[]string{"ALLOCATED", "FAIL"}, types.NodeStateAlloc, "failing"},
+		{[]string{"IDLE", "FAIL"}, types.NodeStateIdle, "fail"},
+		{[]string{"IDLE", "MAINTENANCE"}, types.NodeStateIdle, "maint"},
+		{[]string{"ALLOCATED", "MAINTENANCE"}, types.NodeStateAlloc, "allocated$"},
+		{[]string{"IDLE", "REBOOT_ISSUED"}, types.NodeStateIdle, "reboot^"},
+		{[]string{"MIXED", "REBOOT_REQUESTED"}, types.NodeStateMix, "mixed@"},
+		{[]string{"IDLE", "RESERVED"}, types.NodeStateIdle, "reserved"},
+		{[]string{"IDLE", "PLANNED"}, types.NodeStateIdle, "planned"},
+		{[]string{"FUTURE", "CLOUD"}, types.NodeStateFuture, "future"},
+		{[]string{"DOWN", "INVALID_REG"}, types.NodeStateDown, "inval"},
+		{[]string{"INVALID"}, types.NodeStateUnknown, "unknown"},
+		{[]string{"idle", "drain"}, types.NodeStateIdle, "drained"},
+	}
+	for _, tc := range tests {
+		var n NodeData
+		if err := n.SetNodeStates(tc.states); err != nil {
+			t.Errorf("failed to set node states %v: %v", tc.states, err)
+		}
+		if n.BaseState != tc.base || n.State != tc.state {
+			t.Errorf("expected %v to be %s in %s, got %s in %s", tc.states, tc.state, tc.base, n.State, n.BaseState)
+		}
+	}
+
+	var n NodeData
+	if err := n.SetNodeStates([]string{"IDLE", "DRAIN", "HIBERNATING"}); err == nil {
+		t.Errorf("expected an error for an unknown flag")
+	}
+	if n.State != "drained" || !reflect.DeepEqual(n.Flags, []types.NodeFlag{types.NodeFlagDrain}) {
+		t.Errorf("expected the known flags to be kept, got %s %v", n.State, n.Flags)
+	}
+	if err := n.SetNodeStates(nil); err == nil || n.State != "unknown" {
+		t.Errorf("expected a node without a state to be unknown, got %s %v", n.State, err)
+	}
+}
+
+func TestLegacyNodeState(t *testing.T) {
+	tests := []struct {
+		states []string
+		legacy string
+	}{
+		{[]string{"IDLE", "DRAIN"}, "idle|drain"},
+		{[]string{"DOWN", "NOT_RESPONDING"}, "down|not_responding"},
+		{[]string{"ALLOCATED"}, "alloc"},
+		{[]string{"MIXED", "COMPLETING"}, "mix|comp"},
+		{[]string{"IDLE", "RESERVED", "POWERED_DOWN"}, "idle|resv|powered_down"},
+		{[]string{"DOWN", "INVALID_REG"}, "down|invalid"},
+		{[]string{"IDLE", "REBOOT_ISSUED"}, "idle|reboot_issued"},
+		{[]string{"FUTURE", "CLOUD"}, ""},
+	}
+	for _, tc := range tests {
+		var n NodeData
+		n.SetNodeStates(tc.states)
+		if n.LegacyState != tc.legacy {
+			t.Errorf("expected the legacy state of %v to be %q, got %q", tc.states, tc.legacy, n.LegacyState)
+		}
+	}
+}
+
+func TestSetJobTres(t *testing.T) {
+	tests := []struct {
+		tres               string
+		cpus, memory, gpus int64
+	}{
+		{"cpu=4,mem=16G,node=1,billing=4,gres/gpu=2", 4, 16384, 2},
+		{"cpu=1,mem=500M,node=1", 1, 500, 0},
+		{"cpu=128,mem=2T,node=2", 128, 2097152, 0},
+		{"cpu=1,mem=2048K", 1, 2, 0},
+		{"cpu=2,mem=1.5G", 2, 1536, 0},
+		{"cpu=2,mem=4096", 2, 4096, 0},
+		{"", 0, 0, 0},
+	}
+	for _, tc := range tests {
+		var j JobData
+		if err := j.SetJobTres(&tc.tres); err != nil {
+			t.Errorf("failed to set job tres %s: %v", tc.tres, err)
+		}
+		if j.TresCpus != tc.cpus || j.TresMemory != tc.memory || j.TresGPUs != tc.gpus {
+			t.Errorf("expected %s to be %d cpus, %dM and %d gpus, got %d cpus, %dM and %d gpus", tc.tres, tc.cpus, tc.memory, tc.gpus, j.TresCpus, j.TresMemory, j.TresGPUs)
+		}
+	}
+
+	var j JobData
+	for _, tres := range []string{"cpu=four", "cpu=1,mem=4X", "gres/gpu=two"} {
+		if err := j.SetJobTres(&tres); err == nil {
+			t.Errorf("expected an error for %s", tres)
+		}
+	}
+}
+
+func TestSetJobArray(t *testing.T) {
+	tests := []struct {
+		taskString     string
+		maxTasks       int32
+		tasks, wantMax int32
+	}{
+		{"", 0, 0, 0},
+		{"412-9999", 0, 9588, 0},
+		{"1-1000%10", 0, 1000, 10},
+		{"1-1000%10", 5, 1000, 5},
+		{"1,3,5-7", 0, 5, 0},
+		{"0-15:4", 0, 4, 0},
+		{"1-2,10-20:5%2", 0, 5, 2},
+	}
+	jobId, taskId := int32(100), int32(0)
+	for _, tc := range tests {
+		var j JobData
+		if err := j.SetJobArray(&jobId, &taskId, &tc.taskString, &tc.maxTasks); err != nil {
+			t.Errorf("failed to set job array %s: %v", tc.taskString, err)
+		}
+		if j.ArrayTasks != tc.tasks || j.ArrayMaxTasks != tc.wantMax {
+			t.Errorf("expected %s to be %d tasks limited to %d, got %d limited to %d", tc.taskString, tc.tasks, tc.wantMax, j.ArrayTasks, j.ArrayMaxTasks)
+		}
+		if want := max(tc.tasks, 1); j.Tasks() != want {
+			t.Errorf("expected %s to count as %d jobs, got %d", tc.taskString, want, j.Tasks())
+		}
+	}
+
+	var j JobData
+	for _, taskString := range []string{"array_task_string", "1-", "5-1", "1-10:0", "1-10%x", "1..."} {
+		if err := j.SetJobArray(&jobId, &taskId, &taskString, nil); err == nil {
+			t.Errorf("expected an error for %s", taskString)
+		}
+		if j.Tasks() != 1 {
+			t.Errorf("expected a job with an invalid task string to count once, got %d", j.Tasks())
+		}
+	}
+	// a job that isn't part of an array
+	zero := int32(0)
+	if err := j.SetJobArray(&zero, nil, nil, nil); err != nil || j.ArrayJobId != 0 || j.Tasks() != 1 {
+		t.Errorf("expected a job outside of an array to count once, got %d %v", j.Tasks(), err)
+	}
+}
+
+func TestSetJobHet(t *testing.T) {
+	var j JobData
+	id, offset := int32(10), int32(2)
+	if err := j.SetJobHet(&id, &offset); err != nil || j.HetJobId != 10 || j.HetJobOffset != 2 {
+		t.Errorf("expected component 2 of het job 10, got %d of %d: %v", j.HetJobOffset, j.HetJobId, err)
+	}
+	if err := j.SetJobHet(&id, nil); err == nil {
+		t.Errorf("expected an error for a component without an offset")
+	}
+	zero := int32(0)
+	if err := j.SetJobHet(&zero, &zero); err != nil || j.HetJobId != 0 {
+		t.Errorf("expected a job outside of a het job, got %d: %v", j.HetJobId, err)
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/util"
+)
+
+// Recording saves the raw slurmrestd responses of every poll so a problem seen
+// on a cluster can be replayed offline, and turned into testdata fixtures,
+// without access to the cluster. Each poll is saved to its own directory
+// named by the time of the poll, with a <endpoint>.json file per endpoint:
+//
+//	<dir>/20241015T120000.000000000Z/jobs.json
+//	<dir>/20241015T120000.000000000Z/nodes.json
+//	...
+
+const pollDirFormat = "20060102T150405.000000000Z"
+
+// Recorder writes the responses of each poll to a directory
+type Recorder struct {
+	dir        string
+	anonymizer *anonymizer
+
+	mu sync.Mutex
+}
+
+// NewRecorder returns a Recorder writing to dir, creating it if needed. If
+// anonymize is true, user, account and group names are replaced with
+// pseudonyms and free text fields like job commands and paths are cleared
+// before the responses are written.
+func NewRecorder(dir string, anonymize bool) (*Recorder, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record directory: %v", err)
+	}
+	r := &Recorder{dir: dir}
+	if anonymize {
+		r.anonymizer = newAnonymizer()
+	}
+	return r, nil
+}
+
+// Record writes the responses of one poll, keyed by endpoint name. A response
+// that fails to be written doesn't stop the others from being recorded.
+func (r *Recorder) Record(responses map[string][]byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pollDir := filepath.Join(r.dir, time.Now().UTC().Format(pollDirFormat))
+	err := os.MkdirAll(pollDir, 0750)
+	if err != nil {
+		return fmt.Errorf("failed to create poll directory: %v", err)
+	}
+	var errmsgs []string
+	for name, data := range responses {
+		if r.anonymizer != nil {
+			data, err = r.anonymizer.anonymize(data)
+			if err != nil {
+				// never fall back to writing the response as is
+				errmsgs = append(errmsgs, fmt.Sprintf("failed to anonymize %s response: %v", name, err))
+				continue
+			}
+		}
+		err = os.WriteFile(filepath.Join(pollDir, name+".json"), data, 0640)
+		if err != nil {
+			errmsgs = append(errmsgs, fmt.Sprintf("failed to write %s response: %v", name, err))
+		}
+	}
+	if len(errmsgs) > 0 {
+		sort.Strings(errmsgs)
+		return fmt.Errorf("%s", strings.Join(errmsgs, ", "))
+	}
+	return nil
+}
+
+// Replayer serves recorded responses instead of querying slurmrestd. Every
+// poll reads the next recorded poll, starting over after the last one.
+type Replayer struct {
+	polls []string
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewReplayer returns a Replayer for dir, which is either a directory written
+// by a Recorder, or a single poll directory containing <endpoint>.json files.
+func NewReplayer(dir string) (*Replayer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay directory: %v", err)
+	}
+	var polls []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			// a single poll
+			return &Replayer{polls: []string{dir}}, nil
+		}
+		if e.IsDir() {
+			polls = append(polls, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(polls) == 0 {
+		return nil, fmt.Errorf("no recorded polls found in %s", dir)
+	}
+	// the poll directory names sort by time
+	sort.Strings(polls)
+	return &Replayer{polls: polls}, nil
+}
+
+// Next returns the directory of the next poll to replay
+func (r *Replayer) Next() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	poll := r.polls[r.next]
+	r.next = (r.next + 1) % len(r.polls)
+	return poll
+}
+
+// Read returns the recorded response of an endpoint in a poll directory
+func (r *Replayer) Read(poll string, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(poll, name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("no recorded %s response in %s: %v", name, poll, err)
+	}
+	return data, nil
+}
+
+// anonymizer replaces identifying values in responses. Names are replaced
+// with stable pseudonyms (user1, account1, ...) for the life of the
+// exporter, so recorded polls stay consistent with each other and the same
+// user in the jobs and shares responses maps to the same pseudonym.
+type anonymizer struct {
+	names map[string]map[string]string
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{names: make(map[string]map[string]string)}
+}
+
+// fields holding names, mapped to the kind of pseudonym used to replace them
+var anonymizedFields = map[string]string{
+	"user":               "user",
+	"user_name":          "user",
+	"mail_user":          "user",
+	"owner":              "user",
+	"reason_set_by_user": "user",
+	"account":            "account",
+	"group":              "group",
+	"group_name":         "group",
+}
+
+// free text fields that may contain anything, including paths with user names
+var clearedFields = map[string]bool{
+	"command":                   true,
+	"comment":                   true,
+	"admin_comment":             true,
+	"system_comment":            true,
+	"extra":                     true,
+	"standard_error":            true,
+	"standard_input":            true,
+	"standard_output":           true,
+	"current_working_directory": true,
+	"source":                    true,
+}
+
+func (a *anonymizer) anonymize(data []byte) ([]byte, error) {
+	// the shares response can contain bare Infinity values
+	data = util.CleanseInfinity(data)
+	d := json.NewDecoder(bytes.NewReader(data))
+	// keep numbers as they were, float64 would lose precision on large ids
+	d.UseNumber()
+	var v any
+	err := d.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(a.walk(v))
+}
+
+func (a *anonymizer) walk(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			s, isString := child.(string)
+			switch {
+			case isString && clearedFields[k]:
+				v[k] = ""
+			case isString && anonymizedFields[k] != "":
+				v[k] = a.pseudonym(anonymizedFields[k], s)
+			default:
+				v[k] = a.walk(child)
+			}
+		}
+		a.anonymizeNames(v)
+	case []any:
+		for i := range v {
+			v[i] = a.walk(v[i])
+		}
+	}
+	return v
+}
+
+// anonymizeNames handles the "name" fields that identify someone, which can
+// only be told apart from node or partition names by the object they're in.
+func (a *anonymizer) anonymizeNames(v map[string]any) {
+	name, ok := v["name"].(string)
+	if !ok {
+		return
+	}
+	// jobs
+	if _, ok := v["job_id"]; ok {
+		v["name"] = a.pseudonym("job", name)
+		return
+	}
+	// shares, which are either users or accounts
+	if _, ok := v["shares_normalized"]; ok {
+		kind := "account"
+		if types, ok := v["type"].([]any); ok {
+			for _, t := range types {
+				if t == "USER" {
+					kind = "user"
+				}
+			}
+		}
+		v["name"] = a.pseudonym(kind, name)
+		if parent, ok := v["parent"].(string); ok {
+			v["parent"] = a.pseudonym("account", parent)
+		}
+	}
+}
+
+func (a *anonymizer) pseudonym(kind string, value string) string {
+	if value == "" {
+		return ""
+	}
+	names, ok := a.names[kind]
+	if !ok {
+		names = make(map[string]string)
+		a.names[kind] = names
+	}
+	p, ok := names[value]
+	if !ok {
+		p = fmt.Sprintf("%s%d", kind, len(names)+1)
+		names[value] = p
+	}
+	return p
+}
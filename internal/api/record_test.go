@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	dir := t.TempDir()
+
+	recorder, err := NewRecorder(dir, false)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+	ctx = context.WithValue(ctx, types.ApiRecorderKey, recorder)
+	if err := PopulateCache(ctx); err != nil {
+		t.Fatalf("failed to populate cache: %v", err)
+	}
+	recorded := CurrentSnapshot(ctx).Jobs
+	WipeCache(ctx)
+
+	polls, _ := os.ReadDir(dir)
+	if len(polls) != 1 {
+		t.Fatalf("expected 1 recorded poll, got %d", len(polls))
+	}
+
+	// replaying must not touch slurmrestd
+	s.SetFault("jobs", fakeslurm.Fault{StatusCode: 500})
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("failed to create replayer: %v", err)
+	}
+	ctx = context.WithValue(ctx, types.ApiRecorderKey, (*Recorder)(nil))
+	ctx = context.WithValue(ctx, types.ApiReplayerKey, replayer)
+	if err := PopulateCache(ctx); err != nil {
+		t.Fatalf("failed to populate cache from replay: %v", err)
+	}
+	replayed := CurrentSnapshot(ctx).Jobs
+	if recorded == nil || !reflect.DeepEqual(recorded, replayed) {
+		t.Fatalf("replayed jobs response differs from the recorded one")
+	}
+}
+
+func TestRecordAnonymized(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	dir := t.TempDir()
+
+	recorder, err := NewRecorder(dir, true)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+	ctx = context.WithValue(ctx, types.ApiRecorderKey, recorder)
+	if err := PopulateCache(ctx); err != nil {
+		t.Fatalf("failed to populate cache: %v", err)
+	}
+	polls, _ := os.ReadDir(dir)
+	if len(polls) != 1 {
+		t.Fatalf("expected 1 recorded poll, got %d", len(polls))
+	}
+	recorded, _ := os.ReadDir(filepath.Join(dir, polls[0].Name()))
+	if len(recorded) != len(endpoints) {
+		t.Fatalf("expected %d recorded responses, got %d", len(endpoints), len(recorded))
+	}
+	want := CurrentSnapshot(ctx).Jobs
+
+	// the anonymized responses must still be usable as fixtures
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("failed to create replayer: %v", err)
+	}
+	b, err := replayer.Read(replayer.Next(), "jobs")
+	if err != nil {
+		t.Fatalf("failed to read recorded jobs response: %v", err)
+	}
+	got, err := ProcessJobsResponse(b)
+	if err != nil {
+		t.Fatalf("failed to process anonymized jobs response: %v", err)
+	}
+	if len(got.Jobs) != len(want.Jobs) {
+		t.Fatalf("expected %d jobs after anonymizing, got %d", len(want.Jobs), len(got.Jobs))
+	}
+	if got.Jobs[0].UserName == want.Jobs[0].UserName {
+		t.Fatalf("expected user name %s to be anonymized", want.Jobs[0].UserName)
+	}
+}
+
+func TestReplayerSinglePoll(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "jobs.json"), []byte(`{}`), 0640)
+
+	r, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("failed to create replayer: %v", err)
+	}
+	if r.Next() != dir || r.Next() != dir {
+		t.Fatalf("expected every poll to replay %s", dir)
+	}
+	if _, err := r.Read(dir, "nodes"); err == nil {
+		t.Fatalf("expected an error reading a missing response")
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	a := newAnonymizer()
+	jobs := []byte(`{"jobs": [
+		{"job_id": 1, "name": "secret-project", "user_name": "alice", "account": "physics", "command": "/home/alice/run.sh"},
+		{"job_id": 2, "name": "other", "user_name": "bob", "account": "physics", "command": "/home/bob/run.sh"}
+	]}`)
+	shares := []byte(`{"shares": {"shares": [
+		{"name": "alice", "parent": "physics", "type": ["USER"], "shares_normalized": {"number": 1}}
+	]}}`)
+
+	b, err := a.anonymize(jobs)
+	if err != nil {
+		t.Fatalf("failed to anonymize jobs: %v", err)
+	}
+	for _, s := range []string{"alice", "bob", "physics", "secret-project", "/home"} {
+		if bytes.Contains(b, []byte(s)) {
+			t.Fatalf("anonymized jobs still contain %q: %s", s, b)
+		}
+	}
+
+	b, err = a.anonymize(shares)
+	if err != nil {
+		t.Fatalf("failed to anonymize shares: %v", err)
+	}
+	// the same names must map to the same pseudonyms across responses
+	alice := a.pseudonym("user", "alice")
+	physics := a.pseudonym("account", "physics")
+	if !bytes.Contains(b, []byte(`"name":"`+alice+`"`)) || !bytes.Contains(b, []byte(`"parent":"`+physics+`"`)) {
+		t.Fatalf("expected shares to use pseudonyms %s and %s: %s", alice, physics, b)
+	}
+}
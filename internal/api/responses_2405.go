@@ -18,20 +18,88 @@ type DiagResp struct {
 		BfBackfilledJobs       *int32 `json:"bf_backfilled_jobs"`
 		BfLastBackfilledJobs   *int32 `json:"bf_last_backfilled_jobs"`
 		BfBackfilledHetJobs    *int32 `json:"bf_backfilled_het_jobs"`
+		ReqTime                struct {
+			Number *int64 `json:"number"`
+		} `json:"req_time"`
+		ReqTimeStart struct {
+			Number *int64 `json:"number"`
+		} `json:"req_time_start"`
+		GettimeofdayLatency *int32 `json:"gettimeofday_latency"`
+		ScheduleCycleMax    *int32 `json:"schedule_cycle_max"`
+		ScheduleCycleTotal  *int32 `json:"schedule_cycle_total"`
+		ScheduleQueueLength *int32 `json:"schedule_queue_length"`
+		JobsSubmitted       *int32 `json:"jobs_submitted"`
+		JobsStarted         *int32 `json:"jobs_started"`
+		JobsCompleted       *int32 `json:"jobs_completed"`
+		JobsCanceled        *int32 `json:"jobs_canceled"`
+		JobsFailed          *int32 `json:"jobs_failed"`
+		JobsPending         *int32 `json:"jobs_pending"`
+		JobsRunning         *int32 `json:"jobs_running"`
+		BfCycleCounter      *int32 `json:"bf_cycle_counter"`
+		BfCycleMax          *int32 `json:"bf_cycle_max"`
+		BfQueueLen          *int32 `json:"bf_queue_len"`
+		BfTableSize         *int32 `json:"bf_table_size"`
+		BfWhenLastCycle     struct {
+			Number *int64 `json:"number"`
+		} `json:"bf_when_last_cycle"`
+		BfActive          *bool `json:"bf_active"`
+		RpcsByMessageType []struct {
+			MessageType *string `json:"message_type"`
+			Count       *int64  `json:"count"`
+			TotalTime   *int64  `json:"total_time"`
+		} `json:"rpcs_by_message_type"`
+		RpcsByUser []struct {
+			User      *string `json:"user"`
+			Count     *int64  `json:"count"`
+			TotalTime *int64  `json:"total_time"`
+		} `json:"rpcs_by_user"`
+		PendingRpcs []struct {
+			MessageType *string `json:"message_type"`
+			Count       *int32  `json:"count"`
+		} `json:"pending_rpcs"`
 	} `json:"statistics"`
 }
 
 type JobsResp struct {
-	Jobs []struct {
-		Account      *string  `json:"account"`
-		UserName     *string  `json:"user_name"`
-		Partition    *string  `json:"partition"`
-		JobState     []string `json:"job_state"`
-		Dependency   *string  `json:"dependency"`
-		JobResources struct {
-			Cpus *int32 `json:"cpus"`
-		} `json:"job_resources"`
-	} `json:"jobs"`
+	Jobs       []JobResp `json:"jobs"`
+	LastUpdate struct {
+		Number *int64 `json:"number"`
+	} `json:"last_update"`
+}
+
+// JobResp is a job in the jobs response. Only the fields the collectors use are
+// decoded, the rest of each job is skipped.
+type JobResp struct {
+	JobId        *int32   `json:"job_id"`
+	Account      *string  `json:"account"`
+	UserName     *string  `json:"user_name"`
+	Partition    *string  `json:"partition"`
+	JobState     []string `json:"job_state"`
+	Dependency   *string  `json:"dependency"`
+	TresReqStr   *string  `json:"tres_req_str"`
+	TresAllocStr *string  `json:"tres_alloc_str"`
+	ArrayJobId   struct {
+		Number *int32 `json:"number"`
+	} `json:"array_job_id"`
+	ArrayTaskId struct {
+		Number *int32 `json:"number"`
+	} `json:"array_task_id"`
+	ArrayTaskString *string `json:"array_task_string"`
+	ArrayMaxTasks   struct {
+		Number *int32 `json:"number"`
+	} `json:"array_max_tasks"`
+	HetJobId struct {
+		Number *int32 `json:"number"`
+	} `json:"het_job_id"`
+	HetJobOffset struct {
+		Number *int32 `json:"number"`
+	} `json:"het_job_offset"`
+	Priority struct {
+		Number *int64 `json:"number"`
+	} `json:"priority"`
+	JobResources struct {
+		Cpus *int32 `json:"cpus"`
+	} `json:"job_resources"`
 }
 
 type NodesResp struct {
@@ -48,6 +116,9 @@ type NodesResp struct {
 		AllocIdleCpus *int32   `json:"alloc_idle_cpus,omitempty"`
 		Cpus          *int32   `json:"cpus,omitempty"`
 	} `json:"nodes"`
+	LastUpdate struct {
+		Number *int64 `json:"number"`
+	} `json:"last_update"`
 }
 
 type PartitionsResp struct {
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// Status keeps track of the result of every slurmrestd poll, so the health
+// endpoints can report on the connection to slurmrestd without making any
+// requests themselves.
+type Status struct {
+	mu           sync.Mutex
+	started      time.Time
+	lastPoll     *time.Time
+	slurmRelease string
+	slurmCluster string
+	endpoints    map[string]*EndpointStatus
+}
+
+// EndpointStatus is the result of the polls of one endpoint
+type EndpointStatus struct {
+	Name          string     `json:"name"`
+	Path          string     `json:"path"`
+	Ok            bool       `json:"ok"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	LastDuration  float64    `json:"last_duration_seconds"`
+	// DecodeErrors counts the fields of the responses that couldn't be
+	// decoded since the exporter started, by field name
+	DecodeErrors map[string]int `json:"decode_errors,omitempty"`
+}
+
+// StatusReport is the JSON representation of the exporter status
+type StatusReport struct {
+	Ready        bool              `json:"ready"`
+	Started      time.Time         `json:"started"`
+	LastPoll     *time.Time        `json:"last_poll,omitempty"`
+	ApiVersion   string            `json:"api_version"`
+	SlurmRelease string            `json:"slurm_release,omitempty"`
+	SlurmCluster string            `json:"slurm_cluster,omitempty"`
+	Endpoints    []EndpointStatus  `json:"endpoints"`
+	Config       map[string]string `json:"config,omitempty"`
+}
+
+func NewStatus() *Status {
+	s := &Status{
+		started:   time.Now(),
+		endpoints: make(map[string]*EndpointStatus),
+	}
+	for _, e := range endpoints {
+		s.endpoints[e.name] = &EndpointStatus{Name: e.name, Path: e.path}
+	}
+	return s
+}
+
+// statusFromContext returns the Status stored in the context, or nil
+func statusFromContext(ctx context.Context) *Status {
+	s, _ := ctx.Value(types.ApiStatusKey).(*Status)
+	return s
+}
+
+// metaResp is the part of the response metadata that is the same in every
+// endpoint and api version
+type metaResp struct {
+	Meta struct {
+		Slurm struct {
+			Release string `json:"release"`
+			Cluster string `json:"cluster"`
+		} `json:"slurm"`
+	} `json:"meta"`
+}
+
+// record saves the result of polling an endpoint
+func (s *Status) record(name string, data []byte, err error, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.lastPoll = &now
+	es, ok := s.endpoints[name]
+	if !ok {
+		return
+	}
+	es.LastDuration = duration.Seconds()
+	if err != nil {
+		es.Ok = false
+		es.LastError = err.Error()
+		es.LastErrorTime = &now
+		return
+	}
+	es.Ok = true
+	es.LastSuccess = &now
+	if name == "diag" {
+		var m metaResp
+		if json.Unmarshal(data, &m) == nil {
+			s.slurmRelease = m.Meta.Slurm.Release
+			s.slurmCluster = m.Meta.Slurm.Cluster
+		}
+	}
+}
+
+// recordDecodeErrors adds the fields that couldn't be decoded in a response
+// of an endpoint to its counts
+func (s *Status) recordDecodeErrors(name string, errs DecodeErrors) {
+	if len(errs) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	es, ok := s.endpoints[name]
+	if !ok {
+		return
+	}
+	if es.DecodeErrors == nil {
+		es.DecodeErrors = make(map[string]int)
+	}
+	for field, n := range errs {
+		es.DecodeErrors[field] += n
+	}
+}
+
+// Ready returns true if the last poll of every endpoint succeeded
+func (s *Status) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready()
+}
+
+func (s *Status) ready() bool {
+	if s.lastPoll == nil {
+		return false
+	}
+	for _, es := range s.endpoints {
+		if !es.Ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Report returns a copy of the status, with the endpoints sorted by name
+func (s *Status) Report() StatusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := StatusReport{
+		Ready:        s.ready(),
+		Started:      s.started,
+		LastPoll:     s.lastPoll,
+		ApiVersion:   apiVersion,
+		SlurmRelease: s.slurmRelease,
+		SlurmCluster: s.slurmCluster,
+	}
+	for _, es := range s.endpoints {
+		e := *es
+		e.DecodeErrors = maps.Clone(es.DecodeErrors)
+		r.Endpoints = append(r.Endpoints, e)
+	}
+	sort.Slice(r.Endpoints, func(i, j int) bool {
+		return r.Endpoints[i].Name < r.Endpoints[j].Name
+	})
+	return r
+}
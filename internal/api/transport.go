@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
@@ -25,6 +26,26 @@ type SlurmRestResponse struct {
 
 // GetSlurmRestResponse retrieves response data from slurm api
 func GetSlurmRestResponse(ctx context.Context, endpointCtxKey types.Key) ([]byte, error) {
+	return getSlurmRestResponse(ctx, endpointCtxKey, nil)
+}
+
+func getSlurmRestResponse(ctx context.Context, endpointCtxKey types.Key, query url.Values) ([]byte, error) {
+	var body []byte
+	err := StreamSlurmRestResponse(ctx, endpointCtxKey, query, func(r io.Reader) error {
+		var err error
+		body, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// StreamSlurmRestResponse retrieves response data from slurm api and passes the
+// body to decode as it is read, so large responses are never held in memory
+// whole. query is added to the request url, it may be nil.
+func StreamSlurmRestResponse(ctx context.Context, endpointCtxKey types.Key, query url.Values, decode func(r io.Reader) error) error {
 	var endpointStr string
 	switch endpointCtxKey {
 	case types.ApiDiagEndpointKey:
@@ -38,16 +59,16 @@ func GetSlurmRestResponse(ctx context.Context, endpointCtxKey types.Key) ([]byte
 	case types.ApiSharesEndpointKey:
 		endpointStr = "shares"
 	default:
-		return nil, fmt.Errorf("invalid endpoint key")
+		return fmt.Errorf("invalid endpoint key")
 	}
 	slog.Debug("performing rest request", "endpoint", endpointStr)
-	nr, err := newSlurmRestRequest(ctx, endpointCtxKey)
+	nr, err := newSlurmRestRequest(ctx, endpointCtxKey, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate new slurm rest request: %v", err)
+		return fmt.Errorf("failed to generate new slurm rest request: %v", err)
 	}
-	resp, err := nr.Send()
+	resp, err := nr.Send(decode)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve slurm rest response: %v", err)
+		return fmt.Errorf("failed to retrieve slurm rest response: %v", err)
 	}
 	// sometimes slurm fails to get stuff. we want to error here
 	if resp.StatusCode == 500 {
@@ -61,33 +82,43 @@ func GetSlurmRestResponse(ctx context.Context, endpointCtxKey types.Key) ([]byte
 			errStr = "tried to get more data about the error but failed. try debug mode for more information"
 		}
 		errStr = aed.ToString()
-		return nil, fmt.Errorf("internal server error (500) from slurm controller getting %s data: %s", endpointStr, errStr)
+		return fmt.Errorf("internal server error (500) from slurm controller getting %s data: %s", endpointStr, errStr)
 	}
 	// unauthorized responses should say that
 	if resp.StatusCode == 401 {
-		return nil, fmt.Errorf("unauthorized: invalid credentials")
+		return fmt.Errorf("unauthorized: invalid credentials")
 	}
 	// otherwise, it should be status 200, so this catches unsupported status codes
 	if resp.StatusCode != 200 {
 		slog.Debug("incorrect response status code", "endpoint", endpointStr, "code", resp.StatusCode, "body", string(resp.Body))
-		return nil, fmt.Errorf("received incorrect status code for %s data", endpointStr)
+		return fmt.Errorf("received incorrect status code for %s data", endpointStr)
 	}
 	slog.Debug("successfully queried slurm rest data", "endpoint", endpointStr)
-	return resp.Body, nil
+	return nil
 }
 
 // newSlurmRestRequest returns a new slurmRestRequest object which is used to perform
 // http interactions with the slurmrest server. It configures everything up until
 // the request is actually sent to get data.
-func newSlurmRestRequest(ctx context.Context, k types.Key) (*slurmRestRequest, error) {
+func newSlurmRestRequest(ctx context.Context, k types.Key, query url.Values) (*slurmRestRequest, error) {
 	apiURL := ctx.Value(types.ApiURLKey).(string)
 
+	var sr *slurmRestRequest
+	var err error
 	if strings.HasPrefix(apiURL, "unix://") {
-		return newSlurmUnixRestRequest(ctx, k)
+		sr, err = newSlurmUnixRestRequest(ctx, k)
 	} else if strings.HasPrefix(apiURL, "http://") || strings.HasPrefix(apiURL, "https://") {
-		return newSlurmInetRestRequest(ctx, k)
+		sr, err = newSlurmInetRestRequest(ctx, k)
+	} else {
+		return nil, fmt.Errorf("invalid SLURM_EXPORTER_API_URL: %s", apiURL)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("invalid SLURM_EXPORTER_API_URL: %s", apiURL)
+	if len(query) > 0 {
+		sr.req.URL.RawQuery = query.Encode()
+	}
+	return sr, nil
 }
 
 func newSlurmInetRestRequest(ctx context.Context, k types.Key) (*slurmRestRequest, error) {
@@ -97,7 +128,8 @@ func newSlurmInetRestRequest(ctx context.Context, k types.Key) (*slurmRestReques
 	apiEndpoint := ctx.Value(k).(string)
 
 	url := fmt.Sprintf("%s/%s", apiURL, apiEndpoint)
-	req, err := http.NewRequest("GET", url, nil)
+	// requests are cancelled with the context when the exporter shuts down
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +149,7 @@ func newSlurmUnixRestRequest(ctx context.Context, k types.Key) (*slurmRestReques
 
 	socketPath := strings.TrimPrefix(apiURL, "unix:")
 	url := fmt.Sprintf("http://unix/%s", apiEndpoint)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +160,8 @@ func newSlurmUnixRestRequest(ctx context.Context, k types.Key) (*slurmRestReques
 		client: &http.Client{
 			Transport: &http.Transport{
 				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-					return net.Dial("unix", socketPath)
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
 				},
 				DisableKeepAlives: true,
 			},
@@ -138,22 +171,29 @@ func newSlurmUnixRestRequest(ctx context.Context, k types.Key) (*slurmRestReques
 
 // slurmRestRequest.Send is used to perform the request against the slurmrest
 // server. It returns a *SlurmRestResponse which is a struct containing the
-// response status code and the bytes of the response body.
-func (sr slurmRestRequest) Send() (*SlurmRestResponse, error) {
+// response status code, and the bytes of the response body if the request
+// failed. The body of a successful response is passed to decode as it is
+// read instead.
+func (sr slurmRestRequest) Send(decode func(r io.Reader) error) (*SlurmRestResponse, error) {
 	resp, err := sr.client.Do(sr.req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	sresp := SlurmRestResponse{}
+	sresp.StatusCode = resp.StatusCode
+	if resp.StatusCode != 200 {
+		sresp.Body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %v", err)
+		}
+		return &sresp, nil
+	}
+	err = decode(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
 
-	sresp := SlurmRestResponse{}
-	sresp.StatusCode = resp.StatusCode
-	sresp.Body = body
-
 	return &sresp, nil
 }
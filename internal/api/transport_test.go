@@ -1 +1,81 @@
 package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func newTestContext(url string, user string, token string) context.Context {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, types.ApiUserKey, user)
+	ctx = context.WithValue(ctx, types.ApiTokenKey, token)
+	ctx = context.WithValue(ctx, types.ApiURLKey, url)
+	return RegisterEndpoints(ctx)
+}
+
+func TestGetSlurmRestResponse(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	ctx := newTestContext(s.URL, "slurm", "secret")
+
+	b, err := GetSlurmRestResponse(ctx, types.ApiJobsEndpointKey)
+	if err != nil {
+		t.Fatalf("failed to get jobs response: %v", err)
+	}
+	if _, err := ProcessJobsResponse(b); err != nil {
+		t.Fatalf("failed to process jobs response: %v", err)
+	}
+	if s.Requests("jobs") != 1 {
+		t.Fatalf("expected 1 jobs request, got %d", s.Requests("jobs"))
+	}
+}
+
+func TestGetSlurmRestResponseUnix(t *testing.T) {
+	s, err := fakeslurm.NewUnixServer()
+	if err != nil {
+		t.Fatalf("failed to start unix server: %v", err)
+	}
+	defer s.Close()
+	ctx := newTestContext(s.URL, "", "")
+
+	b, err := GetSlurmRestResponse(ctx, types.ApiNodesEndpointKey)
+	if err != nil {
+		t.Fatalf("failed to get nodes response: %v", err)
+	}
+	if _, err := ProcessNodesResponse(b); err != nil {
+		t.Fatalf("failed to process nodes response: %v", err)
+	}
+}
+
+func TestGetSlurmRestResponseErrors(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+
+	_, err := GetSlurmRestResponse(newTestContext(s.URL, "slurm", "wrong"), types.ApiDiagEndpointKey)
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	s.SetFault("diag", fakeslurm.Fault{
+		StatusCode: 500,
+		Body:       []byte(`{"errors": [{"description": "slurmctld down", "error_number": 1007, "error": "Unable to contact slurm controller", "source": "slurm_get_statistics"}]}`),
+	})
+	_, err = GetSlurmRestResponse(ctx, types.ApiDiagEndpointKey)
+	if err == nil || !strings.Contains(err.Error(), "slurmctld down") {
+		t.Fatalf("expected internal server error, got %v", err)
+	}
+
+	s.SetFault("diag", fakeslurm.Fault{Truncate: true})
+	b, err := GetSlurmRestResponse(ctx, types.ApiDiagEndpointKey)
+	if err != nil {
+		t.Fatalf("failed to get truncated diag response: %v", err)
+	}
+	if _, err := ProcessDiagResponse(b); err == nil {
+		t.Fatalf("expected truncated diag response to fail processing")
+	}
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/util"
@@ -25,20 +28,99 @@ func ProcessDiagResponse(b []byte) (*DiagData, error) {
 
 // ProcessJobsResponse converts the response bytes into a slurm type
 func ProcessJobsResponse(b []byte) (*JobsData, error) {
-	var r JobsResp
 	if len(b) == 0 {
 		return nil, fmt.Errorf("failed to unmarshal jobs response, body is empty")
 	}
-	err := json.Unmarshal(b, &r)
+	return DecodeJobsResponse(bytes.NewReader(b))
+}
+
+// DecodeJobsResponse decodes a jobs response as it is read, one job at a time,
+// so only the fields the collectors use are held in memory instead of the
+// whole response. Malformed jobs are counted in the DecodeErrors of the jobs
+// instead of failing the response.
+func DecodeJobsResponse(r io.Reader) (*JobsData, error) {
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err == io.EOF {
+		return nil, fmt.Errorf("failed to unmarshal jobs response, body is empty")
+	}
 	if err != nil {
-		slog.Debug("failed to unmarshal jobs response", "body", string(b))
 		return nil, fmt.Errorf("failed to unmarshall jobs response data: %v", err)
 	}
+	if t != json.Delim('{') {
+		return nil, fmt.Errorf("failed to unmarshall jobs response data: expected an object")
+	}
 	d := NewJobsData()
-	d.FromResponse(r)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshall jobs response data: %v", err)
+		}
+		if t == "last_update" {
+			var lastUpdate struct {
+				Number *int64 `json:"number"`
+			}
+			if err := dec.Decode(&lastUpdate); err != nil {
+				return nil, fmt.Errorf("failed to unmarshall jobs response data: %v", err)
+			}
+			if lastUpdate.Number != nil {
+				d.LastUpdate = *lastUpdate.Number
+			}
+			continue
+		}
+		if t != "jobs" {
+			// meta, errors and warnings are small, skip them whole
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("failed to unmarshall jobs response data: %v", err)
+			}
+			continue
+		}
+		if err := decodeJobs(dec, d); err != nil {
+			return nil, fmt.Errorf("failed to unmarshall jobs response data: %v", err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("failed to unmarshall jobs response data: %v", err)
+	}
 	return d, nil
 }
 
+// decodeJobs decodes the jobs array element by element
+func decodeJobs(dec *json.Decoder, d *JobsData) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return nil
+	}
+	if t != json.Delim('[') {
+		return fmt.Errorf("expected jobs to be an array")
+	}
+	for dec.More() {
+		// a job with a field of the wrong type is skipped, only a
+		// response that isn't valid json fails
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		var j JobResp
+		if err := json.Unmarshal(raw, &j); err != nil {
+			field := "job"
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field != "" {
+				field = typeErr.Field
+			}
+			d.DecodeErrors.add(field, err)
+			continue
+		}
+		d.addJob(j)
+	}
+	_, err = dec.Token()
+	return err
+}
+
 // ProcessNodesResponse converts the response bytes into a slurm type
 func ProcessNodesResponse(b []byte) (*NodesData, error) {
 	var r NodesResp
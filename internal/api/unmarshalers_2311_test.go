@@ -54,3 +54,23 @@ func TestUnmarshalSharesResponse(t *testing.T) {
 		t.Fatalf("failed to unmarshal shares response: %v\n", err)
 	}
 }
+
+func TestProcessDiagResponse(t *testing.T) {
+	fb := util.ReadTestDataBytes("V0040OpenapiDiagResp.json")
+	d, err := ProcessDiagResponse(fb)
+	if err != nil {
+		t.Fatalf("failed to process diag response: %v\n", err)
+	}
+	if d.JobsSubmitted != 2 {
+		t.Fatalf("expected 2 jobs submitted, got %d\n", d.JobsSubmitted)
+	}
+	if d.BfCycleMax != 0 {
+		t.Fatalf("expected backfill max cycle of 0, got %d\n", d.BfCycleMax)
+	}
+	if len(d.PendingRpcs) != 0 {
+		t.Fatalf("expected 0 pending rpc entries, got %d\n", len(d.PendingRpcs))
+	}
+	if len(d.RpcsByUser) == 0 {
+		t.Fatalf("expected rpc statistics by user\n")
+	}
+}
@@ -54,3 +54,23 @@ func TestUnmarshalSharesResponse(t *testing.T) {
 		t.Fatalf("failed to unmarshal shares response: %v\n", err)
 	}
 }
+
+func TestProcessDiagResponse(t *testing.T) {
+	fb := util.ReadTestDataBytes("SlurmV0041GetDiag200Response.json")
+	d, err := ProcessDiagResponse(fb)
+	if err != nil {
+		t.Fatalf("failed to process diag response: %v\n", err)
+	}
+	if d.JobsSubmitted != 9 {
+		t.Fatalf("expected 9 jobs submitted, got %d\n", d.JobsSubmitted)
+	}
+	if d.BfCycleMax != 4 {
+		t.Fatalf("expected backfill max cycle of 4, got %d\n", d.BfCycleMax)
+	}
+	if len(d.PendingRpcs) != 2 {
+		t.Fatalf("expected 2 pending rpc entries, got %d\n", len(d.PendingRpcs))
+	}
+	if len(d.RpcsByUser) == 0 {
+		t.Fatalf("expected rpc statistics by user\n")
+	}
+}
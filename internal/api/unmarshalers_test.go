@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// unmarshalJobsResponse reads the whole response before unmarshalling it, the
+// way every response was decoded before the jobs were streamed
+func unmarshalJobsResponse(r io.Reader) (*JobsData, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var resp JobsResp
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+	d := NewJobsData()
+	d.FromResponse(resp)
+	return d, nil
+}
+
+func TestDecodeJobsResponse(t *testing.T) {
+	fixture, err := fakeslurm.SyntheticJobs(100)
+	if err != nil {
+		t.Fatalf("failed to generate jobs: %v", err)
+	}
+	want, err := unmarshalJobsResponse(bytes.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("failed to unmarshal jobs: %v", err)
+	}
+	r, _ := fakeslurm.SyntheticJobsReader(100)
+	got, err := DecodeJobsResponse(r)
+	if err != nil {
+		t.Fatalf("failed to decode jobs: %v", err)
+	}
+	if len(got.Jobs) != 100 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the streamed jobs to match the unmarshalled ones")
+	}
+
+	// the jobs can come before or after the rest of the response
+	got, err = DecodeJobsResponse(strings.NewReader(`{"jobs": [], "meta": {"plugin": {"type": "openapi/v0.0.41"}}, "warnings": []}`))
+	if err != nil || len(got.Jobs) != 0 {
+		t.Fatalf("expected an empty jobs list, got %v %v", got, err)
+	}
+	if _, err := DecodeJobsResponse(strings.NewReader(`{"jobs": null}`)); err != nil {
+		t.Fatalf("expected null jobs to decode, got %v", err)
+	}
+
+	for _, body := range []string{"", "[]", `{"jobs": {}}`, `{"jobs": [{"job_id": 1}`, string(fixture[:len(fixture)/2])} {
+		if _, err := DecodeJobsResponse(strings.NewReader(body)); err == nil {
+			t.Fatalf("expected an error decoding %.40q", body)
+		}
+	}
+}
+
+func TestDecodeMalformedJobs(t *testing.T) {
+	tests := []struct {
+		mutate func(job map[string]any)
+		state  types.JobState
+	}{
+		{func(job map[string]any) { job["job_state"] = []string{"PENDING", "REQUEUED"} }, types.JobStateRequeued},
+		{func(job map[string]any) { job["job_state"] = []string{"BOOT_FAIL"} }, types.JobStateBootFail},
+		{func(job map[string]any) { job["job_state"] = []string{"DEADLINE"} }, types.JobStateDeadline},
+		{func(job map[string]any) { job["job_state"] = []string{"COMPLETED", "SPECIAL_EXIT"} }, types.JobStateSpecialExit},
+		{func(job map[string]any) { job["job_state"] = []string{"STOPPED", "SUSPENDED"} }, types.JobStateStopped},
+		{func(job map[string]any) { job["job_state"] = []string{"RUNNING", "COMPLETING"} }, types.JobStateCompleting},
+		{func(job map[string]any) { job["job_state"] = []string{"COMPLETED"} }, types.JobStateCompleted},
+		// flags that aren't reported as the state are ignored
+		{func(job map[string]any) { job["job_state"] = []string{"PENDING", "LAUNCH_FAILED"} }, types.JobStatePending},
+		{func(job map[string]any) { delete(job, "dependency") }, types.JobStateRunning},
+		{func(job map[string]any) { job["job_state"] = []string{"HIBERNATING"} }, types.JobStateUnknown},
+		{func(job map[string]any) { delete(job, "job_state") }, types.JobStateUnknown},
+		{func(job map[string]any) { delete(job, "account") }, types.JobStateRunning},
+	}
+	body, err := fakeslurm.MutatedFixture("jobs", "jobs", func(template func() map[string]any) []map[string]any {
+		var jobs []map[string]any
+		for i, tc := range tests {
+			job := template()
+			job["job_id"] = i + 1
+			job["job_state"] = []string{"RUNNING"}
+			// the 24.05 example job has a placeholder instead of a task range
+			job["array_task_string"] = ""
+			tc.mutate(job)
+			jobs = append(jobs, job)
+		}
+		// jobs without an id are skipped, whatever else is wrong
+		missing := template()
+		delete(missing, "job_id")
+		invalid := template()
+		invalid["job_id"] = "one"
+		return append(jobs, missing, invalid)
+	})
+	if err != nil {
+		t.Fatalf("failed to mutate jobs fixture: %v", err)
+	}
+
+	d, err := ProcessJobsResponse(body)
+	if err != nil {
+		t.Fatalf("expected the malformed jobs not to fail the response, got %v", err)
+	}
+	if len(d.Jobs) != len(tests) {
+		t.Fatalf("expected %d jobs, got %d", len(tests), len(d.Jobs))
+	}
+	for i, tc := range tests {
+		if d.Jobs[i].JobState != tc.state {
+			t.Errorf("expected job %d to be %s, got %s", i+1, tc.state, d.Jobs[i].JobState)
+		}
+	}
+	want := DecodeErrors{"job_state": 2, "account": 1, "job_id": 2}
+	if !reflect.DeepEqual(d.DecodeErrors, want) {
+		t.Fatalf("expected decode errors %v, got %v", want, d.DecodeErrors)
+	}
+
+	// the streamed jobs are decoded the same way
+	streamed, err := DecodeJobsResponse(bytes.NewReader(body))
+	if err != nil || !reflect.DeepEqual(streamed, d) {
+		t.Fatalf("expected the streamed jobs to match, got %v", err)
+	}
+}
+
+func TestDecodeMalformedNodes(t *testing.T) {
+	body, err := fakeslurm.MutatedFixture("nodes", "nodes", func(template func() map[string]any) []map[string]any {
+		unnamed := template()
+		delete(unnamed, "name")
+		unknownState := template()
+		unknownState["name"] = "unknown-state"
+		unknownState["state"] = []string{"IDLE", "HIBERNATING"}
+		noTres := template()
+		noTres["name"] = "no-tres"
+		delete(noTres, "tres")
+		delete(noTres, "tres_used")
+		badGPUs := template()
+		badGPUs["name"] = "bad-gpus"
+		badGPUs["tres"] = "cpu=4,gres/gpu=four"
+		return []map[string]any{unnamed, unknownState, noTres, badGPUs}
+	})
+	if err != nil {
+		t.Fatalf("failed to mutate nodes fixture: %v", err)
+	}
+	d, err := ProcessNodesResponse(body)
+	if err != nil {
+		t.Fatalf("expected the malformed nodes not to fail the response, got %v", err)
+	}
+	if len(d.Nodes) != 3 {
+		t.Fatalf("expected the unnamed node to be skipped, got %d nodes", len(d.Nodes))
+	}
+	if d.Nodes[0].BaseState != types.NodeStateIdle || d.Nodes[0].State != "idle" {
+		t.Errorf("expected the known states to be kept, got %s", d.Nodes[0].State)
+	}
+	if d.Nodes[1].GPUTotal != 0 || d.Nodes[2].GPUTotal != 0 {
+		t.Errorf("expected no gpus on the nodes without a valid tres")
+	}
+	want := DecodeErrors{"name": 1, "state": 1, "tres": 1}
+	if !reflect.DeepEqual(d.DecodeErrors, want) {
+		t.Fatalf("expected decode errors %v, got %v", want, d.DecodeErrors)
+	}
+}
+
+func TestDecodeMalformedPartitions(t *testing.T) {
+	body, err := fakeslurm.MutatedFixture("partitions", "partitions", func(template func() map[string]any) []map[string]any {
+		unnamed := template()
+		delete(unnamed, "name")
+		noCPUs := template()
+		noCPUs["name"] = "no-cpus"
+		delete(noCPUs, "cpus")
+		delete(noCPUs, "nodes")
+		return []map[string]any{unnamed, noCPUs, template()}
+	})
+	if err != nil {
+		t.Fatalf("failed to mutate partitions fixture: %v", err)
+	}
+	d, err := ProcessPartitionsResponse(body)
+	if err != nil {
+		t.Fatalf("expected the malformed partitions not to fail the response, got %v", err)
+	}
+	if len(d.Partitions) != 2 || d.Partitions[0].Cpus != 0 || d.Partitions[1].Cpus == 0 {
+		t.Fatalf("expected the partitions after the malformed ones, got %+v", d.Partitions)
+	}
+	want := DecodeErrors{"name": 1, "cpus": 1, "nodes": 1}
+	if !reflect.DeepEqual(d.DecodeErrors, want) {
+		t.Fatalf("expected decode errors %v, got %v", want, d.DecodeErrors)
+	}
+}
+
+// peakHeap samples the live heap until stopped and returns the largest value
+// seen, in bytes
+func peakHeap() (stop func() uint64) {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	done := make(chan struct{})
+	result := make(chan uint64)
+	go func() {
+		var peak uint64
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			metrics.Read(sample)
+			peak = max(peak, sample[0].Value.Uint64())
+			select {
+			case <-done:
+				result <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		return <-result
+	}
+}
+
+// BenchmarkDecodeJobs decodes a generated jobs response far larger than the
+// jobs kept from it, reading it whole before unmarshalling it and streaming
+// it. peak-heap-MB is the largest live heap seen while decoding.
+func BenchmarkDecodeJobs(b *testing.B) {
+	const n = 50000
+	for _, bc := range []struct {
+		name   string
+		decode func(io.Reader) (*JobsData, error)
+	}{
+		{"readall", unmarshalJobsResponse},
+		{"stream", DecodeJobsResponse},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				r, err := fakeslurm.SyntheticJobsReader(n)
+				if err != nil {
+					b.Fatalf("failed to generate jobs: %v", err)
+				}
+				runtime.GC()
+				stop := peakHeap()
+				b.StartTimer()
+				d, err := bc.decode(r)
+				if err != nil || len(d.Jobs) != n {
+					b.Fatalf("failed to decode jobs: %v", err)
+				}
+				peak = max(peak, stop())
+			}
+			b.ReportMetric(float64(peak)/1e6, "peak-heap-MB")
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The health endpoints only report the status recorded by the polls made for
+// scrapes, they never query slurmrestd, so probes can be run as often as
+// needed without putting any load on slurmctld.
+
+// HealthyHandler reports the exporter process is up
+func HealthyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Healthy.")
+	}
+}
+
+// ReadyHandler reports whether the last poll of every slurmrestd endpoint
+// succeeded
+func ReadyHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := statusFromContext(ctx)
+		if status == nil || !status.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "Not ready, check /debug/status for the last slurmrestd errors.")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Ready.")
+	}
+}
+
+// ReloadHandler calls reload for POST requests, so the configuration can be
+// reloaded without sending a signal. A nil reload means the lifecycle api is
+// disabled and every request is forbidden.
+func ReloadHandler(reload func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reload == nil {
+			http.Error(w, "Lifecycle API is not enabled.", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := reload()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "Reloaded.")
+	}
+}
+
+// StatusHandler serves the exporter status as JSON, along with the effective
+// configuration, which must already have any secrets redacted
+func StatusHandler(ctx context.Context, config map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var report StatusReport
+		if status := statusFromContext(ctx); status != nil {
+			report = status.Report()
+		}
+		report.Config = config
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(report)
+		if err != nil {
+			slog.Error("failed to encode status", "error", err)
+		}
+	}
+}
+
+var landingPageTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Slurm Exporter</title></head>
+<body>
+<h1>Slurm Exporter</h1>
+<p>Version {{.Version}}, slurmrestd api {{.Status.ApiVersion}}{{if .Status.SlurmRelease}}, slurm {{.Status.SlurmRelease}}{{end}}{{if .Status.SlurmCluster}} on {{.Status.SlurmCluster}}{{end}}</p>
+<ul>
+<li><a href="metrics">Metrics</a></li>
+<li><a href="-/healthy">Health</a></li>
+<li><a href="-/ready">Readiness</a></li>
+<li><a href="debug/status">Status</a></li>
+<li><a href="api/v1/snapshot">Snapshot</a></li>
+</ul>
+<h2>Endpoints</h2>
+<table>
+<tr><th>Endpoint</th><th>Path</th><th>Status</th><th>Last success</th><th>Last error</th></tr>
+{{- range .Status.Endpoints}}
+<tr><td>{{.Name}}</td><td>{{.Path}}</td><td>{{if .Ok}}ok{{else}}failing{{end}}</td><td>{{if .LastSuccess}}{{.LastSuccess.Format "2006-01-02 15:04:05 MST"}}{{else}}never{{end}}</td><td>{{.LastError}}</td></tr>
+{{- end}}
+</table>
+<h2>Collectors</h2>
+<ul>
+{{- range .Collectors}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// LandingPageHandler serves an html page linking to the other endpoints, with
+// the collectors and the status of each slurmrestd endpoint
+func LandingPageHandler(ctx context.Context, version string, collectors []prometheus.Collector) http.HandlerFunc {
+	var names []string
+	for _, c := range collectors {
+		// *slurm.UsersCollector -> UsersCollector
+		name := fmt.Sprintf("%T", c)
+		names = append(names, name[strings.LastIndex(name, ".")+1:])
+	}
+	sort.Strings(names)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the landing page is registered on /, which matches every path
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var report StatusReport
+		if status := statusFromContext(ctx); status != nil {
+			report = status.Report()
+		}
+		data := struct {
+			Version    string
+			Status     StatusReport
+			Collectors []string
+		}{version, report, names}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := landingPageTemplate.Execute(w, data)
+		if err != nil {
+			slog.Error("failed to render landing page", "error", err)
+		}
+	}
+}
+
+// bearerAuth requires requests to carry the token from a file as a bearer
+// token. The file is read again when it changes, so the token can be rotated
+// without restarting the exporter.
+type bearerAuth struct {
+	path string
+	next http.Handler
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   []byte
+}
+
+// BearerAuthHandler wraps next so requests must carry the token in tokenFile
+// in an "Authorization: Bearer" header
+func BearerAuthHandler(next http.Handler, tokenFile string) http.Handler {
+	return &bearerAuth{path: tokenFile, next: next}
+}
+
+// load returns the current token, reading the file if it changed
+func (b *bearerAuth) load() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return nil, err
+	}
+	if b.token != nil && fi.ModTime().Equal(b.modTime) {
+		return b.token, nil
+	}
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return nil, err
+	}
+	token := bytes.TrimSpace(data)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("bearer token file %s is empty", b.path)
+	}
+	b.token = token
+	b.modTime = fi.ModTime()
+	return b.token, nil
+}
+
+func (b *bearerAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, err := b.load()
+	if err != nil {
+		slog.Error("failed to read bearer token file", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(given), token) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	b.next.ServeHTTP(w, r)
+}
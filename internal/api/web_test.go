@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestReadyHandler(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiCacheKey, NewCache())
+	ctx = context.WithValue(ctx, types.ApiStatusKey, NewStatus())
+	ready := ReadyHandler(ctx)
+
+	if w := get(t, ready, "/-/ready"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready before the first poll, got %d", w.Code)
+	}
+	if err := CheckConnection(ctx); err != nil {
+		t.Fatalf("failed to check connection: %v", err)
+	}
+	if w := get(t, ready, "/-/ready"); w.Code != http.StatusOK {
+		t.Fatalf("expected ready after a successful poll, got %d", w.Code)
+	}
+
+	// an invalid token fails every endpoint
+	ctx = context.WithValue(ctx, types.ApiTokenKey, "expired")
+	PopulateCache(ctx)
+	if w := get(t, ReadyHandler(ctx), "/-/ready"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready after an unauthorized poll, got %d", w.Code)
+	}
+
+	// probes never query slurmrestd
+	requests := s.Requests("jobs")
+	get(t, ready, "/-/ready")
+	get(t, HealthyHandler(), "/-/healthy")
+	get(t, StatusHandler(ctx, nil), "/debug/status")
+	get(t, LandingPageHandler(ctx, "test", nil), "/")
+	if s.Requests("jobs") != requests {
+		t.Fatalf("expected the health endpoints not to query slurmrestd")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	s.SetFault("diag", fakeslurm.Fault{StatusCode: 500})
+	ctx := newTestContext(s.URL, "slurm", "secret")
+	ctx = context.WithValue(ctx, types.ApiStatusKey, NewStatus())
+	CheckConnection(ctx)
+
+	w := get(t, StatusHandler(ctx, map[string]string{"api_token": "<redacted>"}), "/debug/status")
+	var report StatusReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if report.Ready || report.Config["api_token"] != "<redacted>" || len(report.Endpoints) != len(endpoints) {
+		t.Fatalf("unexpected status report: %+v", report)
+	}
+	for _, e := range report.Endpoints {
+		if e.Name == "diag" && (e.Ok || e.LastSuccess != nil || !strings.Contains(e.LastError, "500")) {
+			t.Fatalf("expected diag to be failing, got %+v", e)
+		}
+		if e.Name == "jobs" && (!e.Ok || e.LastSuccess == nil || e.LastError != "") {
+			t.Fatalf("expected jobs to be ok, got %+v", e)
+		}
+	}
+}
+
+func TestLandingPageHandler(t *testing.T) {
+	ctx := context.WithValue(context.Background(), types.ApiStatusKey, NewStatus())
+	h := LandingPageHandler(ctx, "1.2.3", nil)
+	w := get(t, h, "/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "1.2.3") || !strings.Contains(w.Body.String(), "never") {
+		t.Fatalf("unexpected landing page: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(t, h, "/nothing"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown paths to 404, got %d", w.Code)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	reloads := 0
+	reload := ReloadHandler(func() error {
+		reloads++
+		if reloads > 1 {
+			return fmt.Errorf("invalid config")
+		}
+		return nil
+	})
+
+	if w := get(t, reload, "/-/reload"); w.Code != http.StatusMethodNotAllowed || reloads != 0 {
+		t.Fatalf("expected GET to be rejected without reloading, got %d", w.Code)
+	}
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reload.ServeHTTP(w, httptest.NewRequest("POST", "/-/reload", nil))
+		return w
+	}
+	if w := post(); w.Code != http.StatusOK {
+		t.Fatalf("expected a successful reload, got %d", w.Code)
+	}
+	if w := post(); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "invalid config") {
+		t.Fatalf("expected the reload error to be returned, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	ReloadHandler(nil).ServeHTTP(w, httptest.NewRequest("POST", "/-/reload", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 with the lifecycle api disabled, got %d", w.Code)
+	}
+}
+
+func TestBearerAuthHandler(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("first\n"), 0600)
+	h := BearerAuthHandler(HealthyHandler(), tokenFile)
+
+	request := func(token string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/-/healthy", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := request(""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", code)
+	}
+	if code := request("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with the wrong token, got %d", code)
+	}
+	if code := request("first"); code != http.StatusOK {
+		t.Fatalf("expected 200 with the token, got %d", code)
+	}
+
+	// rotating the token takes effect without a restart
+	os.WriteFile(tokenFile, []byte("second"), 0600)
+	os.Chtimes(tokenFile, time.Now(), time.Now().Add(time.Second))
+	if code := request("first"); code != http.StatusUnauthorized {
+		t.Fatalf("expected the old token to be rejected, got %d", code)
+	}
+	if code := request("second"); code != http.StatusOK {
+		t.Fatalf("expected 200 with the new token, got %d", code)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build 2311
+
+package fakeslurm
+
+// fixtures maps each endpoint name to the file in testdata it serves
+var fixtures = map[string]string{
+	"diag":       "V0040OpenapiDiagResp.json",
+	"jobs":       "V0040OpenapiJobInfoResp.json",
+	"nodes":      "V0040OpenapiNodesResp.json",
+	"partitions": "V0040OpenapiPartitionResp.json",
+	"shares":     "V0040OpenapiSharesResp.json",
+}
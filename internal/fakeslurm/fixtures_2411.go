@@ -0,0 +1,12 @@
+//go:build 2411
+
+package fakeslurm
+
+// fixtures maps each endpoint name to the file in testdata it serves
+var fixtures = map[string]string{
+	"diag":       "SlurmV0041GetDiag200Response.json",
+	"jobs":       "V0041OpenapiJobInfoResp.json",
+	"nodes":      "V0041OpenapiNodesResp.json",
+	"partitions": "V0041OpenapiPartitionResp.json",
+	"shares":     "V0041OpenapiSharesResp.json",
+}
@@ -0,0 +1,49 @@
+package fakeslurm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/util"
+)
+
+// Fixture returns the fixture response served for an endpoint
+func Fixture(endpoint string) ([]byte, error) {
+	filename, ok := fixtures[endpoint]
+	if !ok {
+		return nil, fmt.Errorf("no fixture for endpoint %s", endpoint)
+	}
+	return util.ReadTestDataBytes(filename), nil
+}
+
+// MutatedFixture returns the fixture response of an endpoint with the records
+// under key, such as "jobs" or "nodes", replaced by the ones returned by
+// mutate. mutate is given a copy of the first fixture record, which it can
+// change freely, and returns the records to serve.
+func MutatedFixture(endpoint string, key string, mutate func(template func() map[string]any) []map[string]any) ([]byte, error) {
+	b, err := Fixture(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	b = util.CleanseInfinity(b)
+	var resp map[string]any
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse %s fixture: %v", endpoint, err)
+	}
+	records, ok := resp[key].([]any)
+	if !ok || len(records) == 0 {
+		return nil, fmt.Errorf("no %s in the %s fixture", key, endpoint)
+	}
+	first, err := json.Marshal(records[0])
+	if err != nil {
+		return nil, err
+	}
+	template := func() map[string]any {
+		var record map[string]any
+		// the fixture record was just marshalled, so it can't fail
+		json.Unmarshal(first, &record)
+		return record
+	}
+	resp[key] = mutate(template)
+	return json.Marshal(resp)
+}
@@ -0,0 +1,184 @@
+// Package fakeslurm provides a fake slurmrestd that serves the responses in
+// the testdata directory, so the exporter can be tested end to end without a
+// slurm cluster.
+package fakeslurm
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/util"
+)
+
+// Fault describes a failure to inject into the responses for an endpoint
+type Fault struct {
+	// StatusCode is returned instead of 200 when set
+	StatusCode int
+	// Body replaces the fixture body when set
+	Body []byte
+	// Delay is waited before responding
+	Delay time.Duration
+	// Truncate cuts the body in half, leaving invalid json
+	Truncate bool
+}
+
+// Server is a fake slurmrestd. Requests are matched to endpoints by the last
+// element of the path, so every api version is served the same fixtures.
+type Server struct {
+	// URL is the value to use for SLURM_EXPORTER_API_URL
+	URL string
+
+	httpServer *httptest.Server
+	user       string
+	token      string
+	socketDir  string
+
+	mu       sync.Mutex
+	bodies   map[string][]byte
+	faults   map[string]Fault
+	requests map[string]int
+	queries  map[string]url.Values
+}
+
+func newServer(user string, token string) *Server {
+	s := &Server{
+		user:     user,
+		token:    token,
+		bodies:   make(map[string][]byte),
+		faults:   make(map[string]Fault),
+		requests: make(map[string]int),
+		queries:  make(map[string]url.Values),
+	}
+	for name, filename := range fixtures {
+		s.bodies[name] = util.ReadTestDataBytes(filename)
+	}
+	return s
+}
+
+// NewServer starts a fake slurmrestd listening on localhost. Requests must
+// carry the X-SLURM-USER-NAME and X-SLURM-USER-TOKEN headers matching user
+// and token or they are rejected with 401, like slurmrestd does.
+func NewServer(user string, token string) *Server {
+	s := newServer(user, token)
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// NewUnixServer starts a fake slurmrestd listening on a unix socket. Like
+// slurmrestd, authentication headers are not checked on the socket.
+func NewUnixServer() (*Server, error) {
+	s := newServer("", "")
+	// unix socket paths are limited to ~100 characters, so avoid t.TempDir()
+	dir, err := os.MkdirTemp("", "fakeslurm")
+	if err != nil {
+		return nil, err
+	}
+	socketPath := filepath.Join(dir, "slurmrestd.socket")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.socketDir = dir
+	s.httpServer = httptest.NewUnstartedServer(s)
+	s.httpServer.Listener = l
+	s.httpServer.Start()
+	s.URL = "unix://" + socketPath
+	return s, nil
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.httpServer.Close()
+	if s.socketDir != "" {
+		os.RemoveAll(s.socketDir)
+	}
+}
+
+// SetBody replaces the response body for an endpoint, e.g. "jobs"
+func (s *Server) SetBody(endpoint string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies[endpoint] = body
+}
+
+// SetFault injects a failure into the responses for an endpoint
+func (s *Server) SetFault(endpoint string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[endpoint] = f
+}
+
+// ClearFaults removes all injected failures
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]Fault)
+}
+
+// Requests returns the number of requests received for an endpoint
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+// LastQuery returns the query parameters of the last request for an endpoint
+func (s *Server) LastQuery(endpoint string) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[endpoint]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := path.Base(strings.TrimSuffix(r.URL.Path, "/"))
+
+	s.mu.Lock()
+	s.requests[endpoint]++
+	s.queries[endpoint] = r.URL.Query()
+	body, found := s.bodies[endpoint]
+	fault := s.faults[endpoint]
+	s.mu.Unlock()
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if s.user != "" || s.token != "" {
+		if r.Header.Get("X-SLURM-USER-NAME") != s.user || r.Header.Get("X-SLURM-USER-TOKEN") != s.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	status := http.StatusOK
+	if fault.StatusCode != 0 {
+		status = fault.StatusCode
+	}
+	if fault.Body != nil {
+		body = fault.Body
+	}
+	if fault.Truncate {
+		body = body[:len(body)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,102 @@
+package fakeslurm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/util"
+)
+
+// syntheticStates are cycled through by the synthetic jobs, weighted like a
+// busy cluster with a deep queue
+var syntheticStates = []string{"RUNNING", "PENDING", "PENDING", "RUNNING", "COMPLETED", "PENDING", "RUNNING", "FAILED"}
+
+// SyntheticJobs returns a jobs response with n jobs for benchmarks of large
+// clusters. The jobs are copies of the fixture jobs with their own ids, and
+// are spread over 500 users, 50 accounts and 10 partitions.
+func SyntheticJobs(n int) ([]byte, error) {
+	r, err := SyntheticJobsReader(n)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// SyntheticJobsReader returns the same response as SyntheticJobs, generated
+// a job at a time as it is read, so responses far larger than the memory
+// used to decode them can be generated
+func SyntheticJobsReader(n int) (io.Reader, error) {
+	var resp map[string]any
+	err := json.Unmarshal(util.CleanseInfinity(util.ReadTestDataBytes(fixtures["jobs"])), &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jobs fixture: %v", err)
+	}
+	templates, ok := resp["jobs"].([]any)
+	if !ok || len(templates) == 0 {
+		return nil, fmt.Errorf("no jobs in the jobs fixture")
+	}
+	// the rest of the response, like meta, comes before the jobs
+	delete(resp, "jobs")
+	header, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	header = header[:len(header)-1]
+	if len(resp) > 0 {
+		header = append(header, ',')
+	}
+	header = append(header, `"jobs":[`...)
+
+	r := &syntheticJobsReader{n: n, templates: templates}
+	r.buf.Write(header)
+	return r, nil
+}
+
+type syntheticJobsReader struct {
+	n         int
+	i         int
+	templates []any
+	buf       bytes.Buffer
+}
+
+func (r *syntheticJobsReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.i > r.n {
+			return 0, io.EOF
+		}
+		if r.i == r.n {
+			r.buf.WriteString("]}")
+			r.i++
+			break
+		}
+		if r.i > 0 {
+			r.buf.WriteByte(',')
+		}
+		b, err := json.Marshal(r.job(r.i))
+		if err != nil {
+			return 0, err
+		}
+		r.buf.Write(b)
+		r.i++
+	}
+	return r.buf.Read(p)
+}
+
+// job returns the i-th synthetic job
+func (r *syntheticJobsReader) job(i int) map[string]any {
+	template := r.templates[i%len(r.templates)].(map[string]any)
+	// only top level fields are changed, so the nested values can be
+	// shared between the copies
+	job := make(map[string]any, len(template))
+	for k, v := range template {
+		job[k] = v
+	}
+	job["job_id"] = i + 1
+	job["user_name"] = fmt.Sprintf("user%d", i%500)
+	job["account"] = fmt.Sprintf("account%d", i%50)
+	job["partition"] = fmt.Sprintf("partition%d", i%10)
+	job["job_state"] = []any{syntheticStates[i%len(syntheticStates)]}
+	return job
+}
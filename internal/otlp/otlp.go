@@ -0,0 +1,173 @@
+// Package otlp pushes the exporter's metrics to an OpenTelemetry collector,
+// for sites that collect metrics with OTLP instead of scraping.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	promotel "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+// Config configures the OTLP push
+type Config struct {
+	// Endpoint is the url of the collector, an http:// scheme sends the
+	// metrics without TLS. The default path of /v1/metrics is used for
+	// http/protobuf when the url has no path.
+	Endpoint string
+	// Protocol is either http/protobuf or grpc
+	Protocol string
+	// Interval is the time between pushes
+	Interval time.Duration
+	// Headers are sent with every push, for authentication
+	Headers map[string]string
+}
+
+// ParseHeaders parses headers given as a comma separated list of key=value
+// pairs, like OTEL_EXPORTER_OTLP_HEADERS
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, h := range strings.Split(s, ",") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		k, v, found := strings.Cut(h, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", h)
+		}
+		// values may be url encoded, like in OTEL_EXPORTER_OTLP_HEADERS
+		v, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header %q: %v", h, err)
+		}
+		headers[strings.TrimSpace(k)] = v
+	}
+	return headers, nil
+}
+
+// Pusher converts the output of the collectors to OTLP metrics and sends it
+// to a collector on an interval
+type Pusher struct {
+	config   Config
+	exporter sdkmetric.Exporter
+	producer sdkmetric.Producer
+	version  string
+}
+
+// NewPusher returns a Pusher sending the metrics gathered from gatherer,
+// which polls slurmrestd for every push like it does for every scrape.
+// version is reported as the service.version resource attribute.
+func NewPusher(ctx context.Context, config Config, gatherer prometheus.Gatherer, version string) (*Pusher, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %s, must be an http:// or https:// url", config.Endpoint)
+	}
+	var exporter sdkmetric.Exporter
+	switch config.Protocol {
+	case ProtocolHTTP, "":
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(config.Endpoint)}
+		if u.Path == "" || u.Path == "/" {
+			opts = append(opts, otlpmetrichttp.WithURLPath("/v1/metrics"))
+		}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(config.Headers))
+		}
+		exporter, err = otlpmetrichttp.New(ctx, opts...)
+	case ProtocolGRPC:
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpointURL(config.Endpoint)}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(config.Headers))
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid otlp protocol %s, must be http/protobuf or grpc", config.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %v", err)
+	}
+	return &Pusher{
+		config:   config,
+		exporter: exporter,
+		producer: promotel.NewMetricProducer(promotel.WithGatherer(gatherer)),
+		version:  version,
+	}, nil
+}
+
+// Run pushes the metrics every interval until ctx is cancelled. ctx must
+// carry the exporter values, as the resource is described from the status.
+func (p *Pusher) Run(ctx context.Context) {
+	slog.Info("pushing metrics with otlp", "endpoint", p.config.Endpoint, "protocol", p.config.Protocol, "interval", p.config.Interval)
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		err := p.Push(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("failed to push metrics with otlp", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			// the exporter is shut down with a new context, as ctx is
+			// already cancelled
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := p.exporter.Shutdown(shutdownCtx)
+			if err != nil {
+				slog.Error("failed to shut down otlp exporter", "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Push runs the collectors and sends their output once. The gatherer polls
+// slurmrestd, see api.Gatherer.
+func (p *Pusher) Push(ctx context.Context) error {
+	scopeMetrics, err := p.producer.Produce(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+	rm := &metricdata.ResourceMetrics{
+		Resource:     p.resource(ctx),
+		ScopeMetrics: scopeMetrics,
+	}
+	return p.exporter.Export(ctx, rm)
+}
+
+// resource describes the exporter and the cluster. The cluster name and
+// slurm version come from the last diag poll, so they are only known once
+// slurmrestd has been reached.
+func (p *Pusher) resource(ctx context.Context) *resource.Resource {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", "prometheus-slurm-exporter"),
+		attribute.String("service.version", p.version),
+	}
+	if status, ok := ctx.Value(types.ApiStatusKey).(*api.Status); ok {
+		report := status.Report()
+		if report.SlurmCluster != "" {
+			attrs = append(attrs, attribute.String("slurm.cluster.name", report.SlurmCluster))
+		}
+		if report.SlurmRelease != "" {
+			attrs = append(attrs, attribute.String("slurm.version", report.SlurmRelease))
+		}
+	}
+	return resource.NewSchemaless(attrs...)
+}
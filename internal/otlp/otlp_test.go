@@ -0,0 +1,245 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/slurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// receiver is an OTLP receiver stub keeping the requests it was sent
+type receiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	requests []*collectorpb.ExportMetricsServiceRequest
+	headers  []http.Header
+	// received, if set, is signalled for every request without blocking
+	received chan struct{}
+}
+
+func (r *receiver) record(m *collectorpb.ExportMetricsServiceRequest, h http.Header) {
+	r.mu.Lock()
+	r.requests = append(r.requests, m)
+	r.headers = append(r.headers, h)
+	r.mu.Unlock()
+	if r.received != nil {
+		select {
+		case r.received <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/metrics" {
+		http.NotFound(w, req)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var m collectorpb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(body, &m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.record(&m, req.Header.Clone())
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	b, _ := proto.Marshal(&collectorpb.ExportMetricsServiceResponse{})
+	w.Write(b)
+}
+
+func (r *receiver) Export(ctx context.Context, m *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	r.record(m, nil)
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) last(t *testing.T) *collectorpb.ExportMetricsServiceRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) == 0 {
+		t.Fatalf("expected the receiver to get metrics")
+	}
+	return r.requests[len(r.requests)-1]
+}
+
+// exporterContext wires the exporter up against a fake slurmrestd, like main
+// does
+func exporterContext(t *testing.T) (context.Context, prometheus.Gatherer) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	t.Cleanup(s.Close)
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, types.ApiUserKey, "slurm")
+	ctx = context.WithValue(ctx, types.ApiTokenKey, "secret")
+	ctx = context.WithValue(ctx, types.ApiURLKey, s.URL)
+	ctx = context.WithValue(ctx, types.ApiCacheKey, api.NewCache())
+	ctx = context.WithValue(ctx, types.MetricSchemaKey, types.MetricSchemaV2)
+	ctx = context.WithValue(ctx, types.ApiStatusKey, api.NewStatus())
+	ctx = api.RegisterEndpoints(ctx)
+	return ctx, api.NewGatherer(ctx, slurm.NewCollectors(ctx))
+}
+
+func checkRequest(t *testing.T, m *collectorpb.ExportMetricsServiceRequest) {
+	if len(m.ResourceMetrics) != 1 {
+		t.Fatalf("expected one resource, got %d", len(m.ResourceMetrics))
+	}
+	rm := m.ResourceMetrics[0]
+	attrs := make(map[string]string)
+	for _, kv := range rm.Resource.Attributes {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	if attrs["service.name"] != "prometheus-slurm-exporter" || attrs["service.version"] != "test" {
+		t.Errorf("unexpected service attributes %v", attrs)
+	}
+	if attrs["slurm.cluster.name"] == "" || attrs["slurm.version"] == "" {
+		t.Errorf("expected the cluster name and slurm version from diag, got %v", attrs)
+	}
+	names := make(map[string]bool)
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			names[metric.Name] = true
+		}
+	}
+	for _, name := range []string{"slurm_cpus_total", "slurm_nodes", "slurm_scheduler_threads"} {
+		if !names[name] {
+			t.Errorf("expected %s to be pushed, got %v", name, names)
+		}
+	}
+	// counters are pushed as monotonic sums
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			if metric.Name != "slurm_scheduler_jobs_submitted_total" {
+				continue
+			}
+			if metric.GetSum() == nil || !metric.GetSum().IsMonotonic {
+				t.Errorf("expected %s to be a monotonic sum", metric.Name)
+			}
+			return
+		}
+	}
+	t.Errorf("expected slurm_scheduler_jobs_submitted_total to be pushed")
+}
+
+func TestPushHTTP(t *testing.T) {
+	recv := &receiver{}
+	collector := httptest.NewServer(recv)
+	defer collector.Close()
+	ctx, r := exporterContext(t)
+
+	p, err := NewPusher(ctx, Config{
+		Endpoint: collector.URL,
+		Protocol: ProtocolHTTP,
+		Interval: time.Minute,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+	}, r, "test")
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+	if err := p.Push(ctx); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	checkRequest(t, recv.last(t))
+	if got := recv.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("expected the configured headers to be sent, got %q", got)
+	}
+}
+
+func TestPushGRPC(t *testing.T) {
+	recv := &receiver{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(server, recv)
+	go server.Serve(l)
+	defer server.Stop()
+	ctx, r := exporterContext(t)
+
+	p, err := NewPusher(ctx, Config{
+		Endpoint: "http://" + l.Addr().String(),
+		Protocol: ProtocolGRPC,
+		Interval: time.Minute,
+	}, r, "test")
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+	if err := p.Push(ctx); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	checkRequest(t, recv.last(t))
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	recv := &receiver{received: make(chan struct{}, 16)}
+	collector := httptest.NewServer(recv)
+	defer collector.Close()
+	ctx, r := exporterContext(t)
+
+	p, err := NewPusher(ctx, Config{Endpoint: collector.URL, Interval: 10 * time.Millisecond}, r, "test")
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	// the first push is immediate and the second after an interval
+	for i := 0; i < 2; i++ {
+		select {
+		case <-recv.received:
+		case <-time.After(5 * time.Second):
+			cancel()
+			t.Fatalf("expected a push every interval, got %d", i)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Run to return once the context is cancelled")
+	}
+}
+
+func TestNewPusherErrors(t *testing.T) {
+	_, r := exporterContext(t)
+	for _, c := range []Config{
+		{Endpoint: "collector:4318", Protocol: ProtocolHTTP},
+		{Endpoint: "http://collector:4318", Protocol: "udp"},
+	} {
+		if _, err := NewPusher(context.Background(), c, r, "test"); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders("Authorization=Basic%20abc, X-Scope-OrgID=slurm,")
+	if err != nil {
+		t.Fatalf("failed to parse headers: %v", err)
+	}
+	if len(h) != 2 || h["Authorization"] != "Basic abc" || h["X-Scope-OrgID"] != "slurm" {
+		t.Fatalf("unexpected headers %v", h)
+	}
+	if _, err := ParseHeaders("Authorization"); err == nil {
+		t.Fatalf("expected an error for a header without a value")
+	}
+}
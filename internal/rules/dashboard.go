@@ -0,0 +1,128 @@
+package rules
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// The dashboard only models the parts of the Grafana JSON model we set, the
+// rest is filled in with defaults when it's imported.
+
+type dashboard struct {
+	Title         string     `json:"title"`
+	UID           string     `json:"uid"`
+	Tags          []string   `json:"tags"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type panel struct {
+	ID          int          `json:"id"`
+	Title       string       `json:"title"`
+	Type        string       `json:"type"`
+	Datasource  datasource   `json:"datasource"`
+	GridPos     gridPos      `json:"gridPos"`
+	Targets     []target     `json:"targets"`
+	FieldConfig *fieldConfig `json:"fieldConfig,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string     `json:"expr"`
+	LegendFormat string     `json:"legendFormat"`
+	RefID        string     `json:"refId"`
+	Datasource   datasource `json:"datasource"`
+}
+
+type fieldConfig struct {
+	Defaults fieldDefaults `json:"defaults"`
+}
+
+type fieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+var promDatasource = datasource{Type: "prometheus", UID: "${datasource}"}
+
+// WriteDashboard writes a Grafana dashboard with a panel for every recording
+// rule and for the metric behind every alert, so the dashboard always shows
+// what the alerts are looking at.
+func WriteDashboard(w io.Writer, groups []Group) error {
+	d := dashboard{
+		Title:         "Slurm Exporter",
+		UID:           "slurm-exporter",
+		Tags:          []string{"slurm", "generated"},
+		SchemaVersion: 39,
+		Refresh:       "1m",
+		Time:          timeRange{From: "now-6h", To: "now"},
+		Templating: templating{List: []variable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+	}
+
+	var panels []panel
+	for _, g := range groups {
+		for _, r := range g.Rules {
+			switch {
+			case r.Record != "":
+				p := newPanel(r.Record, r.Record)
+				p.FieldConfig = &fieldConfig{Defaults: fieldDefaults{Unit: r.Unit}}
+				panels = append(panels, p)
+			case len(r.Metrics) > 0:
+				panels = append(panels, newPanel(r.Summary, r.Metrics[0]))
+			}
+		}
+	}
+
+	// two panels per row
+	for i := range panels {
+		panels[i].ID = i + 1
+		panels[i].GridPos = gridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8}
+	}
+	d.Panels = panels
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+func newPanel(title string, expr string) panel {
+	return panel{
+		Title:      title,
+		Type:       "timeseries",
+		Datasource: promDatasource,
+		Targets: []target{
+			{Expr: expr, LegendFormat: "__auto", RefID: "A", Datasource: promDatasource},
+		},
+	}
+}
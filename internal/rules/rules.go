@@ -0,0 +1,253 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/slurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The rules and dashboard are generated from the same metric descriptors the
+// collectors register, so a renamed or removed metric makes generation fail
+// instead of silently producing rules that never match anything.
+
+// Rule is either a recording rule or an alert. Metrics lists every exporter
+// metric used by the expression so it can be checked against the collectors.
+type Rule struct {
+	Record      string
+	Alert       string
+	Expr        string
+	For         string
+	Severity    string
+	Summary     string
+	Description string
+	Unit        string
+	Metrics     []string
+}
+
+// Group is a named list of rules, matching a Prometheus rule group
+type Group struct {
+	Name  string
+	Rules []Rule
+}
+
+// MetricNames returns the names of all metrics the collectors export for the
+// given schema. It fails if a collector describes a metric whose name it
+// didn't record, rather than leaving it out of the validation.
+func MetricNames(schema types.MetricSchema) (map[string]bool, error) {
+	descNames := make(slurm.DescNames)
+	ctx := context.WithValue(context.Background(), types.MetricSchemaKey, schema)
+	ctx = context.WithValue(ctx, types.DescNamesKey, descNames)
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		for _, c := range slurm.NewCollectors(ctx) {
+			c.Describe(ch)
+		}
+		close(ch)
+	}()
+	names := make(map[string]bool)
+	var unnamed []string
+	for d := range ch {
+		name, found := descNames[d]
+		if !found {
+			unnamed = append(unnamed, d.String())
+			continue
+		}
+		names[name] = true
+	}
+	if len(unnamed) > 0 {
+		return nil, fmt.Errorf("collectors describe metrics without a recorded name: %s", strings.Join(unnamed, ", "))
+	}
+	return names, nil
+}
+
+// Groups returns the recording rules and alerts for the given schema, after
+// checking every metric they reference is exported by the collectors.
+func Groups(schema types.MetricSchema, job string) ([]Group, error) {
+	var groups []Group
+	if schema.V2() {
+		groups = v2Groups(job)
+	} else {
+		groups = v1Groups(job)
+	}
+
+	names, err := MetricNames(schema)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, g := range groups {
+		for _, r := range g.Rules {
+			for _, m := range r.Metrics {
+				if !names[m] {
+					missing = append(missing, fmt.Sprintf("%s (%s%s)", m, r.Record, r.Alert))
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("rules reference metrics not exported with schema %s: %s", schema, strings.Join(missing, ", "))
+	}
+	return groups, nil
+}
+
+func v1Groups(job string) []Group {
+	return []Group{
+		{
+			Name: "slurm.rules",
+			Rules: []Rule{
+				{
+					Record:  "slurm:cpus_utilization:ratio",
+					Unit:    "percentunit",
+					Expr:    "slurm_cpus_alloc / clamp_min(slurm_cpus_total, 1)",
+					Metrics: []string{"slurm_cpus_alloc", "slurm_cpus_total"},
+				},
+				{
+					Record:  "slurm:partition_cpus_utilization:ratio",
+					Unit:    "percentunit",
+					Expr:    "slurm_partition_cpus_allocated / on(partition) clamp_min(slurm_partition_cpus_total, 1)",
+					Metrics: []string{"slurm_partition_cpus_allocated", "slurm_partition_cpus_total"},
+				},
+				{
+					Record:  "slurm:pending_cpus_pressure:ratio",
+					Unit:    "none",
+					Expr:    "sum(slurm_account_cpus_pending) / clamp_min(slurm_cpus_idle, 1)",
+					Metrics: []string{"slurm_account_cpus_pending", "slurm_cpus_idle"},
+				},
+				{
+					Record:  "slurm:nodes_unavailable:ratio",
+					Unit:    "percentunit",
+					Expr:    "(slurm_nodes_down + slurm_nodes_drain + slurm_nodes_fail) / clamp_min(slurm_nodes_alloc + slurm_nodes_mix + slurm_nodes_idle + slurm_nodes_down + slurm_nodes_drain + slurm_nodes_fail, 1)",
+					Metrics: []string{"slurm_nodes_down", "slurm_nodes_drain", "slurm_nodes_fail", "slurm_nodes_alloc", "slurm_nodes_mix", "slurm_nodes_idle"},
+				},
+			},
+		},
+		{
+			Name:  "slurm.alerts",
+			Rules: alerts(job, "slurm_scheduler_queue_size", "slurm_scheduler_backfill_last_cycle", "60 * 1e6"),
+		},
+	}
+}
+
+func v2Groups(job string) []Group {
+	return []Group{
+		{
+			Name: "slurm.rules",
+			Rules: []Rule{
+				{
+					Record:  "slurm:cpus_utilization:ratio",
+					Unit:    "percentunit",
+					Expr:    `sum(slurm_cpus{state="alloc"}) / clamp_min(sum(slurm_cpus_total), 1)`,
+					Metrics: []string{"slurm_cpus", "slurm_cpus_total"},
+				},
+				{
+					Record:  "slurm:partition_cpus_utilization:ratio",
+					Unit:    "percentunit",
+					Expr:    `sum by (partition) (slurm_partition_cpus{state="alloc"}) / on(partition) clamp_min(slurm_partition_cpus_total, 1)`,
+					Metrics: []string{"slurm_partition_cpus", "slurm_partition_cpus_total"},
+				},
+				{
+					Record:  "slurm:pending_cpus_pressure:ratio",
+					Unit:    "none",
+					Expr:    `sum(slurm_account_cpus{state="pending"}) / clamp_min(sum(slurm_cpus{state="idle"}), 1)`,
+					Metrics: []string{"slurm_account_cpus", "slurm_cpus"},
+				},
+				{
+					Record:  "slurm:nodes_unavailable:ratio",
+					Unit:    "percentunit",
+					Expr:    `sum(slurm_nodes{state=~"(down|drained|draining|fail|failing).*"}) / clamp_min(sum(slurm_nodes), 1)`,
+					Metrics: []string{"slurm_nodes"},
+				},
+			},
+		},
+		{
+			Name:  "slurm.alerts",
+			Rules: alerts(job, "slurm_scheduler_agent_queue_size", "slurm_scheduler_backfill_cycle_last_seconds", "60"),
+		},
+	}
+}
+
+// alerts only differ between schemas in the scheduler metric names, the
+// nodes alert is built on top of the recording rule above.
+func alerts(job string, agentQueue string, backfillLast string, backfillLimit string) []Rule {
+	return []Rule{
+		{
+			Alert:       "SlurmExporterDown",
+			Expr:        fmt.Sprintf(`up{job="%s"} == 0`, job),
+			For:         "5m",
+			Severity:    "critical",
+			Summary:     "Slurm exporter scrape failing",
+			Description: "Prometheus failed to scrape the slurm exporter {{ $labels.instance }} for 5 minutes.",
+		},
+		{
+			Alert:       "SlurmExporterNoData",
+			Expr:        "absent(slurm_scheduler_threads)",
+			For:         "10m",
+			Severity:    "warning",
+			Summary:     "Slurm exporter is not returning slurm data",
+			Description: "The exporter is up but did not return scheduler metrics for 10 minutes, check the connection to slurmrestd.",
+			Metrics:     []string{"slurm_scheduler_threads"},
+		},
+		{
+			Alert:       "SlurmctldAgentQueueGrowing",
+			Expr:        fmt.Sprintf("%s > 100 and deriv(%s[15m]) > 0", agentQueue, agentQueue),
+			For:         "15m",
+			Severity:    "warning",
+			Summary:     "slurmctld agent queue is growing",
+			Description: "The slurmctld agent queue has {{ $value }} outgoing RPCs and keeps growing, nodes may not be responding.",
+			Metrics:     []string{agentQueue},
+		},
+		{
+			Alert:       "SlurmNodesUnavailable",
+			Expr:        "slurm:nodes_unavailable:ratio > 0.1",
+			For:         "15m",
+			Severity:    "warning",
+			Summary:     "Many slurm nodes are down or drained",
+			Description: "{{ $value | humanizePercentage }} of the slurm nodes are down, drained or failed.",
+		},
+		{
+			Alert:       "SlurmBackfillCycleTooLong",
+			Expr:        fmt.Sprintf("%s > %s", backfillLast, backfillLimit),
+			For:         "30m",
+			Severity:    "warning",
+			Summary:     "slurm backfill cycle is too long",
+			Description: "The last backfill scheduler cycle took more than 60 seconds.",
+			Metrics:     []string{backfillLast},
+		},
+	}
+}
+
+var rulesTemplate = template.Must(template.New("rules").Parse(`# Generated by prometheus-slurm-exporter rules generate, do not edit.
+groups:
+{{- range .}}
+  - name: {{.Name}}
+    rules:
+{{- range .Rules}}
+{{- if .Record}}
+      - record: {{.Record}}
+        expr: {{printf "%q" .Expr}}
+{{- else}}
+      - alert: {{.Alert}}
+        expr: {{printf "%q" .Expr}}
+        for: {{.For}}
+        labels:
+          severity: {{.Severity}}
+        annotations:
+          summary: {{printf "%q" .Summary}}
+          description: {{printf "%q" .Description}}
+{{- end}}
+{{- end}}
+{{- end}}
+`))
+
+// WriteRules writes the groups as a Prometheus rules file
+func WriteRules(w io.Writer, groups []Group) error {
+	return rulesTemplate.Execute(w, groups)
+}
@@ -0,0 +1,49 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// This fails whenever a collector renames a metric the rules depend on
+func TestGroupsReferenceExportedMetrics(t *testing.T) {
+	for _, schema := range []types.MetricSchema{types.MetricSchemaV1, types.MetricSchemaV2} {
+		groups, err := Groups(schema, "slurm_exporter")
+		if err != nil {
+			t.Fatalf("failed to generate rules for schema %s: %v", schema, err)
+		}
+
+		var rb bytes.Buffer
+		if err := WriteRules(&rb, groups); err != nil {
+			t.Fatalf("failed to write rules for schema %s: %v", schema, err)
+		}
+		if !strings.Contains(rb.String(), "record: slurm:cpus_utilization:ratio") {
+			t.Fatalf("missing recording rule in schema %s rules:\n%s", schema, rb.String())
+		}
+
+		var db bytes.Buffer
+		if err := WriteDashboard(&db, groups); err != nil {
+			t.Fatalf("failed to write dashboard for schema %s: %v", schema, err)
+		}
+		var d map[string]any
+		if err := json.Unmarshal(db.Bytes(), &d); err != nil {
+			t.Fatalf("dashboard for schema %s is not valid json: %v", schema, err)
+		}
+	}
+}
+
+func TestMetricNames(t *testing.T) {
+	names, err := MetricNames(types.MetricSchemaBoth)
+	if err != nil {
+		t.Fatalf("failed to get metric names: %v", err)
+	}
+	for _, name := range []string{"slurm_cpus_alloc", "slurm_cpus", "slurm_node_cpus", "slurm_scheduler_threads", "slurm_exporter_decode_errors_total"} {
+		if !names[name] {
+			t.Errorf("expected %s in the metric names, got %v", name, names)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,11 +18,16 @@ AccountsCollector collects metrics for accounts
 // AccountsCollector collects metrics for accounts
 type AccountsCollector struct {
 	ctx          context.Context
+	schema       types.MetricSchema
+	jobs         *prometheus.Desc
+	cpus         *prometheus.Desc
 	pending      *prometheus.Desc
 	pending_cpus *prometheus.Desc
 	running      *prometheus.Desc
 	running_cpus *prometheus.Desc
 	suspended    *prometheus.Desc
+	filter       *types.SeriesFilter
+	dropped      *prometheus.Desc
 }
 
 // NewAccountsCollector creates a new AccountsCollector
@@ -31,55 +35,93 @@ func NewAccountsCollector(ctx context.Context) *AccountsCollector {
 	labels := []string{"account"}
 	return &AccountsCollector{
 		ctx:          ctx,
-		pending:      prometheus.NewDesc("slurm_account_jobs_pending", "Pending jobs for account", labels, nil),
-		pending_cpus: prometheus.NewDesc("slurm_account_cpus_pending", "Pending cpus for account", labels, nil),
-		running:      prometheus.NewDesc("slurm_account_jobs_running", "Running jobs for account", labels, nil),
-		running_cpus: prometheus.NewDesc("slurm_account_cpus_running", "Running cpus for account", labels, nil),
-		suspended:    prometheus.NewDesc("slurm_account_jobs_suspended", "Suspended jobs for account", labels, nil),
+		schema:       metricSchema(ctx),
+		jobs:         newDesc(ctx, "slurm_account_jobs", "Jobs for account by state", []string{"account", "state"}, nil),
+		cpus:         newDesc(ctx, "slurm_account_cpus", "CPUs for account by job state", []string{"account", "state"}, nil),
+		pending:      newDesc(ctx, "slurm_account_jobs_pending", "Pending jobs for account", labels, nil),
+		pending_cpus: newDesc(ctx, "slurm_account_cpus_pending", "Pending cpus for account", labels, nil),
+		running:      newDesc(ctx, "slurm_account_jobs_running", "Running jobs for account", labels, nil),
+		running_cpus: newDesc(ctx, "slurm_account_cpus_running", "Running cpus for account", labels, nil),
+		suspended:    newDesc(ctx, "slurm_account_jobs_suspended", "Suspended jobs for account", labels, nil),
+		filter:       seriesFilter(ctx, "accounts"),
+		dropped:      newDroppedDesc(ctx, "accounts"),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (ac *AccountsCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *ac
+	c.ctx = ctx
+	return &c
+}
+
 func (ac *AccountsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- ac.pending
-	ch <- ac.pending_cpus
-	ch <- ac.running
-	ch <- ac.running_cpus
-	ch <- ac.suspended
+	if ac.schema.Legacy() {
+		ch <- ac.pending
+		ch <- ac.pending_cpus
+		ch <- ac.running
+		ch <- ac.running_cpus
+		ch <- ac.suspended
+	}
+	if ac.schema.V2() {
+		ch <- ac.jobs
+		ch <- ac.cpus
+	}
+	if ac.filter != nil {
+		ch <- ac.dropped
+	}
 }
 
 func (ac *AccountsCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := ac.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	jobsRespBytes, found := apiCache.Get("jobs")
-	if !found {
-		slog.Error("failed to get jobs response for users metrics from cache")
-		return
-	}
-	jobsData, err := api.ProcessJobsResponse(jobsRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to extract jobs data for accounts metrics", "error", err)
+	snapshot := api.CurrentSnapshot(ac.ctx)
+	jobsData := snapshot.Jobs
+	if jobsData == nil {
+		slog.Error("failed to get jobs data for accounts metrics", "error", snapshot.Err("jobs"))
 		return
 	}
+	jobsData = relabelJobs(ac.ctx, jobsData)
 	am, err := ParseAccountsMetrics(*jobsData)
 	if err != nil {
 		slog.Error("failed to parse accounts metrics", "error", err)
 		return
 	}
+	if ac.filter != nil {
+		var dropped droppedValues[JobMetrics]
+		am, dropped = filterSeries(ac.filter, am, (*JobMetrics).weight, (*JobMetrics).add)
+		dropped.collect(ch, ac.dropped, ac.collectAccount)
+	}
 	for a := range am {
-		if am[a].pending > 0 {
-			ch <- prometheus.MustNewConstMetric(ac.pending, prometheus.GaugeValue, am[a].pending, a)
-		}
-		if am[a].pending_cpus > 0 {
-			ch <- prometheus.MustNewConstMetric(ac.pending_cpus, prometheus.GaugeValue, am[a].pending_cpus, a)
-		}
-		if am[a].running > 0 {
-			ch <- prometheus.MustNewConstMetric(ac.running, prometheus.GaugeValue, am[a].running, a)
-		}
-		if am[a].running_cpus > 0 {
-			ch <- prometheus.MustNewConstMetric(ac.running_cpus, prometheus.GaugeValue, am[a].running_cpus, a)
-		}
-		if am[a].suspended > 0 {
-			ch <- prometheus.MustNewConstMetric(ac.suspended, prometheus.GaugeValue, am[a].suspended, a)
-		}
+		ac.collectAccount(ch, a, am[a])
+	}
+}
+
+// collectAccount sends the series of an account
+func (ac *AccountsCollector) collectAccount(ch chan<- prometheus.Metric, a string, m *JobMetrics) {
+	if ac.schema.V2() {
+		ch <- prometheus.MustNewConstMetric(ac.jobs, prometheus.GaugeValue, m.pending, a, "pending")
+		ch <- prometheus.MustNewConstMetric(ac.jobs, prometheus.GaugeValue, m.running, a, "running")
+		ch <- prometheus.MustNewConstMetric(ac.jobs, prometheus.GaugeValue, m.suspended, a, "suspended")
+		ch <- prometheus.MustNewConstMetric(ac.cpus, prometheus.GaugeValue, m.pending_cpus, a, "pending")
+		ch <- prometheus.MustNewConstMetric(ac.cpus, prometheus.GaugeValue, m.running_cpus, a, "running")
+	}
+	if !ac.schema.Legacy() {
+		return
+	}
+	if m.pending > 0 {
+		ch <- prometheus.MustNewConstMetric(ac.pending, prometheus.GaugeValue, m.pending, a)
+	}
+	if m.pending_cpus > 0 {
+		ch <- prometheus.MustNewConstMetric(ac.pending_cpus, prometheus.GaugeValue, m.pending_cpus, a)
+	}
+	if m.running > 0 {
+		ch <- prometheus.MustNewConstMetric(ac.running, prometheus.GaugeValue, m.running, a)
+	}
+	if m.running_cpus > 0 {
+		ch <- prometheus.MustNewConstMetric(ac.running_cpus, prometheus.GaugeValue, m.running_cpus, a)
+	}
+	if m.suspended > 0 {
+		ch <- prometheus.MustNewConstMetric(ac.suspended, prometheus.GaugeValue, m.suspended, a)
 	}
 }
 
@@ -95,10 +137,25 @@ func NewJobMetrics() *JobMetrics {
 	return &JobMetrics{}
 }
 
+// weight orders accounts when folding them into other, the accounts with the
+// most cpus in use or requested are kept
+func (m *JobMetrics) weight() float64 {
+	return m.pending_cpus + m.running_cpus
+}
+
+func (m *JobMetrics) add(o *JobMetrics) {
+	m.pending += o.pending
+	m.pending_cpus += o.pending_cpus
+	m.running += o.running
+	m.running_cpus += o.running_cpus
+	m.suspended += o.suspended
+}
+
 // ParseAccountsMetrics gets the response body of jobs from SLURM and
 // parses it into a map of "accountName": *JobMetrics
 func ParseAccountsMetrics(jobsData api.JobsData) (map[string]*JobMetrics, error) {
 	accounts := make(map[string]*JobMetrics)
+	counter := make(jobCounter)
 	for _, j := range jobsData.Jobs {
 		// build the map with the account name as the key and job metrics as the value
 		_, key := accounts[j.Account]
@@ -108,15 +165,19 @@ func ParseAccountsMetrics(jobsData api.JobsData) (map[string]*JobMetrics, error)
 		}
 		// for each of the jobs, depending on the state,
 		// tally up the cpu count and increment the count of jobs for that state
+		// a pending job array counts as its tasks, and a het job as one job
+		n := counter.count(j, j.Account)
 		switch j.JobState {
 		case types.JobStatePending:
-			accounts[j.Account].pending++
-			accounts[j.Account].pending_cpus += float64(j.Cpus)
+			accounts[j.Account].pending += n
+			// slurm only reports the cpus of a job once it is
+			// allocated, a pending job requests them in its TRES
+			accounts[j.Account].pending_cpus += float64(j.TresCpus) * float64(j.Tasks())
 		case types.JobStateRunning:
-			accounts[j.Account].running++
+			accounts[j.Account].running += n
 			accounts[j.Account].running_cpus += float64(j.Cpus)
 		case types.JobStateSuspended:
-			accounts[j.Account].suspended++
+			accounts[j.Account].suspended += n
 		}
 	}
 	return accounts, nil
@@ -0,0 +1,50 @@
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BenchmarkScrape measures a whole scrape, polling a fake slurmrestd and
+// running every collector, on clusters with a large number of jobs
+func BenchmarkScrape(b *testing.B) {
+	for _, n := range []int{1000, 20000} {
+		b.Run(fmt.Sprintf("jobs=%d", n), func(b *testing.B) {
+			jobs, err := fakeslurm.SyntheticJobs(n)
+			if err != nil {
+				b.Fatalf("failed to generate jobs: %v", err)
+			}
+			s := fakeslurm.NewServer("slurm", "secret")
+			defer s.Close()
+			s.SetBody("jobs", jobs)
+
+			ctx := context.Background()
+			ctx = context.WithValue(ctx, types.ApiUserKey, "slurm")
+			ctx = context.WithValue(ctx, types.ApiTokenKey, "secret")
+			ctx = context.WithValue(ctx, types.ApiURLKey, s.URL)
+			ctx = context.WithValue(ctx, types.ApiCacheKey, api.NewCache())
+			ctx = context.WithValue(ctx, types.MetricSchemaKey, types.MetricSchemaV2)
+			ctx = api.RegisterEndpoints(ctx)
+			r := prometheus.NewRegistry()
+			r.MustRegister(NewCollectors(ctx)...)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := api.PopulateCache(ctx); err != nil {
+					b.Fatalf("failed to poll: %v", err)
+				}
+				if _, err := r.Gather(); err != nil {
+					b.Fatalf("failed to gather: %v", err)
+				}
+				api.WipeCache(ctx)
+			}
+		})
+	}
+}
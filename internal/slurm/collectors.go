@@ -0,0 +1,41 @@
+package slurm
+
+import (
+	"context"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCollectors returns every collector the exporter serves
+func NewCollectors(ctx context.Context) []prometheus.Collector {
+	return []prometheus.Collector{
+		NewAccountsCollector(ctx),
+		NewCPUsCollector(ctx),
+		NewDecodeErrorsCollector(ctx),
+		NewGPUsCollector(ctx),
+		NewNodesCollector(ctx),
+		NewNodeCollector(ctx),
+		NewPartitionsCollector(ctx),
+		NewPriorityCollector(ctx),
+		NewFairShareCollector(ctx),
+		NewQueueCollector(ctx),
+		NewSchedulerCollector(ctx),
+		NewUsersCollector(ctx),
+	}
+}
+
+// DescNames maps the descriptors of the collectors to their metric names,
+// which prometheus.Desc doesn't expose. Collectors built with a DescNames in
+// their context under types.DescNamesKey record every descriptor they make.
+type DescNames map[*prometheus.Desc]string
+
+// newDesc makes a descriptor like prometheus.NewDesc, recording its name in
+// the DescNames of ctx if there is one
+func newDesc(ctx context.Context, fqName string, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	d := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+	if names, ok := ctx.Value(types.DescNamesKey).(DescNames); ok {
+		names[d] = fqName
+	}
+	return d
+}
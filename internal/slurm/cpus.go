@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,61 +11,70 @@ import (
 
 // CPU metrics collector
 type CPUsCollector struct {
-	ctx   context.Context
-	alloc *prometheus.Desc
-	idle  *prometheus.Desc
-	other *prometheus.Desc
-	total *prometheus.Desc
+	ctx    context.Context
+	schema types.MetricSchema
+	cpus   *prometheus.Desc
+	alloc  *prometheus.Desc
+	idle   *prometheus.Desc
+	other  *prometheus.Desc
+	total  *prometheus.Desc
 }
 
 // NewCPUsCollector creates a new CPUsCollector
 func NewCPUsCollector(ctx context.Context) *CPUsCollector {
 	return &CPUsCollector{
-		ctx:   ctx,
-		alloc: prometheus.NewDesc("slurm_cpus_alloc", "Allocated CPUs", nil, nil),
-		idle:  prometheus.NewDesc("slurm_cpus_idle", "Idle CPUs", nil, nil),
-		other: prometheus.NewDesc("slurm_cpus_other", "Mix CPUs", nil, nil),
-		total: prometheus.NewDesc("slurm_cpus_total", "Total CPUs", nil, nil),
+		ctx:    ctx,
+		schema: metricSchema(ctx),
+		cpus:   newDesc(ctx, "slurm_cpus", "CPUs by state", []string{"state"}, nil),
+		alloc:  newDesc(ctx, "slurm_cpus_alloc", "Allocated CPUs", nil, nil),
+		idle:   newDesc(ctx, "slurm_cpus_idle", "Idle CPUs", nil, nil),
+		other:  newDesc(ctx, "slurm_cpus_other", "Other CPUs", nil, nil),
+		total:  newDesc(ctx, "slurm_cpus_total", "Total CPUs", nil, nil),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (cc *CPUsCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *cc
+	c.ctx = ctx
+	return &c
+}
+
 func (cc *CPUsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- cc.alloc
-	ch <- cc.idle
-	ch <- cc.other
+	if cc.schema.Legacy() {
+		ch <- cc.alloc
+		ch <- cc.idle
+		ch <- cc.other
+	}
+	if cc.schema.V2() {
+		ch <- cc.cpus
+	}
 	ch <- cc.total
 }
 
 func (cc *CPUsCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := cc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	jobsRespBytes, found := apiCache.Get("jobs")
-	if !found {
-		slog.Error("failed to get jobs response for users metrics from cache")
+	snapshot := api.CurrentSnapshot(cc.ctx)
+	nodesData := snapshot.Nodes
+	if nodesData == nil {
+		slog.Error("failed to get nodes data for cpu metrics", "error", snapshot.Err("nodes"))
 		return
 	}
-	jobsData, err := api.ProcessJobsResponse(jobsRespBytes.([]byte))
+	cm, err := ParseCPUsMetrics(nodesData)
 	if err != nil {
-		slog.Error("failed to process jobs response for cpu metrics", "error", err)
-		return
-	}
-	nodesRespBytes, found := apiCache.Get("nodes")
-	if !found {
-		slog.Error("failed to get nodes response for cpu metrics from cache")
+		slog.Error("failed to collect cpus metrics", "error", err)
 		return
 	}
-	nodesData, err := api.ProcessNodesResponse(nodesRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process nodes response for cpu metrics", "error", err)
-		return
+	if cc.schema.Legacy() {
+		ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm.alloc)
+		ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm.idle)
+		ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, cm.other)
 	}
-	cm, err := ParseCPUsMetrics(nodesData, jobsData)
-	if err != nil {
-		slog.Error("failed to collect cpus metrics", "error", err)
-		return
+	if cc.schema.V2() {
+		ch <- prometheus.MustNewConstMetric(cc.cpus, prometheus.GaugeValue, cm.alloc, "alloc")
+		ch <- prometheus.MustNewConstMetric(cc.cpus, prometheus.GaugeValue, cm.idle, "idle")
+		ch <- prometheus.MustNewConstMetric(cc.cpus, prometheus.GaugeValue, cm.other, "other")
 	}
-	ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm.alloc)
-	ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm.idle)
-	ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, cm.other)
 	ch <- prometheus.MustNewConstMetric(cc.total, prometheus.GaugeValue, cm.total)
 }
 
@@ -81,41 +89,23 @@ func NewCPUsMetrics() *cpusMetrics {
 	return &cpusMetrics{}
 }
 
-// ParseCPUMetrics pulls out total cluster cpu states of alloc,idle,other,total
-func ParseCPUsMetrics(nodesData *api.NodesData, jobsData *api.JobsData) (*cpusMetrics, error) {
+// ParseCPUsMetrics pulls out total cluster cpu states of alloc,idle,other,total
+// the way sinfo -o %C reports them. The states come from the nodes rather
+// than the running jobs, so they always add up to the total.
+func ParseCPUsMetrics(nodesData *api.NodesData) (*cpusMetrics, error) {
 	cm := NewCPUsMetrics()
-	for _, j := range jobsData.Jobs {
-		// alloc is easy, we just add up all the cpus in the "Running" job state
-		if j.JobState == types.JobStateRunning {
-			cm.alloc += float64(j.Cpus)
-		}
-	}
-	// total is just the total number of cpus in the cluster
-	nodes := nodesData.Nodes
-	for _, n := range nodes {
-		if n.Cpus == 1 {
-			// TODO: This probably needs to be a call to partitions to get all nodes
-			// in a partition, then add the nodes CPU values up for this field.
-			// In our environment, nodes that exist (need slurm commands) get
-			// put into slurm without being assigned a partition, but slurm
-			// seems to track these systems with cpus=1.
-			// This isn't a problem unless your site has nodes with a single CPU.
+	for _, n := range nodesData.Nodes {
+		// nodes outside of every partition, such as login or transfer nodes
+		// registered to run slurm commands, can't run jobs and aren't part
+		// of the cluster cpus in sinfo either
+		if len(n.Partitions) == 0 {
 			continue
 		}
-		cpus := float64(n.Cpus)
-		cm.total += cpus
-
-		for _, ns := range n.States {
-			if ns == types.NodeStateMix || ns == types.NodeStateAlloc || ns == types.NodeStateIdle {
-				// TODO: This calculate is scuffed. In our 17k core environment, it's
-				// reporting ~400 more than the `sinfo -h -o '%C'` command.
-				// Gotta figure this one out.
-				idle_cpus := float64(n.AllocIdleCpus)
-				cm.idle += idle_cpus
-			}
-		}
+		alloc, idle, other := n.CPUStates()
+		cm.alloc += float64(alloc)
+		cm.idle += float64(idle)
+		cm.other += float64(other)
+		cm.total += float64(n.Cpus)
 	}
-	// Assumedly, this should be fine.
-	cm.other = cm.total - cm.idle - cm.alloc
 	return cm, nil
 }
@@ -0,0 +1,115 @@
+package slurm
+
+import (
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+)
+
+// fixtureNodes returns the nodes served by the fake slurmrestd, with extra
+// nodes made from the first fixture node in each of the given states
+func fixtureNodes(t *testing.T, states map[string][]string) *api.NodesData {
+	b, err := fakeslurm.Fixture("nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodesData, err := api.ProcessNodesResponse(b)
+	if err != nil {
+		t.Fatalf("failed to decode nodes fixture: %v", err)
+	}
+	b, err = fakeslurm.MutatedFixture("nodes", "nodes", func(template func() map[string]any) []map[string]any {
+		var nodes []map[string]any
+		for name, state := range states {
+			n := template()
+			n["name"] = name
+			n["hostname"] = name
+			n["state"] = state
+			n["partitions"] = []string{"fixture"}
+			n["cpus"] = 16
+			n["alloc_cpus"] = 4
+			n["alloc_idle_cpus"] = 12
+			nodes = append(nodes, n)
+		}
+		return nodes
+	})
+	if err != nil {
+		t.Fatalf("failed to mutate nodes fixture: %v", err)
+	}
+	extra, err := api.ProcessNodesResponse(b)
+	if err != nil {
+		t.Fatalf("failed to decode mutated nodes fixture: %v", err)
+	}
+	nodesData.Nodes = append(nodesData.Nodes, extra.Nodes...)
+	return nodesData
+}
+
+func fixturePartitions(t *testing.T) *api.PartitionsData {
+	b, err := fakeslurm.Fixture("partitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitionsData, err := api.ProcessPartitionsResponse(b)
+	if err != nil {
+		t.Fatalf("failed to decode partitions fixture: %v", err)
+	}
+	return partitionsData
+}
+
+var cpuTestStates = map[string][]string{
+	"mixed":    {"MIXED"},
+	"draining": {"MIXED", "DRAIN"},
+	"down":     {"DOWN", "NOT_RESPONDING"},
+	"failing":  {"ALLOCATED", "FAIL"},
+	"idle":     {"IDLE", "POWERED_DOWN"},
+}
+
+func TestParseCPUsMetricsAddsUp(t *testing.T) {
+	nodesData := fixtureNodes(t, cpuTestStates)
+	cm, err := ParseCPUsMetrics(nodesData)
+	if err != nil {
+		t.Fatalf("failed to parse cpus metrics: %v", err)
+	}
+	if cm.alloc+cm.idle+cm.other != cm.total {
+		t.Fatalf("expected alloc+idle+other to be the total, got %+v", *cm)
+	}
+
+	// the total is the cpus of the nodes in a partition, whatever their cpus
+	var total, other float64
+	for _, n := range nodesData.Nodes {
+		if len(n.Partitions) > 0 {
+			total += float64(n.Cpus)
+		}
+	}
+	if cm.total != total {
+		t.Errorf("expected %v cpus in the partitions, got %v", total, cm.total)
+	}
+	// the cpus of the down, draining and failing nodes are all other, even the
+	// allocated ones
+	for _, n := range nodesData.Nodes {
+		if _, ok := cpuTestStates[n.Name]; ok {
+			_, _, o := n.CPUStates()
+			other += float64(o)
+		}
+	}
+	if other != 48 {
+		t.Errorf("expected the cpus of 3 unavailable nodes to be other, got %v", other)
+	}
+}
+
+func TestParsePartitionsMetricsAddsUp(t *testing.T) {
+	nodesData := fixtureNodes(t, cpuTestStates)
+	pm, err := ParsePartitionsMetrics(fixturePartitions(t), api.NewJobsData(), nodesData)
+	if err != nil {
+		t.Fatalf("failed to parse partitions metrics: %v", err)
+	}
+	for name, p := range pm {
+		if p.cpus_allocated+p.cpus_idle+p.cpus_other != p.cpus_total {
+			t.Errorf("expected alloc+idle+other to be the total of partition %s, got %+v", name, *p)
+		}
+	}
+	want := [4]float64{8, 24, 48, 80}
+	if p := pm["fixture"]; p == nil || [4]float64{p.cpus_allocated, p.cpus_idle, p.cpus_other, p.cpus_total} != want {
+		t.Errorf("expected the fixture partition cpus to be %v, got %+v", want, p)
+	}
+}
@@ -0,0 +1,41 @@
+package slurm
+
+import (
+	"context"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DecodeErrorsCollector reports the fields of the slurmrestd responses that
+// couldn't be decoded. The records with those fields were skipped or exported
+// with the field unknown, so a rising count means the metrics are missing
+// part of the cluster.
+type DecodeErrorsCollector struct {
+	ctx          context.Context
+	decodeErrors *prometheus.Desc
+}
+
+func NewDecodeErrorsCollector(ctx context.Context) *DecodeErrorsCollector {
+	return &DecodeErrorsCollector{
+		ctx:          ctx,
+		decodeErrors: newDesc(ctx, "slurm_exporter_decode_errors_total", "Fields of the slurmrestd responses that couldn't be decoded", []string{"endpoint", "field"}, nil),
+	}
+}
+
+func (dc *DecodeErrorsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dc.decodeErrors
+}
+
+func (dc *DecodeErrorsCollector) Collect(ch chan<- prometheus.Metric) {
+	status, ok := dc.ctx.Value(types.ApiStatusKey).(*api.Status)
+	if !ok {
+		return
+	}
+	for _, e := range status.Report().Endpoints {
+		for field, n := range e.DecodeErrors {
+			ch <- prometheus.MustNewConstMetric(dc.decodeErrors, prometheus.CounterValue, float64(n), e.Name, field)
+		}
+	}
+}
@@ -4,9 +4,7 @@ import (
 	"context"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
-	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -19,27 +17,31 @@ func NewFairShareCollector(ctx context.Context) *FairShareCollector {
 	labels := []string{"account"}
 	return &FairShareCollector{
 		ctx:       ctx,
-		fairshare: prometheus.NewDesc("slurm_account_fairshare", "FairShare for account", labels, nil),
+		fairshare: newDesc(ctx, "slurm_account_fairshare", "FairShare for account", labels, nil),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (fsc *FairShareCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *fsc
+	c.ctx = ctx
+	return &c
+}
+
 func (fsc *FairShareCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- fsc.fairshare
 }
 
 func (fsc *FairShareCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := fsc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	sharesRespBytes, found := apiCache.Get("shares")
-	if !found {
-		slog.Error("failed to get shares response for fair share metrics from cache")
-		return
-	}
+	snapshot := api.CurrentSnapshot(fsc.ctx)
 
-	sharesData, err := api.ProcessSharesResponse(sharesRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process shares response for fair share metrics", "error", err)
+	sharesData := snapshot.Shares
+	if sharesData == nil {
+		slog.Error("failed to get shares data for fair share metrics", "error", snapshot.Err("shares"))
 		return
 	}
+	sharesData = relabelShares(fsc.ctx, sharesData)
 	fsm, err := ParseFairShareMetrics(sharesData)
 	if err != nil {
 		slog.Error("failed to collect fair share metrics", "error", err)
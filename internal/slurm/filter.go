@@ -0,0 +1,118 @@
+package slurm
+
+import (
+	"context"
+	"sort"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherLabelValue is the label value the series over a collector's limit are
+// folded into
+const otherLabelValue = "other"
+
+// seriesFilter returns the series filter configured for the named collector,
+// or nil if its series aren't filtered
+func seriesFilter(ctx context.Context, collector string) *types.SeriesFilter {
+	filters, _ := ctx.Value(types.SeriesFiltersKey).(map[string]*types.SeriesFilter)
+	return filters[collector]
+}
+
+// newDroppedDesc returns the self-metric reporting how many series a
+// collector didn't export. The collector is a constant label so every
+// filtered collector can register its own descriptor.
+func newDroppedDesc(ctx context.Context, collector string) *prometheus.Desc {
+	return newDesc(ctx,
+		"slurm_exporter_dropped_series",
+		"Series not exported by the collector in the last scrape, because their label value was excluded or folded into other",
+		[]string{"reason"},
+		prometheus.Labels{"collector": collector},
+	)
+}
+
+// droppedValues are the label values dropped by filterSeries, with their
+// metrics
+type droppedValues[T any] struct {
+	excluded map[string]*T
+	folded   map[string]*T
+}
+
+// collect sends the number of series the dropped values would have been
+// exported as, counting the metrics series sends for each of them
+func (d droppedValues[T]) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc, series func(ch chan<- prometheus.Metric, name string, m *T)) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(countSeries(d.excluded, series)), "excluded")
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(countSeries(d.folded, series)), "folded")
+}
+
+// countSeries returns the number of metrics series sends for the values
+func countSeries[T any](values map[string]*T, series func(ch chan<- prometheus.Metric, name string, m *T)) int {
+	if len(values) == 0 {
+		return 0
+	}
+	ch := make(chan prometheus.Metric, 64)
+	count := make(chan int)
+	go func() {
+		n := 0
+		for range ch {
+			n++
+		}
+		count <- n
+	}()
+	for name, m := range values {
+		series(ch, name, m)
+	}
+	close(ch)
+	return <-count
+}
+
+// filterSeries removes the label values of m rejected by the filter, then
+// keeps the limit-1 values with the largest weight and merges the rest into
+// an "other" value. Ties are broken by name so the kept values are stable
+// between scrapes.
+func filterSeries[T any](f *types.SeriesFilter, m map[string]*T, weight func(*T) float64, merge func(dst *T, src *T)) (map[string]*T, droppedValues[T]) {
+	d := droppedValues[T]{excluded: make(map[string]*T), folded: make(map[string]*T)}
+	if f == nil {
+		return m, d
+	}
+	var names []string
+	for name := range m {
+		if !f.Allowed(name) {
+			d.excluded[name] = m[name]
+			continue
+		}
+		names = append(names, name)
+	}
+	if f.Limit == 0 || len(names) <= f.Limit {
+		filtered := make(map[string]*T, len(names))
+		for _, name := range names {
+			filtered[name] = m[name]
+		}
+		return filtered, d
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		wi, wj := weight(m[names[i]]), weight(m[names[j]])
+		if wi != wj {
+			return wi > wj
+		}
+		return names[i] < names[j]
+	})
+	// the other value takes up one of the slots
+	kept := f.Limit - 1
+	filtered := make(map[string]*T, f.Limit)
+	other := new(T)
+	for i, name := range names {
+		if i < kept {
+			filtered[name] = m[name]
+			continue
+		}
+		merge(other, m[name])
+		d.folded[name] = m[name]
+	}
+	if existing, ok := filtered[otherLabelValue]; ok {
+		merge(other, existing)
+	}
+	filtered[otherLabelValue] = other
+	return filtered, d
+}
@@ -0,0 +1,115 @@
+package slurm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func testUsersMetrics() map[string]*userJobMetrics {
+	return map[string]*userJobMetrics{
+		"alice": {running: 1, running_cpus: 64},
+		"bob":   {running: 2, running_cpus: 32},
+		"carol": {pending: 1, pending_cpus: 16},
+		"dave":  {pending: 3, pending_cpus: 8},
+		"root":  {running: 1, running_cpus: 1},
+	}
+}
+
+func TestFilterSeriesRegex(t *testing.T) {
+	f, err := types.NewSeriesFilter("[a-d].*", "bob", 0)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	um, dropped := filterSeries(f, testUsersMetrics(), (*userJobMetrics).weight, (*userJobMetrics).add)
+	if len(um) != 3 || um["bob"] != nil || um["root"] != nil {
+		t.Fatalf("expected alice, carol and dave, got %v", um)
+	}
+	if len(dropped.excluded) != 2 || len(dropped.folded) != 0 {
+		t.Fatalf("unexpected dropped counts %+v", dropped)
+	}
+
+	// regexes are anchored
+	f, _ = types.NewSeriesFilter("", "a", 0)
+	um, _ = filterSeries(f, testUsersMetrics(), (*userJobMetrics).weight, (*userJobMetrics).add)
+	if len(um) != 5 {
+		t.Fatalf("expected an anchored exclude regex to match nothing, got %v", um)
+	}
+}
+
+func TestFilterSeriesLimit(t *testing.T) {
+	f, _ := types.NewSeriesFilter("", "root", 3)
+	um, dropped := filterSeries(f, testUsersMetrics(), (*userJobMetrics).weight, (*userJobMetrics).add)
+	if len(um) != 3 || um["alice"] == nil || um["bob"] == nil {
+		t.Fatalf("expected the two largest users and other, got %v", um)
+	}
+	other := um[otherLabelValue]
+	if other == nil || other.pending != 4 || other.pending_cpus != 24 {
+		t.Fatalf("expected carol and dave to be folded into other, got %+v", other)
+	}
+	if len(dropped.excluded) != 1 || len(dropped.folded) != 2 {
+		t.Fatalf("unexpected dropped counts %+v", dropped)
+	}
+
+	// no filter leaves the metrics alone
+	um, dropped = filterSeries(nil, testUsersMetrics(), (*userJobMetrics).weight, (*userJobMetrics).add)
+	if len(um) != 5 || len(dropped.excluded) != 0 || len(dropped.folded) != 0 {
+		t.Fatalf("expected no filtering without a filter")
+	}
+}
+
+func TestFilterSeriesDroppedSeries(t *testing.T) {
+	f, _ := types.NewSeriesFilter("", "root", 3)
+	_, dropped := filterSeries(f, testUsersMetrics(), (*userJobMetrics).weight, (*userJobMetrics).add)
+	for _, tc := range []struct {
+		schema   types.MetricSchema
+		excluded int
+		folded   int
+	}{
+		// every user has 5 series in v2
+		{types.MetricSchemaV2, 5, 10},
+		// and only the non zero ones in v1
+		{types.MetricSchemaV1, 2, 4},
+		{types.MetricSchemaBoth, 7, 14},
+	} {
+		ctx := context.WithValue(context.Background(), types.MetricSchemaKey, tc.schema)
+		uc := NewUsersCollector(ctx)
+		excluded, folded := countSeries(dropped.excluded, uc.collectUser), countSeries(dropped.folded, uc.collectUser)
+		if excluded != tc.excluded || folded != tc.folded {
+			t.Errorf("expected %d excluded and %d folded series with schema %s, got %d and %d", tc.excluded, tc.folded, tc.schema, excluded, folded)
+		}
+	}
+}
+
+func TestSeriesFilterInvalid(t *testing.T) {
+	if _, err := types.NewSeriesFilter("(", "", 0); err == nil {
+		t.Fatalf("expected an error for an invalid regex")
+	}
+	if _, err := types.NewSeriesFilter("", "", -1); err == nil {
+		t.Fatalf("expected an error for a negative limit")
+	}
+}
+
+func TestFilteredCollectorsRegister(t *testing.T) {
+	filters := make(map[string]*types.SeriesFilter)
+	for _, c := range []string{"users", "accounts", "nodes", "partitions"} {
+		filters[c], _ = types.NewSeriesFilter("", "", 10)
+	}
+	ctx := context.WithValue(context.Background(), types.SeriesFiltersKey, filters)
+	// every collector registers its own dropped series descriptor
+	r := prometheus.NewRegistry()
+	if err := r.Register(NewUsersCollector(ctx)); err != nil {
+		t.Fatalf("failed to register users collector: %v", err)
+	}
+	if err := r.Register(NewAccountsCollector(ctx)); err != nil {
+		t.Fatalf("failed to register accounts collector: %v", err)
+	}
+	if err := r.Register(NewNodeCollector(ctx)); err != nil {
+		t.Fatalf("failed to register node collector: %v", err)
+	}
+	if err := r.Register(NewPartitionsCollector(ctx)); err != nil {
+		t.Fatalf("failed to register partitions collector: %v", err)
+	}
+}
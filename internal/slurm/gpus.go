@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,6 +11,8 @@ import (
 
 type GPUsCollector struct {
 	ctx         context.Context
+	schema      types.MetricSchema
+	gpus        *prometheus.Desc
 	alloc       *prometheus.Desc
 	idle        *prometheus.Desc
 	other       *prometheus.Desc
@@ -22,31 +23,41 @@ type GPUsCollector struct {
 func NewGPUsCollector(ctx context.Context) *GPUsCollector {
 	return &GPUsCollector{
 		ctx:         ctx,
-		alloc:       prometheus.NewDesc("slurm_gpus_alloc", "Allocated GPUs", nil, nil),
-		idle:        prometheus.NewDesc("slurm_gpus_idle", "Idle GPUs", nil, nil),
-		other:       prometheus.NewDesc("slurm_gpus_other", "Other GPUs", nil, nil),
-		total:       prometheus.NewDesc("slurm_gpus_total", "Total GPUs", nil, nil),
-		utilization: prometheus.NewDesc("slurm_gpus_utilization", "Total GPU utilization", nil, nil),
+		schema:      metricSchema(ctx),
+		gpus:        newDesc(ctx, "slurm_gpus", "GPUs by state", []string{"state"}, nil),
+		alloc:       newDesc(ctx, "slurm_gpus_alloc", "Allocated GPUs", nil, nil),
+		idle:        newDesc(ctx, "slurm_gpus_idle", "Idle GPUs", nil, nil),
+		other:       newDesc(ctx, "slurm_gpus_other", "Other GPUs", nil, nil),
+		total:       newDesc(ctx, "slurm_gpus_total", "Total GPUs", nil, nil),
+		utilization: newDesc(ctx, "slurm_gpus_utilization", "Total GPU utilization", nil, nil),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (cc *GPUsCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *cc
+	c.ctx = ctx
+	return &c
+}
+
 func (cc *GPUsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- cc.alloc
-	ch <- cc.idle
-	ch <- cc.other
+	if cc.schema.Legacy() {
+		ch <- cc.alloc
+		ch <- cc.idle
+		ch <- cc.other
+	}
+	if cc.schema.V2() {
+		ch <- cc.gpus
+	}
 	ch <- cc.total
 	ch <- cc.utilization
 }
 func (cc *GPUsCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := cc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	nodesRespBytes, found := apiCache.Get("nodes")
-	if !found {
-		slog.Error("failed to get nodes response for cpu metrics from cache")
-		return
-	}
-	nodesData, err := api.ProcessNodesResponse(nodesRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process nodes response for gpu metrics", "error", err)
+	snapshot := api.CurrentSnapshot(cc.ctx)
+	nodesData := snapshot.Nodes
+	if nodesData == nil {
+		slog.Error("failed to get nodes data for gpu metrics", "error", snapshot.Err("nodes"))
 		return
 	}
 	gm, err := ParseGPUsMetrics(nodesData)
@@ -54,9 +65,16 @@ func (cc *GPUsCollector) Collect(ch chan<- prometheus.Metric) {
 		slog.Error("failed to collect gpus metrics", "error", err)
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, gm.alloc)
-	ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, gm.idle)
-	ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, gm.other)
+	if cc.schema.Legacy() {
+		ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, gm.alloc)
+		ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, gm.idle)
+		ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, gm.other)
+	}
+	if cc.schema.V2() {
+		ch <- prometheus.MustNewConstMetric(cc.gpus, prometheus.GaugeValue, gm.alloc, "alloc")
+		ch <- prometheus.MustNewConstMetric(cc.gpus, prometheus.GaugeValue, gm.idle, "idle")
+		ch <- prometheus.MustNewConstMetric(cc.gpus, prometheus.GaugeValue, gm.other, "other")
+	}
 	ch <- prometheus.MustNewConstMetric(cc.total, prometheus.GaugeValue, gm.total)
 	ch <- prometheus.MustNewConstMetric(cc.utilization, prometheus.GaugeValue, gm.utilization)
 }
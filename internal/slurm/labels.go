@@ -0,0 +1,74 @@
+package slurm
+
+import (
+	"context"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// The user and account names are rewritten in the parsed data, before the
+// metrics are aggregated, so names that transform to the same value (or are
+// dropped) are summed into one series instead of producing duplicates. The
+// parsed data is shared by every collector, so it is copied rather than
+// rewritten in place.
+
+// relabelJobs returns the jobs with the user and account label transforms
+// from the context applied
+func relabelJobs(ctx context.Context, jobsData *api.JobsData) *api.JobsData {
+	users, _ := ctx.Value(types.UserLabelTransformKey).(*types.LabelTransform)
+	accounts, _ := ctx.Value(types.AccountLabelTransformKey).(*types.LabelTransform)
+	if users == nil && accounts == nil {
+		return jobsData
+	}
+	relabeled := *jobsData
+	relabeled.Jobs = make([]api.JobData, len(jobsData.Jobs))
+	copy(relabeled.Jobs, jobsData.Jobs)
+	for i := range relabeled.Jobs {
+		relabeled.Jobs[i].UserName = users.Apply(relabeled.Jobs[i].UserName)
+		relabeled.Jobs[i].Account = accounts.Apply(relabeled.Jobs[i].Account)
+	}
+	return &relabeled
+}
+
+// relabelShares returns the shares with the account label transform from the
+// context applied
+func relabelShares(ctx context.Context, sharesData *api.SharesData) *api.SharesData {
+	accounts, _ := ctx.Value(types.AccountLabelTransformKey).(*types.LabelTransform)
+	if accounts == nil {
+		return sharesData
+	}
+	relabeled := *sharesData
+	relabeled.Shares = make([]api.ShareData, len(sharesData.Shares))
+	copy(relabeled.Shares, sharesData.Shares)
+	for i := range relabeled.Shares {
+		// the root account is skipped by name when parsing
+		if relabeled.Shares[i].Name == "root" {
+			continue
+		}
+		relabeled.Shares[i].Name = accounts.Apply(relabeled.Shares[i].Name)
+	}
+	return &relabeled
+}
+
+// relabelDiag returns the diag statistics with the user label transform from
+// the context applied to the rpcs by user. The rpcs of users that transform
+// to the same value are summed when parsing, and with the drop transform
+// they are left out, since a single series for every user says nothing the
+// rpcs by message type don't.
+func relabelDiag(ctx context.Context, diagData *api.DiagData) *api.DiagData {
+	users, _ := ctx.Value(types.UserLabelTransformKey).(*types.LabelTransform)
+	if users == nil {
+		return diagData
+	}
+	relabeled := *diagData
+	relabeled.RpcsByUser = make([]api.RpcData, 0, len(diagData.RpcsByUser))
+	for _, rpc := range diagData.RpcsByUser {
+		rpc.Name = users.Apply(rpc.Name)
+		if rpc.Name == "" {
+			continue
+		}
+		relabeled.RpcsByUser = append(relabeled.RpcsByUser, rpc)
+	}
+	return &relabeled
+}
@@ -0,0 +1,92 @@
+package slurm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func testJobsData() *api.JobsData {
+	return &api.JobsData{
+		Jobs: []api.JobData{
+			{JobId: 1, UserName: "alice", Account: "physics", JobState: types.JobStatePending, Cpus: 4},
+			{JobId: 2, UserName: "bob", Account: "physics", JobState: types.JobStateRunning, Cpus: 8},
+			{JobId: 3, UserName: "carol", Account: "chemistry", JobState: types.JobStateRunning, Cpus: 2},
+		},
+	}
+}
+
+func TestLabelTransformHash(t *testing.T) {
+	a, _ := types.NewLabelTransform(types.LabelModeHash, []byte("secret"), nil)
+	b, _ := types.NewLabelTransform(types.LabelModeHash, []byte("secret"), nil)
+	other, _ := types.NewLabelTransform(types.LabelModeHash, []byte("other"), nil)
+
+	// hashes must survive restarts, so only depend on the secret
+	if a.Apply("alice") != b.Apply("alice") {
+		t.Fatalf("expected the same hash for the same secret")
+	}
+	if a.Apply("alice") == other.Apply("alice") || a.Apply("alice") == a.Apply("bob") {
+		t.Fatalf("expected different hashes for different secrets and names")
+	}
+	if a.Apply("alice") == "alice" || len(a.Apply("alice")) != 16 {
+		t.Fatalf("unexpected hash %q", a.Apply("alice"))
+	}
+	if _, err := types.NewLabelTransform(types.LabelModeHash, nil, nil); err == nil {
+		t.Fatalf("expected an error hashing without a secret")
+	}
+}
+
+func TestLabelTransformMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	os.WriteFile(path, []byte("# name,alias\nalice,user-a\nbob, user-b\n"), 0600)
+	mapping, err := types.ReadLabelMap(path)
+	if err != nil {
+		t.Fatalf("failed to read label map: %v", err)
+	}
+
+	m, _ := types.NewLabelTransform(types.LabelModeMap, nil, mapping)
+	if m.Apply("alice") != "user-a" || m.Apply("bob") != "user-b" || m.Apply("carol") != "other" {
+		t.Fatalf("unexpected mapped values %q %q %q", m.Apply("alice"), m.Apply("bob"), m.Apply("carol"))
+	}
+	hashed, _ := types.NewLabelTransform(types.LabelModeMap, []byte("secret"), mapping)
+	h, _ := types.NewLabelTransform(types.LabelModeHash, []byte("secret"), nil)
+	if hashed.Apply("carol") != h.Apply("carol") {
+		t.Fatalf("expected unmapped names to be hashed when a secret is set")
+	}
+}
+
+func TestRelabelJobs(t *testing.T) {
+	drop, _ := types.NewLabelTransform(types.LabelModeDrop, nil, nil)
+	mapping := map[string]string{"physics": "science", "chemistry": "science"}
+	science, _ := types.NewLabelTransform(types.LabelModeMap, nil, mapping)
+	ctx := context.WithValue(context.Background(), types.UserLabelTransformKey, drop)
+	ctx = context.WithValue(ctx, types.AccountLabelTransformKey, science)
+
+	original := testJobsData()
+	jobsData := relabelJobs(ctx, original)
+
+	// the parsed data is shared by the collectors, so it must not change
+	if original.Jobs[0].UserName != "alice" || original.Jobs[0].Account != "physics" {
+		t.Fatalf("expected the original jobs not to be relabeled")
+	}
+
+	// dropped and merged names are summed into a single series
+	um, _ := ParseUsersMetrics(jobsData)
+	if len(um) != 1 || um[""].running != 2 || um[""].running_cpus != 10 || um[""].pending != 1 {
+		t.Fatalf("expected users to be aggregated into one series, got %v", um)
+	}
+	am, _ := ParseAccountsMetrics(*jobsData)
+	if len(am) != 1 || am["science"].running_cpus != 10 {
+		t.Fatalf("expected accounts to be merged, got %v", am)
+	}
+
+	// no transforms leaves the names alone
+	jobsData = relabelJobs(context.Background(), testJobsData())
+	if jobsData.Jobs[0].UserName != "alice" || jobsData.Jobs[0].Account != "physics" {
+		t.Fatalf("expected names to be kept without transforms")
+	}
+}
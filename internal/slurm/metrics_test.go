@@ -0,0 +1,219 @@
+package slurm
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/util"
+)
+
+var update = flag.Bool("update", false, "update the golden exposition files")
+
+// newTestExporter wires the exporter up the same way main does, against the
+// given slurmrestd url, and serves its /metrics endpoint
+func newTestExporter(t *testing.T, url string, user string, token string, schema types.MetricSchema, minRefresh time.Duration) *httptest.Server {
+	return serveTestExporter(t, newTestContext(url, user, token, schema, minRefresh))
+}
+
+// newTestContext returns the context main passes to the collectors, tests
+// can add their own settings to it before serving it
+func newTestContext(url string, user string, token string, schema types.MetricSchema, minRefresh time.Duration) context.Context {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, types.ApiUserKey, user)
+	ctx = context.WithValue(ctx, types.ApiTokenKey, token)
+	ctx = context.WithValue(ctx, types.ApiURLKey, url)
+	ctx = context.WithValue(ctx, types.ApiCacheKey, api.NewCache())
+	ctx = context.WithValue(ctx, types.ApiCacheTimeoutKey, minRefresh)
+	ctx = context.WithValue(ctx, types.MetricSchemaKey, schema)
+	ctx = context.WithValue(ctx, types.ApiStatusKey, api.NewStatus())
+	return api.RegisterEndpoints(ctx)
+}
+
+// serveTestExporter serves the /metrics endpoint of the collectors using ctx
+func serveTestExporter(t *testing.T, ctx context.Context) *httptest.Server {
+	exporter := httptest.NewServer(api.MetricsHandler(api.NewGatherer(ctx, NewCollectors(ctx))))
+	t.Cleanup(exporter.Close)
+	return exporter
+}
+
+// getMetrics returns the body of a /metrics request, it doesn't fail the
+// test so it can be called from other goroutines
+func getMetrics(exporter *httptest.Server) (string, error) {
+	resp, err := http.Get(exporter.URL + "/metrics")
+	if err != nil {
+		return "", fmt.Errorf("failed to scrape exporter: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("unexpected status code scraping exporter: %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read exporter response: %v", err)
+	}
+	return string(b), nil
+}
+
+// scrapeExporter returns the body of a /metrics request to an exporter
+// polling the given slurmrestd url
+func scrapeExporter(t *testing.T, url string, user string, token string, schema types.MetricSchema) string {
+	got, err := getMetrics(newTestExporter(t, url, user, token, schema, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func compareGolden(t *testing.T, name string, got string) {
+	golden := util.GetTestDataFilePath(fmt.Sprintf("golden/%s_%s.prom", name, api.NewDiagData().ApiVersion))
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+	}
+	if got != string(want) {
+		t.Fatalf("metrics do not match %s, run with -update if this is expected\ngot:\n%s", golden, got)
+	}
+}
+
+func TestMetricsEndToEnd(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+
+	compareGolden(t, "metrics_v1", scrapeExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV1))
+	compareGolden(t, "metrics_v2", scrapeExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV2))
+}
+
+func TestMetricsEndToEndUnix(t *testing.T) {
+	s, err := fakeslurm.NewUnixServer()
+	if err != nil {
+		t.Fatalf("failed to start unix server: %v", err)
+	}
+	defer s.Close()
+
+	compareGolden(t, "metrics_v1", scrapeExporter(t, s.URL, "", "", types.MetricSchemaV1))
+}
+
+// A failing endpoint only drops the metrics that depend on it
+func TestMetricsEndToEndEndpointFailure(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	s.SetFault("diag", fakeslurm.Fault{StatusCode: 500})
+
+	got := scrapeExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV1)
+	if strings.Contains(got, "\nslurm_scheduler_threads ") {
+		t.Fatalf("expected slurm_scheduler_threads to be missing when diag fails")
+	}
+	if !strings.Contains(got, "\nslurm_cpus_total ") {
+		t.Fatalf("expected slurm_cpus_total to be present when diag fails")
+	}
+}
+
+// Overlapping scrapes share one poll, and one finishing never wipes the data
+// the others are still collecting from
+func TestMetricsConcurrentScrapes(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	// slow the poll down so the scrapes overlap
+	s.SetFault("jobs", fakeslurm.Fault{Delay: 100 * time.Millisecond})
+	want := scrapeExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV1)
+	exporter := newTestExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV1, 0)
+	polls := s.Requests("jobs")
+
+	const scrapes = 20
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			got, err := getMetrics(exporter)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got != want {
+				t.Errorf("expected every concurrent scrape to get the full metrics, got:\n%s", got)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := s.Requests("jobs") - polls; n == 0 || n >= scrapes {
+		t.Fatalf("expected the concurrent scrapes to share polls, got %d polls for %d scrapes", n, scrapes)
+	}
+}
+
+// Scrapes within the minimum refresh interval reuse the last poll
+func TestMetricsMinRefreshInterval(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	exporter := newTestExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV1, time.Hour)
+
+	first, err := getMetrics(exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getMetrics(exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || !strings.Contains(second, "\nslurm_cpus_total ") {
+		t.Fatalf("expected the second scrape to report the same metrics")
+	}
+	if n := s.Requests("jobs"); n != 1 {
+		t.Fatalf("expected 1 poll within the minimum refresh interval, got %d", n)
+	}
+}
+
+func TestMetricsDecodeErrors(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	body, err := fakeslurm.MutatedFixture("jobs", "jobs", func(template func() map[string]any) []map[string]any {
+		unknown := template()
+		unknown["job_state"] = []string{"HIBERNATING"}
+		unnamed := template()
+		delete(unnamed, "job_id")
+		return []map[string]any{unknown, unnamed, template()}
+	})
+	if err != nil {
+		t.Fatalf("failed to mutate jobs fixture: %v", err)
+	}
+	s.SetBody("jobs", body)
+	exporter := newTestExporter(t, s.URL, "slurm", "secret", types.MetricSchemaV2, 0)
+
+	for _, want := range []string{"1", "2"} {
+		got, err := getMetrics(exporter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the counts add up over the polls
+		for _, series := range []string{
+			`slurm_exporter_decode_errors_total{endpoint="jobs",field="job_id"} ` + want,
+			`slurm_exporter_decode_errors_total{endpoint="jobs",field="job_state"} ` + want,
+			`slurm_queue_jobs{state="unknown"} 1`,
+		} {
+			if !strings.Contains(got, "\n"+series+"\n") {
+				t.Fatalf("expected %s in the metrics, got:\n%s", series, got)
+			}
+		}
+	}
+}
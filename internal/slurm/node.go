@@ -2,10 +2,8 @@ package slurm
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,12 +11,19 @@ import (
 
 type NodeCollector struct {
 	ctx      context.Context
+	schema   types.MetricSchema
+	cpus     *prometheus.Desc
+	cpusTot  *prometheus.Desc
+	memBytes *prometheus.Desc
+	memTot   *prometheus.Desc
 	cpuAlloc *prometheus.Desc
 	cpuIdle  *prometheus.Desc
 	cpuOther *prometheus.Desc
 	cpuTotal *prometheus.Desc
 	memAlloc *prometheus.Desc
 	memTotal *prometheus.Desc
+	filter   *types.SeriesFilter
+	dropped  *prometheus.Desc
 }
 
 // NewNodeCollectorOld creates a Prometheus collector to keep all our stats in
@@ -28,35 +33,56 @@ func NewNodeCollector(ctx context.Context) *NodeCollector {
 
 	return &NodeCollector{
 		ctx:      ctx,
-		cpuAlloc: prometheus.NewDesc("slurm_node_cpu_alloc", "Allocated CPUs per node", labels, nil),
-		cpuIdle:  prometheus.NewDesc("slurm_node_cpu_idle", "Idle CPUs per node", labels, nil),
-		cpuOther: prometheus.NewDesc("slurm_node_cpu_other", "Other CPUs per node", labels, nil),
-		cpuTotal: prometheus.NewDesc("slurm_node_cpu_total", "Total CPUs per node", labels, nil),
-		memAlloc: prometheus.NewDesc("slurm_node_mem_alloc", "Allocated memory per node", labels, nil),
-		memTotal: prometheus.NewDesc("slurm_node_mem_total", "Total memory per node", labels, nil),
+		schema:   metricSchema(ctx),
+		cpus:     newDesc(ctx, "slurm_node_cpus", "CPUs per node by state", []string{"node", "status", "state"}, nil),
+		cpusTot:  newDesc(ctx, "slurm_node_cpus_total", "Total CPUs per node", labels, nil),
+		memBytes: newDesc(ctx, "slurm_node_memory_alloc_bytes", "Allocated memory per node in bytes", labels, nil),
+		memTot:   newDesc(ctx, "slurm_node_memory_total_bytes", "Total memory per node in bytes", labels, nil),
+		cpuAlloc: newDesc(ctx, "slurm_node_cpu_alloc", "Allocated CPUs per node", labels, nil),
+		cpuIdle:  newDesc(ctx, "slurm_node_cpu_idle", "Idle CPUs per node", labels, nil),
+		cpuOther: newDesc(ctx, "slurm_node_cpu_other", "Other CPUs per node", labels, nil),
+		cpuTotal: newDesc(ctx, "slurm_node_cpu_total", "Total CPUs per node", labels, nil),
+		memAlloc: newDesc(ctx, "slurm_node_mem_alloc", "Allocated memory per node", labels, nil),
+		memTotal: newDesc(ctx, "slurm_node_mem_total", "Total memory per node", labels, nil),
+		filter:   seriesFilter(ctx, "nodes"),
+		dropped:  newDroppedDesc(ctx, "nodes"),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (nc *NodeCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *nc
+	c.ctx = ctx
+	return &c
+}
+
 // Send all metric descriptions
 func (nc *NodeCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- nc.cpuAlloc
-	ch <- nc.cpuIdle
-	ch <- nc.cpuOther
-	ch <- nc.cpuTotal
-	ch <- nc.memAlloc
-	ch <- nc.memTotal
+	if nc.schema.Legacy() {
+		ch <- nc.cpuAlloc
+		ch <- nc.cpuIdle
+		ch <- nc.cpuOther
+		ch <- nc.cpuTotal
+		ch <- nc.memAlloc
+		ch <- nc.memTotal
+	}
+	if nc.schema.V2() {
+		ch <- nc.cpus
+		ch <- nc.cpusTot
+		ch <- nc.memBytes
+		ch <- nc.memTot
+	}
+	if nc.filter != nil {
+		ch <- nc.dropped
+	}
 }
 
 func (nc *NodeCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := nc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	nodesRespBytes, found := apiCache.Get("nodes")
-	if !found {
-		slog.Error("failed to get nodes response for cpu metrics from cache")
-		return
-	}
-	nodesData, err := api.ProcessNodesResponse(nodesRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process nodes response for node metrics", "error", err)
+	snapshot := api.CurrentSnapshot(nc.ctx)
+	nodesData := snapshot.Nodes
+	if nodesData == nil {
+		slog.Error("failed to get nodes data for node metrics", "error", snapshot.Err("nodes"))
 		return
 	}
 	nm, err := ParseNodeMetrics(nodesData)
@@ -64,16 +90,41 @@ func (nc *NodeCollector) Collect(ch chan<- prometheus.Metric) {
 		slog.Error("failed to collect nodes metrics", "error", err)
 		return
 	}
+	if nc.filter != nil {
+		var dropped droppedValues[nodeMetrics]
+		nm, dropped = filterSeries(nc.filter, nm, (*nodeMetrics).weight, (*nodeMetrics).add)
+		dropped.collect(ch, nc.dropped, nc.collectNode)
+	}
 	for node := range nm {
-		ch <- prometheus.MustNewConstMetric(nc.cpuAlloc, prometheus.GaugeValue, float64(nm[node].cpuAlloc), node, nm[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(nc.cpuIdle, prometheus.GaugeValue, float64(nm[node].cpuIdle), node, nm[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(nc.cpuOther, prometheus.GaugeValue, float64(nm[node].cpuOther), node, nm[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(nc.cpuTotal, prometheus.GaugeValue, float64(nm[node].cpuTotal), node, nm[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(nc.memAlloc, prometheus.GaugeValue, float64(nm[node].memAlloc), node, nm[node].nodeStatus)
-		ch <- prometheus.MustNewConstMetric(nc.memTotal, prometheus.GaugeValue, float64(nm[node].memTotal), node, nm[node].nodeStatus)
+		nc.collectNode(ch, node, nm[node])
 	}
 }
 
+// collectNode sends the series of a node
+func (nc *NodeCollector) collectNode(ch chan<- prometheus.Metric, node string, m *nodeMetrics) {
+	if nc.schema.V2() {
+		status := m.nodeStatus
+		ch <- prometheus.MustNewConstMetric(nc.cpus, prometheus.GaugeValue, float64(m.cpuAlloc), node, status, "alloc")
+		ch <- prometheus.MustNewConstMetric(nc.cpus, prometheus.GaugeValue, float64(m.cpuIdle), node, status, "idle")
+		ch <- prometheus.MustNewConstMetric(nc.cpus, prometheus.GaugeValue, float64(m.cpuOther), node, status, "other")
+		ch <- prometheus.MustNewConstMetric(nc.cpusTot, prometheus.GaugeValue, float64(m.cpuTotal), node, status)
+		// slurm reports memory in megabytes
+		ch <- prometheus.MustNewConstMetric(nc.memBytes, prometheus.GaugeValue, float64(m.memAlloc)*1024*1024, node, status)
+		ch <- prometheus.MustNewConstMetric(nc.memTot, prometheus.GaugeValue, float64(m.memTotal)*1024*1024, node, status)
+	}
+	if !nc.schema.Legacy() {
+		return
+	}
+	// the v1 metrics keep the state and cpus slurm reports for the node
+	status := m.legacyStatus
+	ch <- prometheus.MustNewConstMetric(nc.cpuAlloc, prometheus.GaugeValue, float64(m.legacyCpuAlloc), node, status)
+	ch <- prometheus.MustNewConstMetric(nc.cpuIdle, prometheus.GaugeValue, float64(m.legacyCpuIdle), node, status)
+	ch <- prometheus.MustNewConstMetric(nc.cpuOther, prometheus.GaugeValue, 0, node, status)
+	ch <- prometheus.MustNewConstMetric(nc.cpuTotal, prometheus.GaugeValue, float64(m.cpuTotal), node, status)
+	ch <- prometheus.MustNewConstMetric(nc.memAlloc, prometheus.GaugeValue, float64(m.memAlloc), node, status)
+	ch <- prometheus.MustNewConstMetric(nc.memTotal, prometheus.GaugeValue, float64(m.memTotal), node, status)
+}
+
 // NodeMetrics stores metrics for each node
 type nodeMetrics struct {
 	memAlloc   uint64
@@ -83,6 +134,30 @@ type nodeMetrics struct {
 	cpuOther   uint64
 	cpuTotal   uint64
 	nodeStatus string
+	// the v1 metrics label the node with its states joined by |, and
+	// report the allocated and idle cpus as slurm does
+	legacyStatus   string
+	legacyCpuAlloc uint64
+	legacyCpuIdle  uint64
+}
+
+// weight orders nodes when folding them into other. The largest nodes are
+// kept, rather than the busiest, so the same nodes are kept between scrapes.
+func (m *nodeMetrics) weight() float64 {
+	return float64(m.cpuTotal)
+}
+
+// add sums the resources of the nodes, the status of the folded nodes is left
+// empty as they don't share one
+func (m *nodeMetrics) add(o *nodeMetrics) {
+	m.memAlloc += o.memAlloc
+	m.memTotal += o.memTotal
+	m.cpuAlloc += o.cpuAlloc
+	m.cpuIdle += o.cpuIdle
+	m.cpuOther += o.cpuOther
+	m.cpuTotal += o.cpuTotal
+	m.legacyCpuAlloc += o.legacyCpuAlloc
+	m.legacyCpuIdle += o.legacyCpuIdle
 }
 
 func NewNodeMetrics() *nodeMetrics {
@@ -96,24 +171,24 @@ func ParseNodeMetrics(nodesData *api.NodesData) (map[string]*nodeMetrics, error)
 
 	for _, n := range nodesData.Nodes {
 		nodeName := n.Hostname
-		nodeMap[nodeName] = &nodeMetrics{0, 0, 0, 0, 0, 0, ""}
+		nodeMap[nodeName] = &nodeMetrics{}
 
-		// state
-		nodeStatesStr, err := n.GetNodeStatesString("|")
-		if err != nil {
-			return nil, fmt.Errorf("failed to get node state: %v", err)
-		}
-		nodeMap[nodeName].nodeStatus = nodeStatesStr
+		// state, as sinfo shows it
+		nodeMap[nodeName].nodeStatus = n.State
+		nodeMap[nodeName].legacyStatus = n.LegacyState
 
 		// memory
 		nodeMap[nodeName].memAlloc = uint64(n.AllocMemory)
 		nodeMap[nodeName].memTotal = uint64(n.RealMemory)
 
-		// cpu
-		nodeMap[nodeName].cpuAlloc = uint64(n.AllocCpus)
-		nodeMap[nodeName].cpuIdle = uint64(n.AllocIdleCpus)
-		nodeMap[nodeName].cpuOther = uint64(n.OtherCpus)
+		// cpu, split the same way as the cluster and partition cpus
+		alloc, idle, other := n.CPUStates()
+		nodeMap[nodeName].cpuAlloc = uint64(alloc)
+		nodeMap[nodeName].cpuIdle = uint64(idle)
+		nodeMap[nodeName].cpuOther = uint64(other)
 		nodeMap[nodeName].cpuTotal = uint64(n.Cpus)
+		nodeMap[nodeName].legacyCpuAlloc = uint64(n.AllocCpus)
+		nodeMap[nodeName].legacyCpuIdle = uint64(n.AllocIdleCpus)
 	}
 
 	return nodeMap, nil
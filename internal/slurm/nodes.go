@@ -3,8 +3,8 @@ package slurm
 import (
 	"context"
 	"log/slog"
+	"strings"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,6 +12,8 @@ import (
 
 type NodesCollector struct {
 	ctx    context.Context
+	schema types.MetricSchema
+	nodes  *prometheus.Desc
 	alloc  *prometheus.Desc
 	comp   *prometheus.Desc
 	down   *prometheus.Desc
@@ -28,44 +30,54 @@ type NodesCollector struct {
 func NewNodesCollector(ctx context.Context) *NodesCollector {
 	return &NodesCollector{
 		ctx:    ctx,
-		alloc:  prometheus.NewDesc("slurm_nodes_alloc", "Allocated nodes", nil, nil),
-		comp:   prometheus.NewDesc("slurm_nodes_comp", "Completing nodes", nil, nil),
-		down:   prometheus.NewDesc("slurm_nodes_down", "Down nodes", nil, nil),
-		drain:  prometheus.NewDesc("slurm_nodes_drain", "Drain nodes", nil, nil),
-		err:    prometheus.NewDesc("slurm_nodes_err", "Error nodes", nil, nil),
-		fail:   prometheus.NewDesc("slurm_nodes_fail", "Fail nodes", nil, nil),
-		idle:   prometheus.NewDesc("slurm_nodes_idle", "Idle nodes", nil, nil),
-		maint:  prometheus.NewDesc("slurm_nodes_maint", "Maint nodes", nil, nil),
-		mix:    prometheus.NewDesc("slurm_nodes_mix", "Mix nodes", nil, nil),
-		resv:   prometheus.NewDesc("slurm_nodes_resv", "Reserved nodes", nil, nil),
-		reboot: prometheus.NewDesc("slurm_nodes_reboot", "Reboot nodes", nil, nil),
+		schema: metricSchema(ctx),
+		nodes:  newDesc(ctx, "slurm_nodes", "Nodes by state, as sinfo shows it", []string{"state"}, nil),
+		alloc:  newDesc(ctx, "slurm_nodes_alloc", "Allocated nodes", nil, nil),
+		comp:   newDesc(ctx, "slurm_nodes_comp", "Completing nodes", nil, nil),
+		down:   newDesc(ctx, "slurm_nodes_down", "Down nodes", nil, nil),
+		drain:  newDesc(ctx, "slurm_nodes_drain", "Drain nodes", nil, nil),
+		err:    newDesc(ctx, "slurm_nodes_err", "Error nodes", nil, nil),
+		fail:   newDesc(ctx, "slurm_nodes_fail", "Fail nodes", nil, nil),
+		idle:   newDesc(ctx, "slurm_nodes_idle", "Idle nodes", nil, nil),
+		maint:  newDesc(ctx, "slurm_nodes_maint", "Maint nodes", nil, nil),
+		mix:    newDesc(ctx, "slurm_nodes_mix", "Mix nodes", nil, nil),
+		resv:   newDesc(ctx, "slurm_nodes_resv", "Reserved nodes", nil, nil),
+		reboot: newDesc(ctx, "slurm_nodes_reboot", "Reboot nodes", nil, nil),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (nc *NodesCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *nc
+	c.ctx = ctx
+	return &c
+}
+
 func (nc *NodesCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- nc.alloc
-	ch <- nc.comp
-	ch <- nc.down
-	ch <- nc.drain
-	ch <- nc.err
-	ch <- nc.fail
-	ch <- nc.idle
-	ch <- nc.maint
-	ch <- nc.mix
-	ch <- nc.resv
-	ch <- nc.reboot
+	if nc.schema.Legacy() {
+		ch <- nc.alloc
+		ch <- nc.comp
+		ch <- nc.down
+		ch <- nc.drain
+		ch <- nc.err
+		ch <- nc.fail
+		ch <- nc.idle
+		ch <- nc.maint
+		ch <- nc.mix
+		ch <- nc.resv
+		ch <- nc.reboot
+	}
+	if nc.schema.V2() {
+		ch <- nc.nodes
+	}
 }
 
 func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := nc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	nodesRespBytes, found := apiCache.Get("nodes")
-	if !found {
-		slog.Error("failed to get nodes response for cpu metrics from cache")
-		return
-	}
-	nodesData, err := api.ProcessNodesResponse(nodesRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process nodes response for nodes metrics", "error", err)
+	snapshot := api.CurrentSnapshot(nc.ctx)
+	nodesData := snapshot.Nodes
+	if nodesData == nil {
+		slog.Error("failed to get nodes data for nodes metrics", "error", snapshot.Err("nodes"))
 		return
 	}
 	nm, err := ParseNodesMetrics(nodesData)
@@ -73,20 +85,54 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
 		slog.Error("failed to collect nodes metrics", "error", err)
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(nc.alloc, prometheus.GaugeValue, nm.alloc)
-	ch <- prometheus.MustNewConstMetric(nc.comp, prometheus.GaugeValue, nm.comp)
-	ch <- prometheus.MustNewConstMetric(nc.down, prometheus.GaugeValue, nm.down)
-	ch <- prometheus.MustNewConstMetric(nc.drain, prometheus.GaugeValue, nm.drain)
-	ch <- prometheus.MustNewConstMetric(nc.err, prometheus.GaugeValue, nm.err)
-	ch <- prometheus.MustNewConstMetric(nc.fail, prometheus.GaugeValue, nm.fail)
-	ch <- prometheus.MustNewConstMetric(nc.idle, prometheus.GaugeValue, nm.idle)
-	ch <- prometheus.MustNewConstMetric(nc.maint, prometheus.GaugeValue, nm.maint)
-	ch <- prometheus.MustNewConstMetric(nc.mix, prometheus.GaugeValue, nm.mix)
-	ch <- prometheus.MustNewConstMetric(nc.resv, prometheus.GaugeValue, nm.resv)
-	ch <- prometheus.MustNewConstMetric(nc.reboot, prometheus.GaugeValue, nm.reboot)
+	if nc.schema.Legacy() {
+		ch <- prometheus.MustNewConstMetric(nc.alloc, prometheus.GaugeValue, nm.alloc)
+		ch <- prometheus.MustNewConstMetric(nc.comp, prometheus.GaugeValue, nm.comp)
+		ch <- prometheus.MustNewConstMetric(nc.down, prometheus.GaugeValue, nm.down)
+		ch <- prometheus.MustNewConstMetric(nc.drain, prometheus.GaugeValue, nm.drain)
+		ch <- prometheus.MustNewConstMetric(nc.err, prometheus.GaugeValue, nm.err)
+		ch <- prometheus.MustNewConstMetric(nc.fail, prometheus.GaugeValue, nm.fail)
+		ch <- prometheus.MustNewConstMetric(nc.idle, prometheus.GaugeValue, nm.idle)
+		ch <- prometheus.MustNewConstMetric(nc.maint, prometheus.GaugeValue, nm.maint)
+		ch <- prometheus.MustNewConstMetric(nc.mix, prometheus.GaugeValue, nm.mix)
+		ch <- prometheus.MustNewConstMetric(nc.resv, prometheus.GaugeValue, nm.resv)
+		ch <- prometheus.MustNewConstMetric(nc.reboot, prometheus.GaugeValue, nm.reboot)
+	}
+	if nc.schema.V2() {
+		for state, count := range nm.states {
+			ch <- prometheus.MustNewConstMetric(nc.nodes, prometheus.GaugeValue, count, state)
+		}
+	}
+}
+
+// nodeStates are the states sinfo shows for a node, without the suffixes
+// for flags like ~ for powered down. They are always exported so the series
+// don't come and go, states with a suffix are only exported when a node is
+// in them.
+var nodeStates = []string{
+	"allocated",
+	"completing",
+	"down",
+	"drained",
+	"draining",
+	"error",
+	"fail",
+	"failing",
+	"future",
+	"idle",
+	"inval",
+	"maint",
+	"mixed",
+	"planned",
+	"reboot",
+	"reserved",
+	"unknown",
 }
 
 type nodesMetrics struct {
+	// states counts the nodes by the state sinfo shows, every node is in
+	// exactly one state so they add up to the number of nodes
+	states map[string]float64
 	alloc  float64
 	comp   float64
 	down   float64
@@ -101,44 +147,46 @@ type nodesMetrics struct {
 }
 
 func NewNodesMetrics() *nodesMetrics {
-	return &nodesMetrics{}
+	nm := &nodesMetrics{states: make(map[string]float64, len(nodeStates))}
+	for _, state := range nodeStates {
+		nm.states[state] = 0
+	}
+	return nm
 }
 
 // ParseNodesMetrics iterates through node response objects and tallies up
-// nodes based on their state
+// nodes based on their state. Each node is counted once, by the state sinfo
+// shows for it, so a drained idle node is drained and not idle.
 func ParseNodesMetrics(nodesData *api.NodesData) (*nodesMetrics, error) {
 	nm := NewNodesMetrics()
 
 	for _, n := range nodesData.Nodes {
-		for _, ns := range n.States {
-			switch ns {
-			case types.NodeStateAlloc:
-				nm.alloc += 1
-			case types.NodeStateComp:
-				nm.comp += 1
-			case types.NodeStateDown:
-				nm.down += 1
-			case types.NodeStateDrain:
-				nm.drain += 1
-			case types.NodeStateErr:
-				nm.err += 1
-			case types.NodeStateFail:
-				nm.fail += 1
-			case types.NodeStateIdle:
-				nm.idle += 1
-			case types.NodeStateMaint:
-				nm.maint += 1
-			case types.NodeStateMix:
-				nm.mix += 1
-			case types.NodeStateResv:
-				nm.resv += 1
-			case types.NodeStateReboot:
-				nm.reboot += 1
-			case types.NodeStateRebootIssued:
-				nm.reboot += 1
-			case types.NodeStateRebootCancel:
-				nm.reboot += 1
-			}
+		nm.states[n.State]++
+		// the v1 metrics are the sinfo states without the suffix, as
+		// matched by the original exporter
+		switch strings.TrimRight(n.State, "$@^#%~!*") {
+		case "allocated":
+			nm.alloc += 1
+		case "completing":
+			nm.comp += 1
+		case "down":
+			nm.down += 1
+		case "drained", "draining":
+			nm.drain += 1
+		case "error":
+			nm.err += 1
+		case "fail", "failing":
+			nm.fail += 1
+		case "idle":
+			nm.idle += 1
+		case "maint":
+			nm.maint += 1
+		case "mixed":
+			nm.mix += 1
+		case "reserved":
+			nm.resv += 1
+		case "reboot":
+			nm.reboot += 1
 		}
 	}
 
@@ -0,0 +1,46 @@
+package slurm
+
+import (
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+)
+
+func TestParseNodesMetricsCountsEachNodeOnce(t *testing.T) {
+	nodesData := api.NewNodesData()
+	for i, states := range [][]string{
+		{"IDLE", "DRAIN"},
+		{"MIXED", "COMPLETING"},
+		{"MIXED", "DRAIN"},
+		{"DOWN", "NOT_RESPONDING", "POWERED_DOWN"},
+		{"IDLE", "POWERED_DOWN"},
+		{"IDLE"},
+		{"ALLOCATED"},
+		{"FUTURE"},
+	} {
+		n := api.NodeData{Name: string(rune('a' + i))}
+		n.SetNodeStates(states)
+		nodesData.Nodes = append(nodesData.Nodes, n)
+	}
+	nm, err := ParseNodesMetrics(nodesData)
+	if err != nil {
+		t.Fatalf("failed to parse nodes metrics: %v", err)
+	}
+
+	var total float64
+	for _, count := range nm.states {
+		total += count
+	}
+	if total != float64(len(nodesData.Nodes)) {
+		t.Fatalf("expected the states to add up to %d nodes, got %v", len(nodesData.Nodes), nm.states)
+	}
+	for state, want := range map[string]float64{"drained": 1, "draining": 1, "completing": 1, "down~": 1, "idle~": 1, "idle": 1, "allocated": 1, "future": 1} {
+		if nm.states[state] != want {
+			t.Errorf("expected %v %s nodes, got %v", want, state, nm.states[state])
+		}
+	}
+	// a drained idle node is no longer counted as idle too
+	if nm.idle != 2 || nm.drain != 2 || nm.comp != 1 || nm.down != 1 {
+		t.Errorf("unexpected v1 counts: %+v", *nm)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"log/slog"
 	"strings"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,63 +12,85 @@ import (
 
 type PartitionsCollector struct {
 	ctx       context.Context
+	schema    types.MetricSchema
+	cpus      *prometheus.Desc
 	allocated *prometheus.Desc
 	idle      *prometheus.Desc
 	other     *prometheus.Desc
+	jobs      *prometheus.Desc
 	pending   *prometheus.Desc
 	total     *prometheus.Desc
+	jobCpus   *prometheus.Desc
+	jobGpus   *prometheus.Desc
+	jobMemory *prometheus.Desc
+	filter    *types.SeriesFilter
+	dropped   *prometheus.Desc
 }
 
 func NewPartitionsCollector(ctx context.Context) *PartitionsCollector {
 	labels := []string{"partition"}
+	stateLabels := []string{"partition", "state"}
 	return &PartitionsCollector{
 		ctx:       ctx,
-		allocated: prometheus.NewDesc("slurm_partition_cpus_allocated", "Allocated CPUs for partition", labels, nil),
-		idle:      prometheus.NewDesc("slurm_partition_cpus_idle", "Idle CPUs for partition", labels, nil),
-		other:     prometheus.NewDesc("slurm_partition_cpus_other", "Other CPUs for partition", labels, nil),
-		pending:   prometheus.NewDesc("slurm_partition_jobs_pending", "Pending jobs for partition", labels, nil),
-		total:     prometheus.NewDesc("slurm_partition_cpus_total", "Total CPUs for partition", labels, nil),
+		schema:    metricSchema(ctx),
+		cpus:      newDesc(ctx, "slurm_partition_cpus", "CPUs for partition by state", stateLabels, nil),
+		allocated: newDesc(ctx, "slurm_partition_cpus_allocated", "Allocated CPUs for partition", labels, nil),
+		idle:      newDesc(ctx, "slurm_partition_cpus_idle", "Idle CPUs for partition", labels, nil),
+		other:     newDesc(ctx, "slurm_partition_cpus_other", "Other CPUs for partition", labels, nil),
+		jobs:      newDesc(ctx, "slurm_partition_jobs", "Jobs for partition by state", stateLabels, nil),
+		pending:   newDesc(ctx, "slurm_partition_jobs_pending", "Pending jobs for partition", labels, nil),
+		total:     newDesc(ctx, "slurm_partition_cpus_total", "Total CPUs for partition", labels, nil),
+		jobCpus:   newDesc(ctx, "slurm_partition_job_cpus", "CPUs of the pending and running jobs for partition", stateLabels, nil),
+		jobGpus:   newDesc(ctx, "slurm_partition_job_gpus", "GPUs of the pending and running jobs for partition", stateLabels, nil),
+		jobMemory: newDesc(ctx, "slurm_partition_job_memory_bytes", "Memory of the pending and running jobs for partition in bytes", stateLabels, nil),
+		filter:    seriesFilter(ctx, "partitions"),
+		dropped:   newDroppedDesc(ctx, "partitions"),
 	}
 }
 
-func (pc *PartitionsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- pc.allocated
-	ch <- pc.idle
-	ch <- pc.other
-	ch <- pc.pending
-	ch <- pc.total
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (pc *PartitionsCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *pc
+	c.ctx = ctx
+	return &c
 }
 
-func (pc *PartitionsCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := pc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	partitionsRespBytes, found := apiCache.Get("partitions")
-	if !found {
-		slog.Error("failed to get partitions response for partitions metrics from cache")
-		return
+func (pc *PartitionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	if pc.schema.Legacy() {
+		ch <- pc.allocated
+		ch <- pc.idle
+		ch <- pc.other
+		ch <- pc.pending
 	}
-	jobsRespBytes, found := apiCache.Get("jobs")
-	if !found {
-		slog.Error("failed to get jobs response for users metrics from cache")
-		return
+	if pc.schema.V2() {
+		ch <- pc.cpus
+		ch <- pc.jobs
 	}
-	nodesRespBytes, found := apiCache.Get("nodes")
-	if !found {
-		slog.Error("failed to get nodes response for cpu metrics from cache")
-		return
+	ch <- pc.total
+	ch <- pc.jobCpus
+	ch <- pc.jobGpus
+	ch <- pc.jobMemory
+	if pc.filter != nil {
+		ch <- pc.dropped
 	}
-	partitionsData, err := api.ProcessPartitionsResponse(partitionsRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process partitions data for partitions metrics", "error", err)
+}
+
+func (pc *PartitionsCollector) Collect(ch chan<- prometheus.Metric) {
+	snapshot := api.CurrentSnapshot(pc.ctx)
+	partitionsData := snapshot.Partitions
+	if partitionsData == nil {
+		slog.Error("failed to get partitions data for partitions metrics", "error", snapshot.Err("partitions"))
 		return
 	}
-	jobsData, err := api.ProcessJobsResponse(jobsRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process jobs data for partitions metrics", "error", err)
+	jobsData := snapshot.Jobs
+	if jobsData == nil {
+		slog.Error("failed to get jobs data for partitions metrics", "error", snapshot.Err("jobs"))
 		return
 	}
-	nodesData, err := api.ProcessNodesResponse(nodesRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process nodes data for partitions metrics", "error", err)
+	nodesData := snapshot.Nodes
+	if nodesData == nil {
+		slog.Error("failed to get nodes data for partitions metrics", "error", snapshot.Err("nodes"))
 		return
 	}
 	pm, err := ParsePartitionsMetrics(partitionsData, jobsData, nodesData)
@@ -77,27 +98,49 @@ func (pc *PartitionsCollector) Collect(ch chan<- prometheus.Metric) {
 		slog.Error("failed to collect partitions metrics", "error", err)
 		return
 	}
+	if pc.filter != nil {
+		var dropped droppedValues[partitionMetrics]
+		pm, dropped = filterSeries(pc.filter, pm, (*partitionMetrics).weight, (*partitionMetrics).add)
+		dropped.collect(ch, pc.dropped, pc.collectPartition)
+	}
 	for p := range pm {
-		if pm[p].cpus_allocated > 0 {
-			ch <- prometheus.MustNewConstMetric(pc.allocated, prometheus.GaugeValue, pm[p].cpus_allocated, p)
+		pc.collectPartition(ch, p, pm[p])
+	}
+}
+
+// collectPartition sends the series of a partition
+func (pc *PartitionsCollector) collectPartition(ch chan<- prometheus.Metric, p string, m *partitionMetrics) {
+	if pc.schema.V2() {
+		ch <- prometheus.MustNewConstMetric(pc.cpus, prometheus.GaugeValue, m.cpus_allocated, p, "alloc")
+		ch <- prometheus.MustNewConstMetric(pc.cpus, prometheus.GaugeValue, m.cpus_idle, p, "idle")
+		ch <- prometheus.MustNewConstMetric(pc.cpus, prometheus.GaugeValue, m.cpus_other, p, "other")
+		for _, state := range types.JobStates {
+			ch <- prometheus.MustNewConstMetric(pc.jobs, prometheus.GaugeValue, m.jobs[state], p, string(state))
 		}
-		if pm[p].cpus_idle > 0 {
-			ch <- prometheus.MustNewConstMetric(pc.idle, prometheus.GaugeValue, pm[p].cpus_idle, p)
+	}
+	if pc.schema.Legacy() {
+		if m.cpus_allocated > 0 {
+			ch <- prometheus.MustNewConstMetric(pc.allocated, prometheus.GaugeValue, m.cpus_allocated, p)
 		}
-		if pm[p].cpus_other > 0 {
-			ch <- prometheus.MustNewConstMetric(pc.other, prometheus.GaugeValue, pm[p].cpus_other, p)
+		if m.cpus_idle > 0 {
+			ch <- prometheus.MustNewConstMetric(pc.idle, prometheus.GaugeValue, m.cpus_idle, p)
 		}
-		if pm[p].cpus_total > 0 {
-			ch <- prometheus.MustNewConstMetric(pc.total, prometheus.GaugeValue, pm[p].cpus_total, p)
+		if m.cpus_other > 0 {
+			ch <- prometheus.MustNewConstMetric(pc.other, prometheus.GaugeValue, m.cpus_other, p)
 		}
-		if pm[p].jobs_pending > 0 {
-			ch <- prometheus.MustNewConstMetric(pc.pending, prometheus.GaugeValue, pm[p].jobs_pending, p)
+		if pending := m.jobs[types.JobStatePending]; pending > 0 {
+			ch <- prometheus.MustNewConstMetric(pc.pending, prometheus.GaugeValue, pending, p)
 		}
 	}
+	if m.cpus_total > 0 {
+		ch <- prometheus.MustNewConstMetric(pc.total, prometheus.GaugeValue, m.cpus_total, p)
+	}
+	m.pending_demand.collect(ch, pc.jobCpus, pc.jobGpus, pc.jobMemory, p, "pending")
+	m.running_demand.collect(ch, pc.jobCpus, pc.jobGpus, pc.jobMemory, p, "running")
 }
 
 func NewPartitionsMetrics() *partitionMetrics {
-	return &partitionMetrics{0, 0, 0, 0, 0}
+	return &partitionMetrics{jobs: make(map[types.JobState]float64)}
 }
 
 type partitionMetrics struct {
@@ -105,40 +148,51 @@ type partitionMetrics struct {
 	cpus_idle      float64
 	cpus_other     float64
 	cpus_total     float64
-	jobs_pending   float64
+	// jobs counts the jobs of the partition in every state. A pending job
+	// submitted to several partitions is counted in each of them, since it
+	// can start in any, so the partitions add up to more than the cluster.
+	jobs           map[types.JobState]float64
+	pending_demand jobDemand
+	running_demand jobDemand
+}
+
+// weight orders partitions when folding them into other, the largest
+// partitions are kept
+func (m *partitionMetrics) weight() float64 {
+	return m.cpus_total
+}
+
+func (m *partitionMetrics) add(o *partitionMetrics) {
+	m.cpus_allocated += o.cpus_allocated
+	m.cpus_idle += o.cpus_idle
+	m.cpus_other += o.cpus_other
+	m.cpus_total += o.cpus_total
+	if m.jobs == nil {
+		m.jobs = make(map[types.JobState]float64)
+	}
+	for state, n := range o.jobs {
+		m.jobs[state] += n
+	}
+	m.pending_demand.add(o.pending_demand)
+	m.running_demand.add(o.running_demand)
 }
 
 // ParsePartitionsMetrics returns a map where the keys are the partition names and the values are a partitionMetrics struct
 func ParsePartitionsMetrics(partitionsData *api.PartitionsData, jobsData *api.JobsData, nodesData *api.NodesData) (map[string]*partitionMetrics, error) {
 	partitions := make(map[string]*partitionMetrics)
-	nodePartitions := make(map[string][]string)
 
-	// first, scan through partition data to easily get total cpus
+	// every partition is reported, even one without nodes
 	for _, p := range partitionsData.Partitions {
-		_, exists := partitions[p.Name]
-		if !exists {
-			partitions[p.Name] = NewPartitionsMetrics()
-		}
-
-		// cpu total
-		partitions[p.Name].cpus_total = float64(p.Cpus)
-	}
-
-	// we need to gather cpus from the nodes perspective because a node can
-	// be a member of multiple partitions, running a job in one partition, and
-	// we want to see that there are allocated cpus on the other partition because
-	// of the shared node.
-	for _, n := range nodesData.Nodes {
-		nodePartitions[n.Name] = n.Partitions
+		partitions[p.Name] = NewPartitionsMetrics()
 	}
 
-	// to get used and available cpus, we need to scan through the job list and categorize
-	// each job by its partition, adding the cpus as we go
+	// the cpus are gathered from the nodes, so that alloc, idle and other add
+	// up to the total. a node can be a member of multiple partitions, running a
+	// job in one partition, and we want to see that there are allocated cpus on
+	// the other partition because of the shared node.
 	for _, n := range nodesData.Nodes {
-		alloc_cpus := n.AllocCpus
-		idle_cpus := n.AllocIdleCpus
-		nodePartitionNames := n.Partitions
-		for _, partitionName := range nodePartitionNames {
+		alloc, idle, other := n.CPUStates()
+		for _, partitionName := range n.Partitions {
 			// this needs to exist to handle the test data provided by SLURM
 			// where the nodes response example data does not correspond to
 			// the partitions response example data. in real data, the
@@ -148,19 +202,18 @@ func ParsePartitionsMetrics(partitionsData *api.PartitionsData, jobsData *api.Jo
 				partitions[partitionName] = NewPartitionsMetrics()
 			}
 
-			partitions[partitionName].cpus_allocated += float64(alloc_cpus)
-			partitions[partitionName].cpus_idle += float64(idle_cpus)
+			partitions[partitionName].cpus_allocated += float64(alloc)
+			partitions[partitionName].cpus_idle += float64(idle)
+			partitions[partitionName].cpus_other += float64(other)
+			partitions[partitionName].cpus_total += float64(n.Cpus)
 		}
 	}
 
-	// derive the other stat
-	for i, p := range partitions {
-		partitions[i].cpus_other = p.cpus_total - p.cpus_allocated - p.cpus_idle
-	}
-
-	// lastly, we need to get a count of pending jobs for the partition
+	// lastly, count the jobs and add up their resources by partition
+	counter := make(jobCounter)
 	for _, j := range jobsData.Jobs {
-		// partition name can be comma-separated, so we iterate through it
+		// a pending job can be submitted to several partitions, the
+		// partition names are comma-separated
 		pnames := strings.Split(j.Partition, ",")
 		for _, partitionName := range pnames {
 			// this needs to exist to handle the test data provided by SLURM
@@ -171,7 +224,13 @@ func ParsePartitionsMetrics(partitionsData *api.PartitionsData, jobsData *api.Jo
 			if !exists {
 				partitions[partitionName] = NewPartitionsMetrics()
 			}
-			partitions[partitionName].jobs_pending += 1
+			partitions[partitionName].jobs[j.JobState] += counter.count(j, partitionName)
+			switch j.JobState {
+			case types.JobStatePending:
+				partitions[partitionName].pending_demand.addJob(j)
+			case types.JobStateRunning:
+				partitions[partitionName].running_demand.addJob(j)
+			}
 		}
 	}
 
@@ -0,0 +1,59 @@
+package slurm
+
+import (
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestParsePartitionsMetricsJobs(t *testing.T) {
+	jobsData := api.NewJobsData()
+	for _, j := range []struct {
+		partition string
+		state     types.JobState
+		tres      string
+	}{
+		{"p1", types.JobStateRunning, "cpu=8,mem=16G,node=1,gres/gpu=2"},
+		{"p1", types.JobStatePending, "cpu=4,mem=8G,node=1"},
+		{"p1,p2", types.JobStatePending, "cpu=2,mem=512M,node=1,gres/gpu=1"},
+		{"p2", types.JobStateCompleted, "cpu=64,mem=1T,node=1"},
+	} {
+		jd := api.JobData{Partition: j.partition, JobState: j.state}
+		if err := jd.SetJobTres(&j.tres); err != nil {
+			t.Fatalf("failed to set job tres %s: %v", j.tres, err)
+		}
+		jobsData.Jobs = append(jobsData.Jobs, jd)
+	}
+	pm, err := ParsePartitionsMetrics(api.NewPartitionsData(), jobsData, api.NewNodesData())
+	if err != nil {
+		t.Fatalf("failed to parse partitions metrics: %v", err)
+	}
+
+	p1, p2 := pm["p1"], pm["p2"]
+	if p1.jobs[types.JobStatePending] != 2 || p1.jobs[types.JobStateRunning] != 1 || p1.jobs[types.JobStateCompleted] != 0 {
+		t.Errorf("unexpected jobs for p1: %v", p1.jobs)
+	}
+	if p2.jobs[types.JobStatePending] != 1 || p2.jobs[types.JobStateCompleted] != 1 {
+		t.Errorf("unexpected jobs for p2: %v", p2.jobs)
+	}
+	// the pending job submitted to both partitions is demand in each of them
+	if want := (jobDemand{cpus: 6, gpus: 1, memory: 8704}); p1.pending_demand != want {
+		t.Errorf("expected pending demand %+v for p1, got %+v", want, p1.pending_demand)
+	}
+	if want := (jobDemand{cpus: 2, gpus: 1, memory: 512}); p2.pending_demand != want {
+		t.Errorf("expected pending demand %+v for p2, got %+v", want, p2.pending_demand)
+	}
+	if want := (jobDemand{cpus: 8, gpus: 2, memory: 16384}); p1.running_demand != want {
+		t.Errorf("expected running demand %+v for p1, got %+v", want, p1.running_demand)
+	}
+
+	// but only once in the cluster
+	qm, err := ParseQueueMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse queue metrics: %v", err)
+	}
+	if want := (jobDemand{cpus: 6, gpus: 1, memory: 8704}); qm.pending_demand != want {
+		t.Errorf("expected cluster pending demand %+v, got %+v", want, qm.pending_demand)
+	}
+}
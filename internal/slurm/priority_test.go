@@ -0,0 +1,76 @@
+package slurm
+
+import (
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestParsePriorityMetrics(t *testing.T) {
+	jobsData := &api.JobsData{
+		Jobs: []api.JobData{
+			{JobId: 1, Partition: "compute", JobState: types.JobStatePending, Priority: 100},
+			{JobId: 2, Partition: "compute", JobState: types.JobStatePending, Priority: 300},
+			{JobId: 3, Partition: "compute,gpu", JobState: types.JobStatePending, Priority: 200},
+			{JobId: 4, Partition: "compute", JobState: types.JobStatePending, Priority: 400},
+			{JobId: 5, Partition: "compute", JobState: types.JobStateRunning, Priority: 9999},
+		},
+	}
+	pm, err := ParsePriorityMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse priority metrics: %v", err)
+	}
+	compute := pm["compute"]
+	if compute.pending != 4 || compute.min != 100 || compute.max != 400 || compute.median != 250 {
+		t.Fatalf("unexpected compute priorities: %+v", *compute)
+	}
+	gpu := pm["gpu"]
+	if gpu.pending != 1 || gpu.min != 200 || gpu.max != 200 || gpu.median != 200 {
+		t.Fatalf("unexpected gpu priorities: %+v", *gpu)
+	}
+}
+
+func TestParsePriorityMetricsCountsJobs(t *testing.T) {
+	jobsData := api.NewJobsData()
+	for _, j := range []struct {
+		jobId      int32
+		arrayJobId int32
+		taskString string
+		hetJobId   int32
+		offset     int32
+		priority   int64
+	}{
+		// an array with 10 pending tasks
+		{1, 1, "1-10", 0, 0, 100},
+		// a het job with two pending components
+		{2, 0, "", 2, 0, 200},
+		{3, 0, "", 2, 1, 200},
+		// a plain job
+		{4, 0, "", 0, 0, 300},
+	} {
+		jd := api.JobData{JobId: j.jobId, Partition: "compute", JobState: types.JobStatePending, Priority: j.priority}
+		if err := jd.SetJobArray(&j.arrayJobId, nil, &j.taskString, nil); err != nil {
+			t.Fatalf("failed to set job array %s: %v", j.taskString, err)
+		}
+		if err := jd.SetJobHet(&j.hetJobId, &j.offset); err != nil {
+			t.Fatalf("failed to set het job: %v", err)
+		}
+		jobsData.Jobs = append(jobsData.Jobs, jd)
+	}
+	pm, err := ParsePriorityMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse priority metrics: %v", err)
+	}
+	if compute := pm["compute"]; compute.pending != 12 || compute.min != 100 || compute.max != 300 {
+		t.Fatalf("expected 12 pending jobs from 100 to 300, got %+v", *compute)
+	}
+	// the same pending jobs as the partition
+	partitions, err := ParsePartitionsMetrics(api.NewPartitionsData(), jobsData, api.NewNodesData())
+	if err != nil {
+		t.Fatalf("failed to parse partitions metrics: %v", err)
+	}
+	if got := partitions["compute"].jobs[types.JobStatePending]; got != pm["compute"].pending {
+		t.Fatalf("expected the partition to have %v pending jobs, got %v", pm["compute"].pending, got)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,6 +11,9 @@ import (
 
 type QueueCollector struct {
 	ctx         context.Context
+	schema      types.MetricSchema
+	jobs        *prometheus.Desc
+	jobs_dep    *prometheus.Desc
 	pending     *prometheus.Desc
 	pending_dep *prometheus.Desc
 	running     *prometheus.Desc
@@ -24,51 +26,87 @@ type QueueCollector struct {
 	timeout     *prometheus.Desc
 	preempted   *prometheus.Desc
 	node_fail   *prometheus.Desc
+	cpus        *prometheus.Desc
+	gpus        *prometheus.Desc
+	memory      *prometheus.Desc
+	arrays      *prometheus.Desc
+	arrayTasks  *prometheus.Desc
+	throttled   *prometheus.Desc
+	hetJobs     *prometheus.Desc
+	hetComps    *prometheus.Desc
 }
 
 func NewQueueCollector(ctx context.Context) *QueueCollector {
 	return &QueueCollector{
 		ctx:         ctx,
-		pending:     prometheus.NewDesc("slurm_queue_pending", "Pending jobs in queue", nil, nil),
-		pending_dep: prometheus.NewDesc("slurm_queue_pending_dependency", "Pending jobs because of dependency in queue", nil, nil),
-		running:     prometheus.NewDesc("slurm_queue_running", "Running jobs in the cluster", nil, nil),
-		suspended:   prometheus.NewDesc("slurm_queue_suspended", "Suspended jobs in the cluster", nil, nil),
-		cancelled:   prometheus.NewDesc("slurm_queue_cancelled", "Cancelled jobs in the cluster", nil, nil),
-		completing:  prometheus.NewDesc("slurm_queue_completing", "Completing jobs in the cluster", nil, nil),
-		completed:   prometheus.NewDesc("slurm_queue_completed", "Completed jobs in the cluster", nil, nil),
-		configuring: prometheus.NewDesc("slurm_queue_configuring", "Configuring jobs in the cluster", nil, nil),
-		failed:      prometheus.NewDesc("slurm_queue_failed", "Number of failed jobs", nil, nil),
-		timeout:     prometheus.NewDesc("slurm_queue_timeout", "Jobs stopped by timeout", nil, nil),
-		preempted:   prometheus.NewDesc("slurm_queue_preempted", "Number of preempted jobs", nil, nil),
-		node_fail:   prometheus.NewDesc("slurm_queue_node_fail", "Number of jobs stopped due to node fail", nil, nil),
+		schema:      metricSchema(ctx),
+		jobs:        newDesc(ctx, "slurm_queue_jobs", "Jobs in the cluster by state", []string{"state"}, nil),
+		jobs_dep:    newDesc(ctx, "slurm_queue_jobs_pending_dependency", "Pending jobs waiting on a dependency", nil, nil),
+		pending:     newDesc(ctx, "slurm_queue_pending", "Pending jobs in queue", nil, nil),
+		pending_dep: newDesc(ctx, "slurm_queue_pending_dependency", "Pending jobs because of dependency in queue", nil, nil),
+		running:     newDesc(ctx, "slurm_queue_running", "Running jobs in the cluster", nil, nil),
+		suspended:   newDesc(ctx, "slurm_queue_suspended", "Suspended jobs in the cluster", nil, nil),
+		cancelled:   newDesc(ctx, "slurm_queue_cancelled", "Cancelled jobs in the cluster", nil, nil),
+		completing:  newDesc(ctx, "slurm_queue_completing", "Completing jobs in the cluster", nil, nil),
+		completed:   newDesc(ctx, "slurm_queue_completed", "Completed jobs in the cluster", nil, nil),
+		configuring: newDesc(ctx, "slurm_queue_configuring", "Configuring jobs in the cluster", nil, nil),
+		failed:      newDesc(ctx, "slurm_queue_failed", "Number of failed jobs", nil, nil),
+		timeout:     newDesc(ctx, "slurm_queue_timeout", "Jobs stopped by timeout", nil, nil),
+		preempted:   newDesc(ctx, "slurm_queue_preempted", "Number of preempted jobs", nil, nil),
+		node_fail:   newDesc(ctx, "slurm_queue_node_fail", "Number of jobs stopped due to node fail", nil, nil),
+		cpus:        newDesc(ctx, "slurm_queue_cpus", "CPUs of the pending and running jobs in the cluster", []string{"state"}, nil),
+		gpus:        newDesc(ctx, "slurm_queue_gpus", "GPUs of the pending and running jobs in the cluster", []string{"state"}, nil),
+		memory:      newDesc(ctx, "slurm_queue_memory_bytes", "Memory of the pending and running jobs in the cluster in bytes", []string{"state"}, nil),
+		arrays:      newDesc(ctx, "slurm_queue_arrays_pending", "Job arrays with pending tasks", nil, nil),
+		arrayTasks:  newDesc(ctx, "slurm_queue_array_tasks_pending", "Pending tasks of job arrays", nil, nil),
+		throttled:   newDesc(ctx, "slurm_queue_array_tasks_throttled", "Pending tasks of job arrays held back by the limit on their running tasks", nil, nil),
+		hetJobs:     newDesc(ctx, "slurm_queue_het_jobs", "Heterogeneous jobs in the cluster", nil, nil),
+		hetComps:    newDesc(ctx, "slurm_queue_het_job_components", "Components of the heterogeneous jobs in the cluster", nil, nil),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (qc *QueueCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *qc
+	c.ctx = ctx
+	return &c
+}
+
 func (qc *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- qc.pending
-	ch <- qc.pending_dep
-	ch <- qc.running
-	ch <- qc.suspended
-	ch <- qc.cancelled
-	ch <- qc.completing
-	ch <- qc.completed
-	ch <- qc.configuring
-	ch <- qc.failed
-	ch <- qc.timeout
-	ch <- qc.preempted
-	ch <- qc.node_fail
+	if qc.schema.Legacy() {
+		ch <- qc.pending
+		ch <- qc.pending_dep
+		ch <- qc.running
+		ch <- qc.suspended
+		ch <- qc.cancelled
+		ch <- qc.completing
+		ch <- qc.completed
+		ch <- qc.configuring
+		ch <- qc.failed
+		ch <- qc.timeout
+		ch <- qc.preempted
+		ch <- qc.node_fail
+	}
+	if qc.schema.V2() {
+		ch <- qc.jobs
+		ch <- qc.jobs_dep
+	}
+	ch <- qc.cpus
+	ch <- qc.gpus
+	ch <- qc.memory
+	ch <- qc.arrays
+	ch <- qc.arrayTasks
+	ch <- qc.throttled
+	ch <- qc.hetJobs
+	ch <- qc.hetComps
 }
 
 func (qc *QueueCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := qc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	jobsRespBytes, found := apiCache.Get("jobs")
-	if !found {
-		slog.Error("failed to get jobs response for users metrics from cache")
-		return
-	}
-	jobsData, err := api.ProcessJobsResponse(jobsRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process jobs data for queue metrics", "error", err)
+	snapshot := api.CurrentSnapshot(qc.ctx)
+	jobsData := snapshot.Jobs
+	if jobsData == nil {
+		slog.Error("failed to get jobs data for queue metrics", "error", snapshot.Err("jobs"))
 		return
 	}
 	qm, err := ParseQueueMetrics(jobsData)
@@ -76,25 +114,43 @@ func (qc *QueueCollector) Collect(ch chan<- prometheus.Metric) {
 		slog.Error("failed to collect queue metrics", "error", err)
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(qc.pending, prometheus.GaugeValue, qm.pending)
-	ch <- prometheus.MustNewConstMetric(qc.pending_dep, prometheus.GaugeValue, qm.pending_dep)
-	ch <- prometheus.MustNewConstMetric(qc.running, prometheus.GaugeValue, qm.running)
-	ch <- prometheus.MustNewConstMetric(qc.suspended, prometheus.GaugeValue, qm.suspended)
-	ch <- prometheus.MustNewConstMetric(qc.cancelled, prometheus.GaugeValue, qm.cancelled)
-	ch <- prometheus.MustNewConstMetric(qc.completing, prometheus.GaugeValue, qm.completing)
-	ch <- prometheus.MustNewConstMetric(qc.completed, prometheus.GaugeValue, qm.completed)
-	ch <- prometheus.MustNewConstMetric(qc.configuring, prometheus.GaugeValue, qm.configuring)
-	ch <- prometheus.MustNewConstMetric(qc.failed, prometheus.GaugeValue, qm.failed)
-	ch <- prometheus.MustNewConstMetric(qc.timeout, prometheus.GaugeValue, qm.timeout)
-	ch <- prometheus.MustNewConstMetric(qc.preempted, prometheus.GaugeValue, qm.preempted)
-	ch <- prometheus.MustNewConstMetric(qc.node_fail, prometheus.GaugeValue, qm.node_fail)
+	if qc.schema.Legacy() {
+		ch <- prometheus.MustNewConstMetric(qc.pending, prometheus.GaugeValue, qm.pending)
+		ch <- prometheus.MustNewConstMetric(qc.pending_dep, prometheus.GaugeValue, qm.pending_dep)
+		ch <- prometheus.MustNewConstMetric(qc.running, prometheus.GaugeValue, qm.running)
+		ch <- prometheus.MustNewConstMetric(qc.suspended, prometheus.GaugeValue, qm.suspended)
+		ch <- prometheus.MustNewConstMetric(qc.cancelled, prometheus.GaugeValue, qm.cancelled)
+		ch <- prometheus.MustNewConstMetric(qc.completing, prometheus.GaugeValue, qm.completing)
+		ch <- prometheus.MustNewConstMetric(qc.completed, prometheus.GaugeValue, qm.completed)
+		ch <- prometheus.MustNewConstMetric(qc.configuring, prometheus.GaugeValue, qm.configuring)
+		ch <- prometheus.MustNewConstMetric(qc.failed, prometheus.GaugeValue, qm.failed)
+		ch <- prometheus.MustNewConstMetric(qc.timeout, prometheus.GaugeValue, qm.timeout)
+		ch <- prometheus.MustNewConstMetric(qc.preempted, prometheus.GaugeValue, qm.preempted)
+		ch <- prometheus.MustNewConstMetric(qc.node_fail, prometheus.GaugeValue, qm.node_fail)
+	}
+	if qc.schema.V2() {
+		for _, state := range types.JobStates {
+			ch <- prometheus.MustNewConstMetric(qc.jobs, prometheus.GaugeValue, qm.jobs[state], string(state))
+		}
+		ch <- prometheus.MustNewConstMetric(qc.jobs_dep, prometheus.GaugeValue, qm.pending_dep)
+	}
+	qm.pending_demand.collect(ch, qc.cpus, qc.gpus, qc.memory, "pending")
+	qm.running_demand.collect(ch, qc.cpus, qc.gpus, qc.memory, "running")
+	ch <- prometheus.MustNewConstMetric(qc.arrays, prometheus.GaugeValue, qm.arrays_pending)
+	ch <- prometheus.MustNewConstMetric(qc.arrayTasks, prometheus.GaugeValue, qm.array_tasks_pending)
+	ch <- prometheus.MustNewConstMetric(qc.throttled, prometheus.GaugeValue, qm.array_tasks_throttled)
+	ch <- prometheus.MustNewConstMetric(qc.hetJobs, prometheus.GaugeValue, qm.het_jobs)
+	ch <- prometheus.MustNewConstMetric(qc.hetComps, prometheus.GaugeValue, qm.het_job_components)
 }
 
 func NewQueueMetrics() *queueMetrics {
-	return &queueMetrics{}
+	return &queueMetrics{jobs: make(map[types.JobState]float64)}
 }
 
 type queueMetrics struct {
+	// jobs counts the jobs in every state, pending includes the jobs
+	// waiting on a dependency
+	jobs        map[types.JobState]float64
 	pending     float64
 	pending_dep float64
 	running     float64
@@ -107,39 +163,161 @@ type queueMetrics struct {
 	timeout     float64
 	preempted   float64
 	node_fail   float64
+	// the resources of the pending and running jobs
+	pending_demand jobDemand
+	running_demand jobDemand
+	// the job arrays with pending tasks, their pending tasks and the ones
+	// held back by the limit on the running tasks of the array
+	arrays_pending        float64
+	array_tasks_pending   float64
+	array_tasks_throttled float64
+	// the het jobs and their components, which are also counted once in
+	// jobs
+	het_jobs           float64
+	het_job_components float64
+}
+
+// jobDemand adds up the resources of jobs, the memory is in megabytes
+type jobDemand struct {
+	cpus   float64
+	gpus   float64
+	memory float64
+}
+
+// addJob adds the resources of every task the job stands for
+func (d *jobDemand) addJob(j api.JobData) {
+	n := float64(j.Tasks())
+	d.cpus += float64(j.TresCpus) * n
+	d.gpus += float64(j.TresGPUs) * n
+	d.memory += float64(j.TresMemory) * n
+}
+
+func (d *jobDemand) add(o jobDemand) {
+	d.cpus += o.cpus
+	d.gpus += o.gpus
+	d.memory += o.memory
+}
+
+// collect sends the demand with the given labels followed by the state
+func (d *jobDemand) collect(ch chan<- prometheus.Metric, cpus *prometheus.Desc, gpus *prometheus.Desc, memory *prometheus.Desc, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(cpus, prometheus.GaugeValue, d.cpus, labels...)
+	ch <- prometheus.MustNewConstMetric(gpus, prometheus.GaugeValue, d.gpus, labels...)
+	ch <- prometheus.MustNewConstMetric(memory, prometheus.GaugeValue, d.memory*1024*1024, labels...)
 }
 
 func ParseQueueMetrics(jobsData *api.JobsData) (*queueMetrics, error) {
 	qm := NewQueueMetrics()
+	arrays := make(map[int32]*arrayTasks)
+	hetJobs := make(map[int32]bool)
+	counter := make(jobCounter)
 	for _, j := range jobsData.Jobs {
+		// a pending job array counts as its tasks, and a het job as one job
+		n := counter.count(j, "")
+		qm.jobs[j.JobState] += n
+		if j.HetJobId != 0 {
+			hetJobs[j.HetJobId] = true
+			qm.het_job_components++
+		}
+		if j.ArrayJobId != 0 {
+			if _, exists := arrays[j.ArrayJobId]; !exists {
+				arrays[j.ArrayJobId] = &arrayTasks{}
+			}
+			arrays[j.ArrayJobId].addJob(j)
+		}
 		switch j.JobState {
 		case types.JobStatePending:
+			qm.pending_demand.addJob(j)
 			if j.Dependency != "" {
-				qm.pending_dep++
+				qm.pending_dep += n
 			} else {
-				qm.pending++
+				qm.pending += n
 			}
 		case types.JobStateRunning:
-			qm.running++
+			qm.running_demand.addJob(j)
+			qm.running += n
 		case types.JobStateSuspended:
-			qm.suspended++
+			qm.suspended += n
 		case types.JobStateCancelled:
-			qm.cancelled++
+			qm.cancelled += n
 		case types.JobStateCompleting:
-			qm.completing++
+			qm.completing += n
 		case types.JobStateCompleted:
-			qm.completed++
+			qm.completed += n
 		case types.JobStateConfiguring:
-			qm.configuring++
+			qm.configuring += n
 		case types.JobStateFailed:
-			qm.failed++
+			qm.failed += n
 		case types.JobStateTimeout:
-			qm.timeout++
+			qm.timeout += n
 		case types.JobStatePreempted:
-			qm.preempted++
+			qm.preempted += n
 		case types.JobStateNodeFail:
-			qm.node_fail++
+			qm.node_fail += n
+		}
+	}
+	for _, a := range arrays {
+		if a.pending == 0 {
+			continue
 		}
+		qm.arrays_pending++
+		qm.array_tasks_pending += a.pending
+		qm.array_tasks_throttled += a.throttled()
 	}
+	qm.het_jobs = float64(len(hetJobs))
 	return qm, nil
 }
+
+// hetJobKey is a het job counted in a state and group, such as a user or a
+// partition
+type hetJobKey struct {
+	id    int32
+	state types.JobState
+	group string
+}
+
+// jobCounter counts every component of a het job as a single job
+type jobCounter map[hetJobKey]bool
+
+// count returns the number of jobs the record counts as in group: the tasks
+// of a job array, or 1 for the first component of a het job and 0 for the
+// others. The components of a het job can be in different partitions, so it
+// is counted once in each of them.
+func (c jobCounter) count(j api.JobData, group string) float64 {
+	if j.HetJobId == 0 {
+		return float64(j.Tasks())
+	}
+	key := hetJobKey{j.HetJobId, j.JobState, group}
+	if c[key] {
+		return 0
+	}
+	c[key] = true
+	return 1
+}
+
+// arrayTasks counts the tasks of a job array
+type arrayTasks struct {
+	pending  float64
+	running  float64
+	maxTasks float64
+}
+
+func (a *arrayTasks) addJob(j api.JobData) {
+	switch j.JobState {
+	case types.JobStatePending:
+		a.pending += float64(j.Tasks())
+	case types.JobStateRunning:
+		a.running += float64(j.Tasks())
+	}
+	if j.ArrayMaxTasks > 0 {
+		a.maxTasks = float64(j.ArrayMaxTasks)
+	}
+}
+
+// throttled returns the pending tasks that can't start because as many
+// tasks as the array allows are already running
+func (a *arrayTasks) throttled() float64 {
+	if a.maxTasks == 0 {
+		return 0
+	}
+	return max(a.pending-max(a.maxTasks-a.running, 0), 0)
+}
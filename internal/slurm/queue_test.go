@@ -0,0 +1,153 @@
+package slurm
+
+import (
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestParseQueueMetricsArrays(t *testing.T) {
+	jobsData := api.NewJobsData()
+	for _, j := range []struct {
+		arrayJobId int32
+		taskString string
+		maxTasks   int32
+		state      types.JobState
+	}{
+		// an array limited to 10 running tasks, 4 of which are running
+		{1, "", 10, types.JobStateRunning},
+		{1, "", 10, types.JobStateRunning},
+		{1, "", 10, types.JobStateRunning},
+		{1, "", 10, types.JobStateRunning},
+		{1, "5-1000%10", 10, types.JobStatePending},
+		// an array without a limit
+		{2, "1-100", 0, types.JobStatePending},
+		// a finished array and a plain job
+		{3, "", 0, types.JobStateCompleted},
+		{0, "", 0, types.JobStatePending},
+	} {
+		jd := api.JobData{JobState: j.state, UserName: "user", Account: "account"}
+		if err := jd.SetJobArray(&j.arrayJobId, nil, &j.taskString, &j.maxTasks); err != nil {
+			t.Fatalf("failed to set job array %s: %v", j.taskString, err)
+		}
+		// slurm reports no cpus for a pending job, only its requested TRES
+		tres := "cpu=2,mem=4G,node=1"
+		if err := jd.SetJobTres(&tres); err != nil {
+			t.Fatalf("failed to set job tres %s: %v", tres, err)
+		}
+		if j.state != types.JobStatePending {
+			jd.Cpus = 2
+		}
+		jobsData.Jobs = append(jobsData.Jobs, jd)
+	}
+
+	qm, err := ParseQueueMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse queue metrics: %v", err)
+	}
+	if qm.jobs[types.JobStatePending] != 1097 || qm.pending != 1097 || qm.running != 4 {
+		t.Errorf("expected the pending tasks to be counted, got %v pending and %v running", qm.jobs[types.JobStatePending], qm.running)
+	}
+	if qm.arrays_pending != 2 || qm.array_tasks_pending != 1096 {
+		t.Errorf("expected 1096 pending tasks in 2 arrays, got %v in %v", qm.array_tasks_pending, qm.arrays_pending)
+	}
+	// 6 of the 996 pending tasks of the first array can start
+	if qm.array_tasks_throttled != 990 {
+		t.Errorf("expected 990 throttled tasks, got %v", qm.array_tasks_throttled)
+	}
+
+	um, err := ParseUsersMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse users metrics: %v", err)
+	}
+	if um["user"].pending != 1097 || um["user"].pending_cpus != 2194 || um["user"].running_cpus != 8 {
+		t.Errorf("expected the pending tasks of the user to be counted, got %+v", *um["user"])
+	}
+	am, err := ParseAccountsMetrics(*jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse accounts metrics: %v", err)
+	}
+	if am["account"].pending != 1097 || am["account"].pending_cpus != 2194 || am["account"].running_cpus != 8 {
+		t.Errorf("expected the pending tasks of the account to be counted, got %+v", *am["account"])
+	}
+}
+
+func TestParseMetricsHetJobs(t *testing.T) {
+	jobsData := api.NewJobsData()
+	for _, j := range []struct {
+		jobId     int32
+		hetJobId  int32
+		offset    int32
+		partition string
+		state     types.JobState
+		tres      string
+	}{
+		// a running het job with components in two partitions
+		{10, 10, 0, "cpu", types.JobStateRunning, "cpu=32,mem=64G"},
+		{11, 10, 1, "gpu", types.JobStateRunning, "cpu=4,mem=16G,gres/gpu=2"},
+		{12, 10, 2, "gpu", types.JobStateRunning, "cpu=4,mem=16G,gres/gpu=2"},
+		// a pending het job
+		{20, 20, 0, "cpu", types.JobStatePending, "cpu=8"},
+		{21, 20, 1, "cpu", types.JobStatePending, "cpu=8"},
+		// a plain job
+		{30, 0, 0, "cpu", types.JobStateRunning, "cpu=1"},
+	} {
+		jd := api.JobData{JobId: j.jobId, Partition: j.partition, JobState: j.state, UserName: "user", Account: "account"}
+		if err := jd.SetJobHet(&j.hetJobId, &j.offset); err != nil {
+			t.Fatalf("failed to set het job: %v", err)
+		}
+		if err := jd.SetJobTres(&j.tres); err != nil {
+			t.Fatalf("failed to set job tres %s: %v", j.tres, err)
+		}
+		if j.state == types.JobStateRunning {
+			jd.Cpus = int32(jd.TresCpus)
+		}
+		jobsData.Jobs = append(jobsData.Jobs, jd)
+	}
+
+	qm, err := ParseQueueMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse queue metrics: %v", err)
+	}
+	if qm.jobs[types.JobStateRunning] != 2 || qm.jobs[types.JobStatePending] != 1 {
+		t.Errorf("expected the het jobs to be counted once, got %v", qm.jobs)
+	}
+	if qm.het_jobs != 2 || qm.het_job_components != 5 {
+		t.Errorf("expected 2 het jobs with 5 components, got %v with %v", qm.het_jobs, qm.het_job_components)
+	}
+	// the resources of every component add up
+	if want := (jobDemand{cpus: 41, gpus: 4, memory: 98304}); qm.running_demand != want {
+		t.Errorf("expected running demand %+v, got %+v", want, qm.running_demand)
+	}
+	if want := (jobDemand{cpus: 16}); qm.pending_demand != want {
+		t.Errorf("expected pending demand %+v, got %+v", want, qm.pending_demand)
+	}
+
+	um, err := ParseUsersMetrics(jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse users metrics: %v", err)
+	}
+	if u := um["user"]; u.running != 2 || u.running_cpus != 41 || u.pending != 1 || u.pending_cpus != 16 {
+		t.Errorf("unexpected user metrics %+v", *u)
+	}
+	am, err := ParseAccountsMetrics(*jobsData)
+	if err != nil {
+		t.Fatalf("failed to parse accounts metrics: %v", err)
+	}
+	if a := am["account"]; a.running != 2 || a.running_cpus != 41 || a.pending != 1 || a.pending_cpus != 16 {
+		t.Errorf("unexpected account metrics %+v", *a)
+	}
+
+	// the running het job is counted once in each of its partitions
+	pm, err := ParsePartitionsMetrics(api.NewPartitionsData(), jobsData, api.NewNodesData())
+	if err != nil {
+		t.Fatalf("failed to parse partitions metrics: %v", err)
+	}
+	if pm["cpu"].jobs[types.JobStateRunning] != 2 || pm["gpu"].jobs[types.JobStateRunning] != 1 || pm["cpu"].jobs[types.JobStatePending] != 1 {
+		t.Errorf("unexpected partition jobs, cpu %v and gpu %v", pm["cpu"].jobs, pm["gpu"].jobs)
+	}
+	if want := (jobDemand{cpus: 8, gpus: 4, memory: 32768}); pm["gpu"].running_demand != want {
+		t.Errorf("expected running demand %+v for gpu, got %+v", want, pm["gpu"].running_demand)
+	}
+}
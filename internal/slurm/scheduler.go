@@ -3,8 +3,8 @@ package slurm
 import (
 	"context"
 	"log/slog"
+	"sync"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,6 +12,7 @@ import (
 
 type SchedulerCollector struct {
 	ctx                               context.Context
+	schema                            types.MetricSchema
 	threads                           *prometheus.Desc
 	queue_size                        *prometheus.Desc
 	dbd_queue_size                    *prometheus.Desc
@@ -24,124 +25,441 @@ type SchedulerCollector struct {
 	total_backfilled_jobs_since_start *prometheus.Desc
 	total_backfilled_jobs_since_cycle *prometheus.Desc
 	total_backfilled_heterogeneous    *prometheus.Desc
+	rpc_count_by_type                 *prometheus.Desc
+	rpc_time_by_type                  *prometheus.Desc
+	rpc_count_by_user                 *prometheus.Desc
+	rpc_time_by_user                  *prometheus.Desc
+	rpc_pending_by_type               *prometheus.Desc
+	jobs_pending                      *prometheus.Desc
+	jobs_running                      *prometheus.Desc
+	max_cycle                         *prometheus.Desc
+	schedule_queue_length             *prometheus.Desc
+	backfill_max_cycle                *prometheus.Desc
+	backfill_queue_length             *prometheus.Desc
+	backfill_table_size               *prometheus.Desc
+	backfill_last_cycle_time          *prometheus.Desc
+	backfill_active                   *prometheus.Desc
+	gettimeofday_latency              *prometheus.Desc
+	stats_request_time                *prometheus.Desc
+	stats_reset_time                  *prometheus.Desc
+	jobs_submitted                    *prometheus.Desc
+	jobs_started                      *prometheus.Desc
+	jobs_completed                    *prometheus.Desc
+	jobs_canceled                     *prometheus.Desc
+	jobs_failed                       *prometheus.Desc
+	cycles                            *prometheus.Desc
+	backfill_cycles                   *prometheus.Desc
+	agent_queue_size                  *prometheus.Desc
+	cycle_last_seconds                *prometheus.Desc
+	cycle_mean_seconds                *prometheus.Desc
+	cycle_max_seconds                 *prometheus.Desc
+	backfill_cycle_last_seconds       *prometheus.Desc
+	backfill_cycle_mean_seconds       *prometheus.Desc
+	backfill_cycle_max_seconds        *prometheus.Desc
+	backfilled_jobs                   *prometheus.Desc
+	backfilled_jobs_since_reset       *prometheus.Desc
+	backfilled_het_jobs               *prometheus.Desc
+	gettimeofday_latency_seconds      *prometheus.Desc
+	counters                          *resetCounters
 }
 
 func NewSchedulerCollector(ctx context.Context) *SchedulerCollector {
 	return &SchedulerCollector{
-		ctx: ctx,
-		threads: prometheus.NewDesc(
+		ctx:    ctx,
+		schema: metricSchema(ctx),
+		threads: newDesc(ctx,
 			"slurm_scheduler_threads",
 			"Information provided by the Slurm sdiag command, number of scheduler threads ",
 			nil,
 			nil),
-		queue_size: prometheus.NewDesc(
+		queue_size: newDesc(ctx,
 			"slurm_scheduler_queue_size",
 			"Information provided by the Slurm sdiag command, length of the scheduler queue",
 			nil,
 			nil),
-		dbd_queue_size: prometheus.NewDesc(
+		dbd_queue_size: newDesc(ctx,
 			"slurm_scheduler_dbd_queue_size",
 			"Information provided by the Slurm sdiag command, length of the DBD agent queue",
 			nil,
 			nil),
-		last_cycle: prometheus.NewDesc(
+		last_cycle: newDesc(ctx,
 			"slurm_scheduler_last_cycle",
 			"Information provided by the Slurm sdiag command, scheduler last cycle time in (microseconds)",
 			nil,
 			nil),
-		mean_cycle: prometheus.NewDesc(
+		mean_cycle: newDesc(ctx,
 			"slurm_scheduler_mean_cycle",
 			"Information provided by the Slurm sdiag command, scheduler mean cycle time in (microseconds)",
 			nil,
 			nil),
-		cycle_per_minute: prometheus.NewDesc(
+		cycle_per_minute: newDesc(ctx,
 			"slurm_scheduler_cycle_per_minute",
 			"Information provided by the Slurm sdiag command, number scheduler cycles per minute",
 			nil,
 			nil),
-		backfill_last_cycle: prometheus.NewDesc(
+		backfill_last_cycle: newDesc(ctx,
 			"slurm_scheduler_backfill_last_cycle",
 			"Information provided by the Slurm sdiag command, scheduler backfill last cycle time in (microseconds)",
 			nil,
 			nil),
-		backfill_mean_cycle: prometheus.NewDesc(
+		backfill_mean_cycle: newDesc(ctx,
 			"slurm_scheduler_backfill_mean_cycle",
 			"Information provided by the Slurm sdiag command, scheduler backfill mean cycle time in (microseconds)",
 			nil,
 			nil),
-		backfill_depth_mean: prometheus.NewDesc(
+		backfill_depth_mean: newDesc(ctx,
 			"slurm_scheduler_backfill_depth_mean",
 			"Information provided by the Slurm sdiag command, scheduler backfill mean depth",
 			nil,
 			nil),
-		total_backfilled_jobs_since_start: prometheus.NewDesc(
+		total_backfilled_jobs_since_start: newDesc(ctx,
 			"slurm_scheduler_backfilled_jobs_since_start_total",
 			"Information provided by the Slurm sdiag command, number of jobs started thanks to backfilling since last slurm start",
 			nil,
 			nil),
-		total_backfilled_jobs_since_cycle: prometheus.NewDesc(
+		total_backfilled_jobs_since_cycle: newDesc(ctx,
 			"slurm_scheduler_backfilled_jobs_since_cycle_total",
 			"Information provided by the Slurm sdiag command, number of jobs started thanks to backfilling since last time stats where reset",
 			nil,
 			nil),
-		total_backfilled_heterogeneous: prometheus.NewDesc(
+		total_backfilled_heterogeneous: newDesc(ctx,
 			"slurm_scheduler_backfilled_heterogeneous_total",
 			"Information provided by the Slurm sdiag command, number of heterogeneous job components started thanks to backfilling since last Slurm start",
 			nil,
 			nil),
+		rpc_count_by_type: newDesc(ctx,
+			"slurm_rpc_count_total",
+			"Information provided by the Slurm sdiag command, number of RPCs received by message type",
+			[]string{"type"},
+			nil),
+		rpc_time_by_type: newDesc(ctx,
+			"slurm_rpc_time_seconds_total",
+			"Information provided by the Slurm sdiag command, total time spent processing RPCs by message type",
+			[]string{"type"},
+			nil),
+		rpc_count_by_user: newDesc(ctx,
+			"slurm_rpc_user_count_total",
+			"Information provided by the Slurm sdiag command, number of RPCs received by user",
+			[]string{"user"},
+			nil),
+		rpc_time_by_user: newDesc(ctx,
+			"slurm_rpc_user_time_seconds_total",
+			"Information provided by the Slurm sdiag command, total time spent processing RPCs by user",
+			[]string{"user"},
+			nil),
+		rpc_pending_by_type: newDesc(ctx,
+			"slurm_rpc_pending",
+			"Information provided by the Slurm sdiag command, number of RPCs queued by message type",
+			[]string{"type"},
+			nil),
+		jobs_pending: newDesc(ctx,
+			"slurm_scheduler_jobs_pending",
+			"Information provided by the Slurm sdiag command, number of jobs pending at the time of the last job state snapshot",
+			nil,
+			nil),
+		jobs_running: newDesc(ctx,
+			"slurm_scheduler_jobs_running",
+			"Information provided by the Slurm sdiag command, number of jobs running at the time of the last job state snapshot",
+			nil,
+			nil),
+		max_cycle: newDesc(ctx,
+			"slurm_scheduler_max_cycle",
+			"Information provided by the Slurm sdiag command, scheduler max cycle time since last reset in (microseconds)",
+			nil,
+			nil),
+		schedule_queue_length: newDesc(ctx,
+			"slurm_scheduler_schedule_queue_length",
+			"Information provided by the Slurm sdiag command, number of jobs pending in the main scheduler queue",
+			nil,
+			nil),
+		backfill_max_cycle: newDesc(ctx,
+			"slurm_scheduler_backfill_max_cycle",
+			"Information provided by the Slurm sdiag command, scheduler backfill max cycle time since last reset in (microseconds)",
+			nil,
+			nil),
+		backfill_queue_length: newDesc(ctx,
+			"slurm_scheduler_backfill_queue_length",
+			"Information provided by the Slurm sdiag command, number of jobs pending to be processed by the backfill scheduler",
+			nil,
+			nil),
+		backfill_table_size: newDesc(ctx,
+			"slurm_scheduler_backfill_table_size",
+			"Information provided by the Slurm sdiag command, number of time slots tested by the backfill scheduler in its last cycle",
+			nil,
+			nil),
+		backfill_last_cycle_time: newDesc(ctx,
+			"slurm_scheduler_backfill_last_cycle_timestamp_seconds",
+			"Information provided by the Slurm sdiag command, unix timestamp of the last backfill cycle",
+			nil,
+			nil),
+		backfill_active: newDesc(ctx,
+			"slurm_scheduler_backfill_active",
+			"Information provided by the Slurm sdiag command, whether the backfill scheduler is currently running (1) or not (0)",
+			nil,
+			nil),
+		gettimeofday_latency: newDesc(ctx,
+			"slurm_scheduler_gettimeofday_latency",
+			"Information provided by the Slurm sdiag command, latency of 1000 gettimeofday() calls at controller startup in (microseconds)",
+			nil,
+			nil),
+		stats_request_time: newDesc(ctx,
+			"slurm_scheduler_stats_request_timestamp_seconds",
+			"Information provided by the Slurm sdiag command, unix timestamp of the statistics request",
+			nil,
+			nil),
+		stats_reset_time: newDesc(ctx,
+			"slurm_scheduler_stats_reset_timestamp_seconds",
+			"Information provided by the Slurm sdiag command, unix timestamp of the last statistics reset",
+			nil,
+			nil),
+		jobs_submitted: newDesc(ctx,
+			"slurm_scheduler_jobs_submitted_total",
+			"Information provided by the Slurm sdiag command, number of jobs submitted since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		jobs_started: newDesc(ctx,
+			"slurm_scheduler_jobs_started_total",
+			"Information provided by the Slurm sdiag command, number of jobs started since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		jobs_completed: newDesc(ctx,
+			"slurm_scheduler_jobs_completed_total",
+			"Information provided by the Slurm sdiag command, number of jobs completed since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		jobs_canceled: newDesc(ctx,
+			"slurm_scheduler_jobs_canceled_total",
+			"Information provided by the Slurm sdiag command, number of jobs canceled since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		jobs_failed: newDesc(ctx,
+			"slurm_scheduler_jobs_failed_total",
+			"Information provided by the Slurm sdiag command, number of jobs failed due to slurmd or other internal issues since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		cycles: newDesc(ctx,
+			"slurm_scheduler_cycles_total",
+			"Information provided by the Slurm sdiag command, number of scheduler cycles since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		backfill_cycles: newDesc(ctx,
+			"slurm_scheduler_backfill_cycles_total",
+			"Information provided by the Slurm sdiag command, number of backfill scheduler cycles since the statistics were last reset, adjusted by the exporter to survive resets",
+			nil,
+			nil),
+		agent_queue_size: newDesc(ctx,
+			"slurm_scheduler_agent_queue_size",
+			"Information provided by the Slurm sdiag command, number of enqueued outgoing RPC requests in the agent retry list",
+			nil,
+			nil),
+		cycle_last_seconds: newDesc(ctx,
+			"slurm_scheduler_cycle_last_seconds",
+			"Information provided by the Slurm sdiag command, duration of the last scheduler cycle",
+			nil,
+			nil),
+		cycle_mean_seconds: newDesc(ctx,
+			"slurm_scheduler_cycle_mean_seconds",
+			"Information provided by the Slurm sdiag command, mean duration of scheduler cycles since last reset",
+			nil,
+			nil),
+		cycle_max_seconds: newDesc(ctx,
+			"slurm_scheduler_cycle_max_seconds",
+			"Information provided by the Slurm sdiag command, max duration of scheduler cycles since last reset",
+			nil,
+			nil),
+		backfill_cycle_last_seconds: newDesc(ctx,
+			"slurm_scheduler_backfill_cycle_last_seconds",
+			"Information provided by the Slurm sdiag command, duration of the last backfill cycle",
+			nil,
+			nil),
+		backfill_cycle_mean_seconds: newDesc(ctx,
+			"slurm_scheduler_backfill_cycle_mean_seconds",
+			"Information provided by the Slurm sdiag command, mean duration of backfill cycles since last reset",
+			nil,
+			nil),
+		backfill_cycle_max_seconds: newDesc(ctx,
+			"slurm_scheduler_backfill_cycle_max_seconds",
+			"Information provided by the Slurm sdiag command, max duration of backfill cycles since last reset",
+			nil,
+			nil),
+		backfilled_jobs: newDesc(ctx,
+			"slurm_scheduler_backfilled_jobs_total",
+			"Information provided by the Slurm sdiag command, number of jobs started thanks to backfilling since last slurm start",
+			nil,
+			nil),
+		backfilled_jobs_since_reset: newDesc(ctx,
+			"slurm_scheduler_backfilled_jobs_since_reset",
+			"Information provided by the Slurm sdiag command, number of jobs started thanks to backfilling since last time stats where reset",
+			nil,
+			nil),
+		backfilled_het_jobs: newDesc(ctx,
+			"slurm_scheduler_backfilled_het_jobs_total",
+			"Information provided by the Slurm sdiag command, number of heterogeneous job components started thanks to backfilling since last Slurm start",
+			nil,
+			nil),
+		gettimeofday_latency_seconds: newDesc(ctx,
+			"slurm_scheduler_gettimeofday_latency_seconds",
+			"Information provided by the Slurm sdiag command, latency of 1000 gettimeofday() calls at controller startup",
+			nil,
+			nil),
+		counters: newResetCounters(),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (sc *SchedulerCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *sc
+	c.ctx = ctx
+	return &c
+}
+
 // Send all metric descriptions
 func (c *SchedulerCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.threads
-	ch <- c.queue_size
 	ch <- c.dbd_queue_size
-	ch <- c.last_cycle
-	ch <- c.mean_cycle
 	ch <- c.cycle_per_minute
-	ch <- c.backfill_last_cycle
-	ch <- c.backfill_mean_cycle
 	ch <- c.backfill_depth_mean
-	ch <- c.total_backfilled_jobs_since_start
-	ch <- c.total_backfilled_jobs_since_cycle
-	ch <- c.total_backfilled_heterogeneous
+	ch <- c.rpc_count_by_type
+	ch <- c.rpc_time_by_type
+	ch <- c.rpc_count_by_user
+	ch <- c.rpc_time_by_user
+	ch <- c.rpc_pending_by_type
+	ch <- c.jobs_pending
+	ch <- c.jobs_running
+	ch <- c.schedule_queue_length
+	ch <- c.backfill_queue_length
+	ch <- c.backfill_table_size
+	ch <- c.backfill_last_cycle_time
+	ch <- c.backfill_active
+	ch <- c.stats_request_time
+	ch <- c.stats_reset_time
+	ch <- c.jobs_submitted
+	ch <- c.jobs_started
+	ch <- c.jobs_completed
+	ch <- c.jobs_canceled
+	ch <- c.jobs_failed
+	ch <- c.cycles
+	ch <- c.backfill_cycles
+	if c.schema.Legacy() {
+		ch <- c.queue_size
+		ch <- c.last_cycle
+		ch <- c.mean_cycle
+		ch <- c.backfill_last_cycle
+		ch <- c.backfill_mean_cycle
+		ch <- c.total_backfilled_jobs_since_start
+		ch <- c.total_backfilled_jobs_since_cycle
+		ch <- c.total_backfilled_heterogeneous
+		ch <- c.max_cycle
+		ch <- c.backfill_max_cycle
+		ch <- c.gettimeofday_latency
+	}
+	if c.schema.V2() {
+		ch <- c.agent_queue_size
+		ch <- c.cycle_last_seconds
+		ch <- c.cycle_mean_seconds
+		ch <- c.cycle_max_seconds
+		ch <- c.backfill_cycle_last_seconds
+		ch <- c.backfill_cycle_mean_seconds
+		ch <- c.backfill_cycle_max_seconds
+		ch <- c.backfilled_jobs
+		ch <- c.backfilled_jobs_since_reset
+		ch <- c.backfilled_het_jobs
+		ch <- c.gettimeofday_latency_seconds
+	}
 }
 
 // Send the values of all metrics
 func (sc *SchedulerCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := sc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	diagRespBytes, found := apiCache.Get("diag")
-	if !found {
-		slog.Error("failed to get diag response for scheduler metrics from cache")
-		return
-	}
-	diagData, err := api.ProcessDiagResponse(diagRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process diag response for scheduler metrics", "error", err)
+	snapshot := api.CurrentSnapshot(sc.ctx)
+	diagData := snapshot.Diag
+	if diagData == nil {
+		slog.Error("failed to get diag data for scheduler metrics", "error", snapshot.Err("diag"))
 		return
 	}
+	diagData = relabelDiag(sc.ctx, diagData)
 	sm, err := ParseSchedulerMetrics(diagData)
 	if err != nil {
 		slog.Error("failed to collect scheduler metrics", "error", err)
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(sc.threads, prometheus.GaugeValue, sm.threads)
-	ch <- prometheus.MustNewConstMetric(sc.queue_size, prometheus.GaugeValue, sm.queue_size)
 	ch <- prometheus.MustNewConstMetric(sc.dbd_queue_size, prometheus.GaugeValue, sm.dbd_queue_size)
-	ch <- prometheus.MustNewConstMetric(sc.last_cycle, prometheus.GaugeValue, sm.last_cycle)
-	ch <- prometheus.MustNewConstMetric(sc.mean_cycle, prometheus.GaugeValue, sm.mean_cycle)
 	ch <- prometheus.MustNewConstMetric(sc.cycle_per_minute, prometheus.GaugeValue, sm.cycle_per_minute)
-	ch <- prometheus.MustNewConstMetric(sc.backfill_last_cycle, prometheus.GaugeValue, sm.backfill_last_cycle)
-	ch <- prometheus.MustNewConstMetric(sc.backfill_mean_cycle, prometheus.GaugeValue, sm.backfill_mean_cycle)
 	ch <- prometheus.MustNewConstMetric(sc.backfill_depth_mean, prometheus.GaugeValue, sm.backfill_depth_mean)
-	ch <- prometheus.MustNewConstMetric(sc.total_backfilled_jobs_since_start, prometheus.GaugeValue, sm.total_backfilled_jobs_since_start)
-	ch <- prometheus.MustNewConstMetric(sc.total_backfilled_jobs_since_cycle, prometheus.GaugeValue, sm.total_backfilled_jobs_since_cycle)
-	ch <- prometheus.MustNewConstMetric(sc.total_backfilled_heterogeneous, prometheus.GaugeValue, sm.total_backfilled_heterogeneous)
+	ch <- prometheus.MustNewConstMetric(sc.jobs_pending, prometheus.GaugeValue, sm.jobs_pending)
+	ch <- prometheus.MustNewConstMetric(sc.jobs_running, prometheus.GaugeValue, sm.jobs_running)
+	ch <- prometheus.MustNewConstMetric(sc.schedule_queue_length, prometheus.GaugeValue, sm.schedule_queue_length)
+	ch <- prometheus.MustNewConstMetric(sc.backfill_queue_length, prometheus.GaugeValue, sm.backfill_queue_length)
+	ch <- prometheus.MustNewConstMetric(sc.backfill_table_size, prometheus.GaugeValue, sm.backfill_table_size)
+	ch <- prometheus.MustNewConstMetric(sc.backfill_last_cycle_time, prometheus.GaugeValue, sm.backfill_last_cycle_time)
+	ch <- prometheus.MustNewConstMetric(sc.backfill_active, prometheus.GaugeValue, sm.backfill_active)
+	ch <- prometheus.MustNewConstMetric(sc.stats_request_time, prometheus.GaugeValue, sm.stats_request_time)
+	ch <- prometheus.MustNewConstMetric(sc.stats_reset_time, prometheus.GaugeValue, sm.stats_reset_time)
+	if sc.schema.Legacy() {
+		ch <- prometheus.MustNewConstMetric(sc.queue_size, prometheus.GaugeValue, sm.queue_size)
+		ch <- prometheus.MustNewConstMetric(sc.last_cycle, prometheus.GaugeValue, sm.last_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.mean_cycle, prometheus.GaugeValue, sm.mean_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.backfill_last_cycle, prometheus.GaugeValue, sm.backfill_last_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.backfill_mean_cycle, prometheus.GaugeValue, sm.backfill_mean_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.total_backfilled_jobs_since_start, prometheus.GaugeValue, sm.total_backfilled_jobs_since_start)
+		ch <- prometheus.MustNewConstMetric(sc.total_backfilled_jobs_since_cycle, prometheus.GaugeValue, sm.total_backfilled_jobs_since_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.total_backfilled_heterogeneous, prometheus.GaugeValue, sm.total_backfilled_heterogeneous)
+		ch <- prometheus.MustNewConstMetric(sc.max_cycle, prometheus.GaugeValue, sm.max_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.backfill_max_cycle, prometheus.GaugeValue, sm.backfill_max_cycle)
+		ch <- prometheus.MustNewConstMetric(sc.gettimeofday_latency, prometheus.GaugeValue, sm.gettimeofday_latency)
+	}
+	if sc.schema.V2() {
+		ch <- prometheus.MustNewConstMetric(sc.agent_queue_size, prometheus.GaugeValue, sm.queue_size)
+		ch <- prometheus.MustNewConstMetric(sc.cycle_last_seconds, prometheus.GaugeValue, sm.last_cycle/1e6)
+		ch <- prometheus.MustNewConstMetric(sc.cycle_mean_seconds, prometheus.GaugeValue, sm.mean_cycle/1e6)
+		ch <- prometheus.MustNewConstMetric(sc.cycle_max_seconds, prometheus.GaugeValue, sm.max_cycle/1e6)
+		ch <- prometheus.MustNewConstMetric(sc.backfill_cycle_last_seconds, prometheus.GaugeValue, sm.backfill_last_cycle/1e6)
+		ch <- prometheus.MustNewConstMetric(sc.backfill_cycle_mean_seconds, prometheus.GaugeValue, sm.backfill_mean_cycle/1e6)
+		ch <- prometheus.MustNewConstMetric(sc.backfill_cycle_max_seconds, prometheus.GaugeValue, sm.backfill_max_cycle/1e6)
+		ch <- prometheus.MustNewConstMetric(sc.backfilled_jobs, prometheus.CounterValue, sm.backfilled_jobs)
+		ch <- prometheus.MustNewConstMetric(sc.backfilled_jobs_since_reset, prometheus.GaugeValue, sm.backfilled_jobs_since_reset)
+		ch <- prometheus.MustNewConstMetric(sc.backfilled_het_jobs, prometheus.CounterValue, sm.total_backfilled_heterogeneous)
+		ch <- prometheus.MustNewConstMetric(sc.gettimeofday_latency_seconds, prometheus.GaugeValue, sm.gettimeofday_latency/1e6)
+	}
+
+	// the job and cycle counters are reset by slurmctld at midnight and on
+	// restart, so keep them monotonic across resets before exposing them
+	counters := sc.counters.Adjust(sm.stats_reset_time, map[string]float64{
+		"jobs_submitted":  sm.jobs_submitted,
+		"jobs_started":    sm.jobs_started,
+		"jobs_completed":  sm.jobs_completed,
+		"jobs_canceled":   sm.jobs_canceled,
+		"jobs_failed":     sm.jobs_failed,
+		"cycles":          sm.cycles,
+		"backfill_cycles": sm.backfill_cycles,
+	})
+	ch <- prometheus.MustNewConstMetric(sc.jobs_submitted, prometheus.CounterValue, counters["jobs_submitted"])
+	ch <- prometheus.MustNewConstMetric(sc.jobs_started, prometheus.CounterValue, counters["jobs_started"])
+	ch <- prometheus.MustNewConstMetric(sc.jobs_completed, prometheus.CounterValue, counters["jobs_completed"])
+	ch <- prometheus.MustNewConstMetric(sc.jobs_canceled, prometheus.CounterValue, counters["jobs_canceled"])
+	ch <- prometheus.MustNewConstMetric(sc.jobs_failed, prometheus.CounterValue, counters["jobs_failed"])
+	ch <- prometheus.MustNewConstMetric(sc.cycles, prometheus.CounterValue, counters["cycles"])
+	ch <- prometheus.MustNewConstMetric(sc.backfill_cycles, prometheus.CounterValue, counters["backfill_cycles"])
+	for t, rpc := range sm.rpcs_by_type {
+		ch <- prometheus.MustNewConstMetric(sc.rpc_count_by_type, prometheus.CounterValue, rpc.count, t)
+		ch <- prometheus.MustNewConstMetric(sc.rpc_time_by_type, prometheus.CounterValue, rpc.time, t)
+	}
+	for u, rpc := range sm.rpcs_by_user {
+		ch <- prometheus.MustNewConstMetric(sc.rpc_count_by_user, prometheus.CounterValue, rpc.count, u)
+		ch <- prometheus.MustNewConstMetric(sc.rpc_time_by_user, prometheus.CounterValue, rpc.time, u)
+	}
+	for t, pending := range sm.rpcs_pending {
+		ch <- prometheus.MustNewConstMetric(sc.rpc_pending_by_type, prometheus.GaugeValue, pending, t)
+	}
 }
 
 func NewSchedulerMetrics() *schedulerMetrics {
-	return &schedulerMetrics{}
+	return &schedulerMetrics{
+		rpcs_by_type: make(map[string]*rpcMetrics),
+		rpcs_by_user: make(map[string]*rpcMetrics),
+		rpcs_pending: make(map[string]float64),
+	}
 }
 
 type schedulerMetrics struct {
@@ -157,6 +475,35 @@ type schedulerMetrics struct {
 	total_backfilled_jobs_since_start float64
 	total_backfilled_jobs_since_cycle float64
 	total_backfilled_heterogeneous    float64
+	backfilled_jobs                   float64
+	backfilled_jobs_since_reset       float64
+	jobs_pending                      float64
+	jobs_running                      float64
+	max_cycle                         float64
+	schedule_queue_length             float64
+	backfill_max_cycle                float64
+	backfill_queue_length             float64
+	backfill_table_size               float64
+	backfill_last_cycle_time          float64
+	backfill_active                   float64
+	gettimeofday_latency              float64
+	stats_request_time                float64
+	stats_reset_time                  float64
+	jobs_submitted                    float64
+	jobs_started                      float64
+	jobs_completed                    float64
+	jobs_canceled                     float64
+	jobs_failed                       float64
+	cycles                            float64
+	backfill_cycles                   float64
+	rpcs_by_type                      map[string]*rpcMetrics
+	rpcs_by_user                      map[string]*rpcMetrics
+	rpcs_pending                      map[string]float64
+}
+
+type rpcMetrics struct {
+	count float64
+	time  float64
 }
 
 // Extract the relevant metrics from the sdiag output
@@ -175,5 +522,93 @@ func ParseSchedulerMetrics(diagData *api.DiagData) (*schedulerMetrics, error) {
 	sm.total_backfilled_jobs_since_cycle = float64(diagData.BfBackfilledJobs)
 	sm.total_backfilled_heterogeneous = float64(diagData.BfBackfilledHetJobs)
 	sm.total_backfilled_jobs_since_start = float64(diagData.BfLastBackfilledJobs)
+	// the legacy names above have the two backfill counters swapped, keep
+	// them as they are for existing dashboards and fix them in v2
+	sm.backfilled_jobs = float64(diagData.BfBackfilledJobs)
+	sm.backfilled_jobs_since_reset = float64(diagData.BfLastBackfilledJobs)
+	sm.jobs_pending = float64(diagData.JobsPending)
+	sm.jobs_running = float64(diagData.JobsRunning)
+	sm.max_cycle = float64(diagData.ScheduleCycleMax)
+	sm.schedule_queue_length = float64(diagData.ScheduleQueueLength)
+	sm.backfill_max_cycle = float64(diagData.BfCycleMax)
+	sm.backfill_queue_length = float64(diagData.BfQueueLen)
+	sm.backfill_table_size = float64(diagData.BfTableSize)
+	sm.backfill_last_cycle_time = float64(diagData.BfWhenLastCycle)
+	sm.gettimeofday_latency = float64(diagData.GettimeofdayLatency)
+	sm.stats_request_time = float64(diagData.ReqTime)
+	sm.stats_reset_time = float64(diagData.ReqTimeStart)
+	sm.jobs_submitted = float64(diagData.JobsSubmitted)
+	sm.jobs_started = float64(diagData.JobsStarted)
+	sm.jobs_completed = float64(diagData.JobsCompleted)
+	sm.jobs_canceled = float64(diagData.JobsCanceled)
+	sm.jobs_failed = float64(diagData.JobsFailed)
+	sm.cycles = float64(diagData.ScheduleCycleTotal)
+	sm.backfill_cycles = float64(diagData.BfCycleCounter)
+	if diagData.BfActive {
+		sm.backfill_active = 1
+	}
+
+	// slurm reports rpc time in microseconds. entries are summed per label in
+	// case the controller reports the same name twice, which would otherwise
+	// fail the whole scrape with duplicate series.
+	for _, rpc := range diagData.RpcsByMessageType {
+		addRpcMetrics(sm.rpcs_by_type, rpc)
+	}
+	for _, rpc := range diagData.RpcsByUser {
+		addRpcMetrics(sm.rpcs_by_user, rpc)
+	}
+	for _, rpc := range diagData.PendingRpcs {
+		sm.rpcs_pending[rpc.Name] += float64(rpc.Count)
+	}
 	return sm, nil
 }
+
+func addRpcMetrics(m map[string]*rpcMetrics, rpc api.RpcData) {
+	if _, exists := m[rpc.Name]; !exists {
+		m[rpc.Name] = &rpcMetrics{}
+	}
+	m[rpc.Name].count += float64(rpc.Count)
+	m[rpc.Name].time += float64(rpc.TotalTime) / 1e6
+}
+
+// resetCounters keeps track of counters that slurmctld resets periodically
+// (see req_time_start) so they can be exposed as monotonic counters. When a
+// reset is detected, the last value seen before the reset is added to an
+// offset for that counter. Increments between the last scrape and the reset
+// are lost, which is the best we can do without scraping slurmctld itself.
+type resetCounters struct {
+	mu        sync.Mutex
+	resetTime float64
+	offset    map[string]float64
+	last      map[string]float64
+}
+
+func newResetCounters() *resetCounters {
+	return &resetCounters{
+		offset: make(map[string]float64),
+		last:   make(map[string]float64),
+	}
+}
+
+// Adjust takes the current raw counter values along with the time the
+// statistics were last reset and returns the values with the accumulated
+// offsets applied.
+func (rc *resetCounters) Adjust(resetTime float64, values map[string]float64) map[string]float64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	reset := rc.resetTime != 0 && resetTime != rc.resetTime
+	rc.resetTime = resetTime
+
+	adjusted := make(map[string]float64, len(values))
+	for k, v := range values {
+		// a value going backwards without a new reset time is also a reset,
+		// for example when slurmctld restarts within the same second
+		if reset || v < rc.last[k] {
+			rc.offset[k] += rc.last[k]
+		}
+		rc.last[k] = v
+		adjusted[k] = rc.offset[k] + v
+	}
+	return adjusted
+}
@@ -0,0 +1,133 @@
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func TestParseSchedulerRpcMetrics(t *testing.T) {
+	diagData := &api.DiagData{
+		RpcsByMessageType: []api.RpcData{
+			{Name: "REQUEST_JOB_INFO", Count: 10, TotalTime: 2000000},
+			{Name: "REQUEST_JOB_INFO", Count: 5, TotalTime: 500000},
+		},
+		RpcsByUser: []api.RpcData{
+			{Name: "root", Count: 3, TotalTime: 1500000},
+		},
+		PendingRpcs: []api.RpcData{
+			{Name: "REQUEST_TERMINATE_JOB", Count: 4},
+		},
+	}
+	sm, err := ParseSchedulerMetrics(diagData)
+	if err != nil {
+		t.Fatalf("failed to parse scheduler metrics: %v", err)
+	}
+	jobInfo := sm.rpcs_by_type["REQUEST_JOB_INFO"]
+	if jobInfo.count != 15 || jobInfo.time != 2.5 {
+		t.Fatalf("unexpected rpc by type metrics: %+v", *jobInfo)
+	}
+	root := sm.rpcs_by_user["root"]
+	if root.count != 3 || root.time != 1.5 {
+		t.Fatalf("unexpected rpc by user metrics: %+v", *root)
+	}
+	if sm.rpcs_pending["REQUEST_TERMINATE_JOB"] != 4 {
+		t.Fatalf("unexpected pending rpc metrics: %v", sm.rpcs_pending)
+	}
+}
+
+func TestResetCountersAdjust(t *testing.T) {
+	rc := newResetCounters()
+	steps := []struct {
+		resetTime float64
+		value     float64
+		want      float64
+	}{
+		{100, 5, 5},
+		{100, 8, 8},
+		// slurmctld reset the statistics at midnight
+		{200, 2, 10},
+		{200, 4, 12},
+		// slurmctld restarted and the counter went backwards
+		{200, 1, 13},
+	}
+	for i, s := range steps {
+		got := rc.Adjust(s.resetTime, map[string]float64{"jobs": s.value})["jobs"]
+		if got != s.want {
+			t.Fatalf("step %d: expected %v, got %v", i, s.want, got)
+		}
+	}
+}
+
+func TestSchedulerRpcUsersRelabeled(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	hash, _ := types.NewLabelTransform(types.LabelModeHash, []byte("secret"), nil)
+	ctx := newTestContext(s.URL, "slurm", "secret", types.MetricSchemaV1, 0)
+	ctx = context.WithValue(ctx, types.UserLabelTransformKey, hash)
+	got, err := getMetrics(serveTestExporter(t, ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fakeslurm.Fixture("diag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagData, err := api.ProcessDiagResponse(b)
+	if err != nil {
+		t.Fatalf("failed to decode diag fixture: %v", err)
+	}
+	if len(diagData.RpcsByUser) == 0 {
+		t.Fatalf("expected rpcs by user in the diag fixture")
+	}
+	for _, rpc := range diagData.RpcsByUser {
+		if strings.Contains(got, fmt.Sprintf(`{user="%s"}`, rpc.Name)) {
+			t.Errorf("expected user %s to be hashed", rpc.Name)
+		}
+		if series := fmt.Sprintf(`slurm_rpc_user_count_total{user="%s"}`, hash.Apply(rpc.Name)); !strings.Contains(got, series) {
+			t.Errorf("expected %s in the metrics", series)
+		}
+	}
+}
+
+func TestRelabelDiag(t *testing.T) {
+	diagData := &api.DiagData{
+		RpcsByUser: []api.RpcData{
+			{Name: "root", Count: 3, TotalTime: 1500000},
+			{Name: "slurm", Count: 2, TotalTime: 500000},
+			{Name: "alice", Count: 1, TotalTime: 1000000},
+		},
+	}
+	system, _ := types.NewLabelTransform(types.LabelModeMap, nil, map[string]string{"root": "system", "slurm": "system"})
+	ctx := context.WithValue(context.Background(), types.UserLabelTransformKey, system)
+	sm, err := ParseSchedulerMetrics(relabelDiag(ctx, diagData))
+	if err != nil {
+		t.Fatalf("failed to parse scheduler metrics: %v", err)
+	}
+	// users mapped to the same name are summed
+	if rpc := sm.rpcs_by_user["system"]; rpc == nil || rpc.count != 5 || rpc.time != 2 {
+		t.Fatalf("expected the system users to be summed, got %v", sm.rpcs_by_user)
+	}
+	if rpc := sm.rpcs_by_user["other"]; rpc == nil || rpc.count != 1 || len(sm.rpcs_by_user) != 2 {
+		t.Fatalf("expected the unmapped user to be other, got %v", sm.rpcs_by_user)
+	}
+	if len(diagData.RpcsByUser) != 3 || diagData.RpcsByUser[0].Name != "root" {
+		t.Fatalf("expected the shared diag data to be left untouched")
+	}
+
+	drop, _ := types.NewLabelTransform(types.LabelModeDrop, nil, nil)
+	ctx = context.WithValue(context.Background(), types.UserLabelTransformKey, drop)
+	sm, err = ParseSchedulerMetrics(relabelDiag(ctx, diagData))
+	if err != nil {
+		t.Fatalf("failed to parse scheduler metrics: %v", err)
+	}
+	if len(sm.rpcs_by_user) != 0 {
+		t.Fatalf("expected no rpcs by user with the drop transform, got %v", sm.rpcs_by_user)
+	}
+}
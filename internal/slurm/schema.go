@@ -0,0 +1,19 @@
+package slurm
+
+import (
+	"context"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// metricSchema returns the metric schema stored in the context, defaulting to
+// the legacy v1 names if none was set. Metrics that are named correctly in
+// both schemas are exported regardless of the selected schema, only the
+// renamed ones are switched.
+func metricSchema(ctx context.Context) types.MetricSchema {
+	s, ok := ctx.Value(types.MetricSchemaKey).(types.MetricSchema)
+	if !ok {
+		return types.MetricSchemaV1
+	}
+	return s
+}
@@ -0,0 +1,23 @@
+package slurm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Serving both schemas at once must not register the same metric name twice
+func TestRegisterCollectorsForEachSchema(t *testing.T) {
+	for _, schema := range []types.MetricSchema{types.MetricSchemaV1, types.MetricSchemaV2, types.MetricSchemaBoth} {
+		ctx := context.WithValue(context.Background(), types.MetricSchemaKey, schema)
+		r := prometheus.NewRegistry()
+		collectors := NewCollectors(ctx)
+		for _, c := range collectors {
+			if err := r.Register(c); err != nil {
+				t.Fatalf("failed to register collector with schema %s: %v", schema, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package slurm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+// The snapshot API serves the same aggregated data the collectors export as
+// JSON, for tools that would otherwise poll slurmrestd themselves. It is
+// aggregated from the last poll when it is requested, so it is as fresh as
+// the last scrape, and serving it never queries slurmrestd.
+
+// Snapshot is the cluster state parsed from the polls. Each section is only
+// replaced when the responses it is parsed from were all polled successfully,
+// so a failing endpoint leaves its sections at their last known values, with
+// the time they were last updated.
+type Snapshot struct {
+	Time       time.Time           `json:"time"`
+	Nodes      *NodesSnapshot      `json:"nodes,omitempty"`
+	Partitions *PartitionsSnapshot `json:"partitions,omitempty"`
+	Queue      *QueueSnapshot      `json:"queue,omitempty"`
+	Scheduler  *SchedulerSnapshot  `json:"scheduler,omitempty"`
+}
+
+// NodesSnapshot is parsed from the nodes response
+type NodesSnapshot struct {
+	Updated time.Time               `json:"updated"`
+	States  map[string]float64      `json:"states"`
+	Nodes   map[string]NodeSnapshot `json:"nodes"`
+}
+
+type NodeSnapshot struct {
+	State       string `json:"state"`
+	CPUsAlloc   uint64 `json:"cpus_alloc"`
+	CPUsIdle    uint64 `json:"cpus_idle"`
+	CPUsOther   uint64 `json:"cpus_other"`
+	CPUsTotal   uint64 `json:"cpus_total"`
+	MemoryAlloc uint64 `json:"memory_alloc_megabytes"`
+	MemoryTotal uint64 `json:"memory_total_megabytes"`
+}
+
+// PartitionsSnapshot is parsed from the partitions, jobs and nodes responses
+type PartitionsSnapshot struct {
+	Updated    time.Time                    `json:"updated"`
+	Partitions map[string]PartitionSnapshot `json:"partitions"`
+}
+
+type PartitionSnapshot struct {
+	CPUsAlloc   float64 `json:"cpus_alloc"`
+	CPUsIdle    float64 `json:"cpus_idle"`
+	CPUsOther   float64 `json:"cpus_other"`
+	CPUsTotal   float64 `json:"cpus_total"`
+	JobsPending float64 `json:"jobs_pending"`
+}
+
+// QueueSnapshot is parsed from the jobs response
+type QueueSnapshot struct {
+	Updated           time.Time          `json:"updated"`
+	Jobs              map[string]float64 `json:"jobs"`
+	PendingDependency float64            `json:"pending_dependency"`
+}
+
+// SchedulerSnapshot is parsed from the diag response, durations are in
+// seconds
+type SchedulerSnapshot struct {
+	Updated             time.Time `json:"updated"`
+	Threads             float64   `json:"threads"`
+	AgentQueueSize      float64   `json:"agent_queue_size"`
+	DbdAgentQueueSize   float64   `json:"dbd_agent_queue_size"`
+	CycleLast           float64   `json:"cycle_last_seconds"`
+	CycleMean           float64   `json:"cycle_mean_seconds"`
+	CyclesPerMinute     float64   `json:"cycles_per_minute"`
+	BackfillActive      bool      `json:"backfill_active"`
+	BackfillCycleLast   float64   `json:"backfill_cycle_last_seconds"`
+	BackfillCycleMean   float64   `json:"backfill_cycle_mean_seconds"`
+	BackfillDepthMean   float64   `json:"backfill_depth_mean"`
+	BackfillQueueLength float64   `json:"backfill_queue_length"`
+	JobsPending         float64   `json:"jobs_pending"`
+	JobsRunning         float64   `json:"jobs_running"`
+	JobsSubmitted       float64   `json:"jobs_submitted"`
+	JobsStarted         float64   `json:"jobs_started"`
+	JobsCompleted       float64   `json:"jobs_completed"`
+	JobsCanceled        float64   `json:"jobs_canceled"`
+	JobsFailed          float64   `json:"jobs_failed"`
+}
+
+// SnapshotStore keeps the last Snapshot. It is an api.PollObserver, given
+// the parsed data of every poll, but it only keeps the last poll each section
+// can be parsed from and aggregates the sections when the snapshot is read.
+// Polling never waits for the snapshot api, at the cost of holding on to the
+// data of the last poll.
+type SnapshotStore struct {
+	mu      sync.Mutex
+	time    time.Time
+	sources snapshotSources
+
+	// aggregateMu serializes the readers aggregating the sections, so
+	// Observe isn't blocked by a large jobs list
+	aggregateMu sync.Mutex
+	parsed      snapshotSources
+	snapshot    Snapshot
+}
+
+// snapshotSources are the polls the sections of a snapshot are parsed from
+type snapshotSources struct {
+	nodes      *api.ClusterSnapshot
+	partitions *api.ClusterSnapshot
+	queue      *api.ClusterSnapshot
+	scheduler  *api.ClusterSnapshot
+}
+
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{}
+}
+
+// Observe keeps the poll as the source of the sections its endpoints were
+// polled successfully for. Sections whose endpoints failed keep their
+// previous source.
+func (s *SnapshotStore) Observe(cs *api.ClusterSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.time = cs.Time
+	if cs.Nodes != nil {
+		s.sources.nodes = cs
+	}
+	if cs.Partitions != nil && cs.Jobs != nil && cs.Nodes != nil {
+		s.sources.partitions = cs
+	}
+	if cs.Jobs != nil {
+		s.sources.queue = cs
+	}
+	if cs.Diag != nil {
+		s.sources.scheduler = cs
+	}
+}
+
+// Snapshot returns the last snapshot, aggregating the sections polled since
+// it was last read. The sections are replaced rather than modified by later
+// polls, so the copy is safe to read.
+func (s *SnapshotStore) Snapshot() Snapshot {
+	s.aggregateMu.Lock()
+	defer s.aggregateMu.Unlock()
+	s.mu.Lock()
+	at, sources := s.time, s.sources
+	s.mu.Unlock()
+
+	s.snapshot.Time = at
+	if src := sources.nodes; src != s.parsed.nodes {
+		if nodes := parseNodesSnapshot(src.Nodes, src.Time); nodes != nil {
+			s.snapshot.Nodes = nodes
+		}
+	}
+	if src := sources.partitions; src != s.parsed.partitions {
+		if partitions := parsePartitionsSnapshot(src.Partitions, src.Jobs, src.Nodes, src.Time); partitions != nil {
+			s.snapshot.Partitions = partitions
+		}
+	}
+	if src := sources.queue; src != s.parsed.queue {
+		if queue := parseQueueSnapshot(src.Jobs, src.Time); queue != nil {
+			s.snapshot.Queue = queue
+		}
+	}
+	if src := sources.scheduler; src != s.parsed.scheduler {
+		if scheduler := parseSchedulerSnapshot(src.Diag, src.Time); scheduler != nil {
+			s.snapshot.Scheduler = scheduler
+		}
+	}
+	s.parsed = sources
+	return s.snapshot
+}
+
+func parseNodesSnapshot(nodesData *api.NodesData, at time.Time) *NodesSnapshot {
+	if nodesData == nil {
+		return nil
+	}
+	nm, err := ParseNodesMetrics(nodesData)
+	if err != nil {
+		slog.Error("failed to parse nodes metrics for snapshot", "error", err)
+		return nil
+	}
+	nodeMetrics, err := ParseNodeMetrics(nodesData)
+	if err != nil {
+		slog.Error("failed to parse node metrics for snapshot", "error", err)
+		return nil
+	}
+	ns := &NodesSnapshot{
+		Updated: at,
+		// the same states as slurm_nodes
+		States: nm.states,
+		Nodes:  make(map[string]NodeSnapshot),
+	}
+	for name, m := range nodeMetrics {
+		ns.Nodes[name] = NodeSnapshot{
+			State:       m.nodeStatus,
+			CPUsAlloc:   m.cpuAlloc,
+			CPUsIdle:    m.cpuIdle,
+			CPUsOther:   m.cpuOther,
+			CPUsTotal:   m.cpuTotal,
+			MemoryAlloc: m.memAlloc,
+			MemoryTotal: m.memTotal,
+		}
+	}
+	return ns
+}
+
+func parsePartitionsSnapshot(partitionsData *api.PartitionsData, jobsData *api.JobsData, nodesData *api.NodesData, at time.Time) *PartitionsSnapshot {
+	if partitionsData == nil || jobsData == nil || nodesData == nil {
+		return nil
+	}
+	pm, err := ParsePartitionsMetrics(partitionsData, jobsData, nodesData)
+	if err != nil {
+		slog.Error("failed to parse partitions metrics for snapshot", "error", err)
+		return nil
+	}
+	ps := &PartitionsSnapshot{Updated: at, Partitions: make(map[string]PartitionSnapshot)}
+	for name, m := range pm {
+		ps.Partitions[name] = PartitionSnapshot{
+			CPUsAlloc:   m.cpus_allocated,
+			CPUsIdle:    m.cpus_idle,
+			CPUsOther:   m.cpus_other,
+			CPUsTotal:   m.cpus_total,
+			JobsPending: m.jobs[types.JobStatePending],
+		}
+	}
+	return ps
+}
+
+func parseQueueSnapshot(jobsData *api.JobsData, at time.Time) *QueueSnapshot {
+	if jobsData == nil {
+		return nil
+	}
+	qm, err := ParseQueueMetrics(jobsData)
+	if err != nil {
+		slog.Error("failed to parse queue metrics for snapshot", "error", err)
+		return nil
+	}
+	// the same states as slurm_queue_jobs
+	jobs := make(map[string]float64, len(types.JobStates))
+	for _, state := range types.JobStates {
+		jobs[string(state)] = qm.jobs[state]
+	}
+	return &QueueSnapshot{
+		Updated:           at,
+		Jobs:              jobs,
+		PendingDependency: qm.pending_dep,
+	}
+}
+
+func parseSchedulerSnapshot(diagData *api.DiagData, at time.Time) *SchedulerSnapshot {
+	if diagData == nil {
+		return nil
+	}
+	sm, err := ParseSchedulerMetrics(diagData)
+	if err != nil {
+		slog.Error("failed to parse scheduler metrics for snapshot", "error", err)
+		return nil
+	}
+	// slurm reports cycle times in microseconds
+	return &SchedulerSnapshot{
+		Updated:             at,
+		Threads:             sm.threads,
+		AgentQueueSize:      sm.queue_size,
+		DbdAgentQueueSize:   sm.dbd_queue_size,
+		CycleLast:           sm.last_cycle / 1e6,
+		CycleMean:           sm.mean_cycle / 1e6,
+		CyclesPerMinute:     sm.cycle_per_minute,
+		BackfillActive:      sm.backfill_active == 1,
+		BackfillCycleLast:   sm.backfill_last_cycle / 1e6,
+		BackfillCycleMean:   sm.backfill_mean_cycle / 1e6,
+		BackfillDepthMean:   sm.backfill_depth_mean,
+		BackfillQueueLength: sm.backfill_queue_length,
+		JobsPending:         sm.jobs_pending,
+		JobsRunning:         sm.jobs_running,
+		JobsSubmitted:       sm.jobs_submitted,
+		JobsStarted:         sm.jobs_started,
+		JobsCompleted:       sm.jobs_completed,
+		JobsCanceled:        sm.jobs_canceled,
+		JobsFailed:          sm.jobs_failed,
+	}
+}
+
+// SnapshotHandler serves a section of the last snapshot as JSON, or the
+// whole snapshot if section is empty. It returns 503 until the section has
+// been parsed from a successful poll.
+func SnapshotHandler(store *SnapshotStore, section string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot := store.Snapshot()
+		var v any
+		available := true
+		switch section {
+		case "":
+			v = snapshot
+			available = !snapshot.Time.IsZero()
+		case "nodes":
+			v = snapshot.Nodes
+			available = snapshot.Nodes != nil
+		case "partitions":
+			v = snapshot.Partitions
+			available = snapshot.Partitions != nil
+		case "queue":
+			v = snapshot.Queue
+			available = snapshot.Queue != nil
+		case "scheduler":
+			v = snapshot.Scheduler
+			available = snapshot.Scheduler != nil
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			v = map[string]string{"error": fmt.Sprintf("no %s data has been polled from slurmrestd yet", sectionName(section))}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(v)
+		if err != nil {
+			slog.Error("failed to encode snapshot", "error", err)
+		}
+	}
+}
+
+func sectionName(section string) string {
+	if section == "" {
+		return "snapshot"
+	}
+	return section
+}
@@ -0,0 +1,114 @@
+package slurm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/fakeslurm"
+	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
+)
+
+func pollSnapshot(s *fakeslurm.Server, store *SnapshotStore) {
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, types.ApiUserKey, "slurm")
+	ctx = context.WithValue(ctx, types.ApiTokenKey, "secret")
+	ctx = context.WithValue(ctx, types.ApiURLKey, s.URL)
+	ctx = context.WithValue(ctx, types.ApiPollObserverKey, store)
+	ctx = api.RegisterEndpoints(ctx)
+	// errors are checked through the snapshot
+	_ = api.CheckConnection(ctx)
+}
+
+func getSnapshot(t *testing.T, store *SnapshotStore, section string, v any) int {
+	w := httptest.NewRecorder()
+	SnapshotHandler(store, section).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/"+section, nil))
+	if w.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("failed to decode %s snapshot: %v", section, err)
+		}
+	}
+	return w.Code
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	store := NewSnapshotStore()
+
+	if code := getSnapshot(t, store, "", nil); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the first poll, got %d", code)
+	}
+	pollSnapshot(s, store)
+
+	var snapshot Snapshot
+	if code := getSnapshot(t, store, "", &snapshot); code != http.StatusOK {
+		t.Fatalf("expected the snapshot after a poll, got %d", code)
+	}
+	if snapshot.Nodes == nil || snapshot.Partitions == nil || snapshot.Queue == nil || snapshot.Scheduler == nil {
+		t.Fatalf("expected every section in the snapshot, got %+v", snapshot)
+	}
+
+	// the sections are parsed from the responses
+	var nodes NodesSnapshot
+	getSnapshot(t, store, "nodes", &nodes)
+	if len(nodes.Nodes) == 0 || len(nodes.States) == 0 {
+		t.Fatalf("expected nodes in the nodes snapshot, got %+v", nodes)
+	}
+	var queue QueueSnapshot
+	getSnapshot(t, store, "queue", &queue)
+	if _, ok := queue.Jobs["running"]; !ok {
+		t.Fatalf("expected job counts in the queue snapshot, got %+v", queue)
+	}
+	if code := getSnapshot(t, store, "bogus", nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown section, got %d", code)
+	}
+}
+
+func TestSnapshotKeepsFailedSections(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	store := NewSnapshotStore()
+	pollSnapshot(s, store)
+	first := store.Snapshot()
+
+	// a failing jobs endpoint keeps the sections parsed from it
+	time.Sleep(time.Millisecond)
+	s.SetFault("jobs", fakeslurm.Fault{StatusCode: 500})
+	pollSnapshot(s, store)
+	second := store.Snapshot()
+	if !second.Time.After(first.Time) {
+		t.Fatalf("expected the snapshot time to be updated")
+	}
+	if second.Queue != first.Queue || second.Partitions != first.Partitions {
+		t.Fatalf("expected the queue and partitions sections to be kept when jobs fails")
+	}
+	if second.Nodes == first.Nodes || !second.Nodes.Updated.After(first.Nodes.Updated) {
+		t.Fatalf("expected the nodes section to be updated")
+	}
+}
+
+func TestSnapshotAggregatedOnRead(t *testing.T) {
+	s := fakeslurm.NewServer("slurm", "secret")
+	defer s.Close()
+	store := NewSnapshotStore()
+	pollSnapshot(s, store)
+	if store.snapshot.Nodes != nil || store.snapshot.Queue != nil {
+		t.Fatalf("expected the poll not to aggregate the snapshot")
+	}
+	first := store.Snapshot()
+	if first.Nodes == nil || first.Partitions == nil || first.Queue == nil || first.Scheduler == nil {
+		t.Fatalf("expected every section to be aggregated on read")
+	}
+
+	// reading again without a poll reuses the aggregated sections
+	second := store.Snapshot()
+	if second.Nodes != first.Nodes || second.Partitions != first.Partitions ||
+		second.Queue != first.Queue || second.Scheduler != first.Scheduler {
+		t.Fatalf("expected the sections to be aggregated once per poll")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 
-	"github.com/akyoto/cache"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/api"
 	"github.com/lcrownover/prometheus-slurm-exporter/internal/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,66 +11,109 @@ import (
 
 type UsersCollector struct {
 	ctx          context.Context
+	schema       types.MetricSchema
+	jobs         *prometheus.Desc
+	cpus         *prometheus.Desc
 	pending      *prometheus.Desc
 	pending_cpus *prometheus.Desc
 	running      *prometheus.Desc
 	running_cpus *prometheus.Desc
 	suspended    *prometheus.Desc
+	filter       *types.SeriesFilter
+	dropped      *prometheus.Desc
 }
 
 func NewUsersCollector(ctx context.Context) *UsersCollector {
 	labels := []string{"user"}
 	return &UsersCollector{
 		ctx:          ctx,
-		pending:      prometheus.NewDesc("slurm_user_jobs_pending", "Pending jobs for user", labels, nil),
-		pending_cpus: prometheus.NewDesc("slurm_user_cpus_pending", "Pending jobs for user", labels, nil),
-		running:      prometheus.NewDesc("slurm_user_jobs_running", "Running jobs for user", labels, nil),
-		running_cpus: prometheus.NewDesc("slurm_user_cpus_running", "Running cpus for user", labels, nil),
-		suspended:    prometheus.NewDesc("slurm_user_jobs_suspended", "Suspended jobs for user", labels, nil),
+		schema:       metricSchema(ctx),
+		jobs:         newDesc(ctx, "slurm_user_jobs", "Jobs for user by state", []string{"user", "state"}, nil),
+		cpus:         newDesc(ctx, "slurm_user_cpus", "CPUs for user by job state", []string{"user", "state"}, nil),
+		pending:      newDesc(ctx, "slurm_user_jobs_pending", "Pending jobs for user", labels, nil),
+		pending_cpus: newDesc(ctx, "slurm_user_cpus_pending", "Pending cpus for user", labels, nil),
+		running:      newDesc(ctx, "slurm_user_jobs_running", "Running jobs for user", labels, nil),
+		running_cpus: newDesc(ctx, "slurm_user_cpus_running", "Running cpus for user", labels, nil),
+		suspended:    newDesc(ctx, "slurm_user_jobs_suspended", "Suspended jobs for user", labels, nil),
+		filter:       seriesFilter(ctx, "users"),
+		dropped:      newDroppedDesc(ctx, "users"),
 	}
 }
 
+// WithContext returns a copy of the collector reading the snapshot bound
+// to ctx
+func (uc *UsersCollector) WithContext(ctx context.Context) prometheus.Collector {
+	c := *uc
+	c.ctx = ctx
+	return &c
+}
+
 func (uc *UsersCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- uc.pending
-	ch <- uc.pending_cpus
-	ch <- uc.running
-	ch <- uc.running_cpus
-	ch <- uc.suspended
+	if uc.schema.Legacy() {
+		ch <- uc.pending
+		ch <- uc.pending_cpus
+		ch <- uc.running
+		ch <- uc.running_cpus
+		ch <- uc.suspended
+	}
+	if uc.schema.V2() {
+		ch <- uc.jobs
+		ch <- uc.cpus
+	}
+	if uc.filter != nil {
+		ch <- uc.dropped
+	}
 }
 
 func (uc *UsersCollector) Collect(ch chan<- prometheus.Metric) {
-	apiCache := uc.ctx.Value(types.ApiCacheKey).(*cache.Cache)
-	jobsRespBytes, found := apiCache.Get("jobs")
-	if !found {
-		slog.Error("failed to get jobs response for users metrics from cache")
-		return
-	}
-	jobsData, err := api.ProcessJobsResponse(jobsRespBytes.([]byte))
-	if err != nil {
-		slog.Error("failed to process jobs data for users metrics", "error", err)
+	snapshot := api.CurrentSnapshot(uc.ctx)
+	jobsData := snapshot.Jobs
+	if jobsData == nil {
+		slog.Error("failed to get jobs data for users metrics", "error", snapshot.Err("jobs"))
 		return
 	}
+	jobsData = relabelJobs(uc.ctx, jobsData)
 	um, err := ParseUsersMetrics(jobsData)
 	if err != nil {
 		slog.Error("failed to collect user metrics", "error", err)
 		return
 	}
+	if uc.filter != nil {
+		var dropped droppedValues[userJobMetrics]
+		um, dropped = filterSeries(uc.filter, um, (*userJobMetrics).weight, (*userJobMetrics).add)
+		dropped.collect(ch, uc.dropped, uc.collectUser)
+	}
 	for u := range um {
-		if um[u].pending > 0 {
-			ch <- prometheus.MustNewConstMetric(uc.pending, prometheus.GaugeValue, um[u].pending, u)
-		}
-		if um[u].pending_cpus > 0 {
-			ch <- prometheus.MustNewConstMetric(uc.pending_cpus, prometheus.GaugeValue, um[u].pending_cpus, u)
-		}
-		if um[u].running > 0 {
-			ch <- prometheus.MustNewConstMetric(uc.running, prometheus.GaugeValue, um[u].running, u)
-		}
-		if um[u].running_cpus > 0 {
-			ch <- prometheus.MustNewConstMetric(uc.running_cpus, prometheus.GaugeValue, um[u].running_cpus, u)
-		}
-		if um[u].suspended > 0 {
-			ch <- prometheus.MustNewConstMetric(uc.suspended, prometheus.GaugeValue, um[u].suspended, u)
-		}
+		uc.collectUser(ch, u, um[u])
+	}
+}
+
+// collectUser sends the series of a user
+func (uc *UsersCollector) collectUser(ch chan<- prometheus.Metric, u string, m *userJobMetrics) {
+	if uc.schema.V2() {
+		ch <- prometheus.MustNewConstMetric(uc.jobs, prometheus.GaugeValue, m.pending, u, "pending")
+		ch <- prometheus.MustNewConstMetric(uc.jobs, prometheus.GaugeValue, m.running, u, "running")
+		ch <- prometheus.MustNewConstMetric(uc.jobs, prometheus.GaugeValue, m.suspended, u, "suspended")
+		ch <- prometheus.MustNewConstMetric(uc.cpus, prometheus.GaugeValue, m.pending_cpus, u, "pending")
+		ch <- prometheus.MustNewConstMetric(uc.cpus, prometheus.GaugeValue, m.running_cpus, u, "running")
+	}
+	if !uc.schema.Legacy() {
+		return
+	}
+	if m.pending > 0 {
+		ch <- prometheus.MustNewConstMetric(uc.pending, prometheus.GaugeValue, m.pending, u)
+	}
+	if m.pending_cpus > 0 {
+		ch <- prometheus.MustNewConstMetric(uc.pending_cpus, prometheus.GaugeValue, m.pending_cpus, u)
+	}
+	if m.running > 0 {
+		ch <- prometheus.MustNewConstMetric(uc.running, prometheus.GaugeValue, m.running, u)
+	}
+	if m.running_cpus > 0 {
+		ch <- prometheus.MustNewConstMetric(uc.running_cpus, prometheus.GaugeValue, m.running_cpus, u)
+	}
+	if m.suspended > 0 {
+		ch <- prometheus.MustNewConstMetric(uc.suspended, prometheus.GaugeValue, m.suspended, u)
 	}
 }
 
@@ -87,23 +129,42 @@ type userJobMetrics struct {
 	suspended    float64
 }
 
+// weight orders users when folding them into other, the users with the most
+// cpus in use or requested are kept
+func (m *userJobMetrics) weight() float64 {
+	return m.pending_cpus + m.running_cpus
+}
+
+func (m *userJobMetrics) add(o *userJobMetrics) {
+	m.pending += o.pending
+	m.pending_cpus += o.pending_cpus
+	m.running += o.running
+	m.running_cpus += o.running_cpus
+	m.suspended += o.suspended
+}
+
 func ParseUsersMetrics(jobsData *api.JobsData) (map[string]*userJobMetrics, error) {
 	users := make(map[string]*userJobMetrics)
+	counter := make(jobCounter)
 	for _, j := range jobsData.Jobs {
 		user := j.UserName
 		if _, exists := users[user]; !exists {
 			users[user] = NewUserJobMetrics()
 		}
 
+		// a pending job array counts as its tasks, and a het job as one job
+		n := counter.count(j, user)
 		switch j.JobState {
 		case types.JobStatePending:
-			users[user].pending++
-			users[user].pending_cpus += float64(j.Cpus)
+			users[user].pending += n
+			// slurm only reports the cpus of a job once it is
+			// allocated, a pending job requests them in its TRES
+			users[user].pending_cpus += float64(j.TresCpus) * float64(j.Tasks())
 		case types.JobStateRunning:
-			users[user].running++
+			users[user].running += n
 			users[user].running_cpus += float64(j.Cpus)
 		case types.JobStateSuspended:
-			users[user].suspended++
+			users[user].suspended += n
 		}
 	}
 	return users, nil
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// SeriesFilter limits the label values (users, accounts, nodes or partitions)
+// a collector exports. Values not matching Include, or matching Exclude, are
+// not exported. If more than Limit values are left, the smallest ones are
+// folded into a single "other" value so the number of series stays bounded.
+type SeriesFilter struct {
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+	Limit   int
+}
+
+// NewSeriesFilter returns a SeriesFilter for the given regular expressions,
+// which are anchored like Prometheus relabeling regexes. Empty expressions
+// and a limit of 0 disable that part of the filter.
+func NewSeriesFilter(include string, exclude string, limit int) (*SeriesFilter, error) {
+	f := &SeriesFilter{Limit: limit}
+	var err error
+	if include != "" {
+		f.Include, err = regexp.Compile("^(?:" + include + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid include regex: %v", err)
+		}
+	}
+	if exclude != "" {
+		f.Exclude, err = regexp.Compile("^(?:" + exclude + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %v", err)
+		}
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("invalid limit: %d", limit)
+	}
+	return f, nil
+}
+
+// Allowed returns true if the label value v passes the include and exclude
+// regexes. A nil SeriesFilter allows everything.
+func (f *SeriesFilter) Allowed(v string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Include != nil && !f.Include.MatchString(v) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.MatchString(v) {
+		return false
+	}
+	return true
+}
@@ -2,22 +2,86 @@ package types
 
 type JobState string
 
+// The base states of a job
 const (
 	JobStatePending     JobState = "pending"
-	JobStateCompleted   JobState = "pompleted"
-	JobStateFailed      JobState = "failed"
-	JobStateOutOfMemory JobState = "out_of_memory"
 	JobStateRunning     JobState = "running"
 	JobStateSuspended   JobState = "suspended"
-	JobStateUnknown     JobState = "unknown"
-	JobStateTimeout     JobState = "timeout"
+	JobStateCompleted   JobState = "completed"
 	JobStateCancelled   JobState = "cancelled"
+	JobStateFailed      JobState = "failed"
+	JobStateTimeout     JobState = "timeout"
+	JobStateNodeFail    JobState = "node_fail"
+	JobStatePreempted   JobState = "preempted"
+	JobStateBootFail    JobState = "boot_fail"
+	JobStateDeadline    JobState = "deadline"
+	JobStateOutOfMemory JobState = "out_of_memory"
+	JobStateUnknown     JobState = "unknown"
+)
+
+// The flags of a job that are reported as its state instead of the base
+// state, the same way squeue does
+const (
 	JobStateCompleting  JobState = "completing"
+	JobStateStageOut    JobState = "stage_out"
 	JobStateConfiguring JobState = "configuring"
-	JobStatePreempted   JobState = "preempted"
-	JobStateNodeFail    JobState = "node_fail"
+	JobStateResizing    JobState = "resizing"
+	JobStateRequeued    JobState = "requeued"
+	JobStateRequeueFed  JobState = "requeue_fed"
+	JobStateRequeueHold JobState = "requeue_hold"
+	JobStateSpecialExit JobState = "special_exit"
+	JobStateStopped     JobState = "stopped"
+	JobStateRevoked     JobState = "revoked"
+	JobStateResvDelHold JobState = "resv_del_hold"
+	JobStateSignaling   JobState = "signaling"
 )
 
+// JobStateFlags are the flags reported as the state of a job, in order of
+// precedence
+var JobStateFlags = []JobState{
+	JobStateCompleting,
+	JobStateStageOut,
+	JobStateConfiguring,
+	JobStateResizing,
+	JobStateRequeued,
+	JobStateRequeueFed,
+	JobStateRequeueHold,
+	JobStateSpecialExit,
+	JobStateStopped,
+	JobStateRevoked,
+	JobStateResvDelHold,
+	JobStateSignaling,
+}
+
+// JobStates is every state a job can be reported in
+var JobStates = []JobState{
+	JobStatePending,
+	JobStateRunning,
+	JobStateSuspended,
+	JobStateCompleted,
+	JobStateCancelled,
+	JobStateFailed,
+	JobStateTimeout,
+	JobStateNodeFail,
+	JobStatePreempted,
+	JobStateBootFail,
+	JobStateDeadline,
+	JobStateOutOfMemory,
+	JobStateCompleting,
+	JobStateStageOut,
+	JobStateConfiguring,
+	JobStateResizing,
+	JobStateRequeued,
+	JobStateRequeueFed,
+	JobStateRequeueHold,
+	JobStateSpecialExit,
+	JobStateStopped,
+	JobStateRevoked,
+	JobStateResvDelHold,
+	JobStateSignaling,
+	JobStateUnknown,
+}
+
 type SlurmJobsResponse struct {
 	Jobs []slurmJob `json:"jobs"`
 }
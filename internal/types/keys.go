@@ -13,4 +13,16 @@ const (
 	ApiPartitionsEndpointKey
 	ApiDiagEndpointKey
 	ApiSharesEndpointKey
+	PerJobMetricsKey
+	MetricSchemaKey
+	ApiRecorderKey
+	ApiReplayerKey
+	UserLabelTransformKey
+	AccountLabelTransformKey
+	SeriesFiltersKey
+	ApiStatusKey
+	ApiPollObserverKey
+	ApiIncrementalKey
+	ApiSnapshotKey
+	DescNamesKey
 )
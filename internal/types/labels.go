@@ -0,0 +1,125 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LabelMode selects how user and account label values are exported, so sites
+// that can't store real names in Prometheus can still use the per user and
+// per account metrics.
+type LabelMode string
+
+const (
+	// LabelModeKeep exports the real names
+	LabelModeKeep LabelMode = "keep"
+	// LabelModeHash exports an HMAC of the name, keyed with a secret
+	LabelModeHash LabelMode = "hash"
+	// LabelModeMap exports the name given for it in a lookup file
+	LabelModeMap LabelMode = "map"
+	// LabelModeDrop exports an empty value, aggregating all names together
+	LabelModeDrop LabelMode = "drop"
+)
+
+func ParseLabelMode(s string) (LabelMode, error) {
+	switch LabelMode(s) {
+	case LabelModeKeep, LabelModeHash, LabelModeMap, LabelModeDrop:
+		return LabelMode(s), nil
+	}
+	return "", fmt.Errorf("invalid label mode: %s", s)
+}
+
+// hashLength is the number of hex characters of the HMAC that are kept, 64
+// bits is plenty to avoid collisions between the names on a cluster.
+const hashLength = 16
+
+// LabelTransform rewrites label values according to a LabelMode. The result
+// only depends on the mode, the secret and the lookup file, so the same name
+// is exported with the same value across restarts and dashboards stay
+// continuous.
+type LabelTransform struct {
+	mode    LabelMode
+	secret  []byte
+	mapping map[string]string
+}
+
+// NewLabelTransform returns a LabelTransform for mode. LabelModeHash requires a
+// secret and LabelModeMap requires a mapping. In LabelModeMap, names missing
+// from the mapping are hashed if a secret is given, or exported as "other".
+func NewLabelTransform(mode LabelMode, secret []byte, mapping map[string]string) (*LabelTransform, error) {
+	if mode == LabelModeHash && len(secret) == 0 {
+		return nil, fmt.Errorf("a secret is required to hash labels")
+	}
+	if mode == LabelModeMap && mapping == nil {
+		return nil, fmt.Errorf("a lookup file is required to map labels")
+	}
+	return &LabelTransform{mode: mode, secret: secret, mapping: mapping}, nil
+}
+
+// Apply returns the value to export for the label value v. A nil
+// LabelTransform keeps the value.
+func (t *LabelTransform) Apply(v string) string {
+	if t == nil {
+		return v
+	}
+	switch t.mode {
+	case LabelModeHash:
+		return t.hash(v)
+	case LabelModeMap:
+		if m, ok := t.mapping[v]; ok {
+			return m
+		}
+		if len(t.secret) > 0 {
+			return t.hash(v)
+		}
+		return "other"
+	case LabelModeDrop:
+		return ""
+	}
+	return v
+}
+
+func (t *LabelTransform) hash(v string) string {
+	if v == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+// ReadLabelMap reads a lookup file of "name,value" lines, lines starting with
+// # are ignored.
+func ReadLabelMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open label lookup file: %v", err)
+	}
+	defer f.Close()
+	return parseLabelMap(f)
+}
+
+func parseLabelMap(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	mapping := make(map[string]string)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse label lookup file: %v", err)
+		}
+		mapping[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
+	}
+	return mapping, nil
+}
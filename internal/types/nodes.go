@@ -1,25 +1,44 @@
 package types
 
+// NodeState is the base state of a node
 type NodeState string
 
 const (
-	NodeStateAlloc         NodeState = "alloc"
-	NodeStateComp          NodeState = "comp"
-	NodeStateDown          NodeState = "down"
-	NodeStateDrain         NodeState = "drain"
-	NodeStateFail          NodeState = "fail"
-	NodeStateErr           NodeState = "err"
-	NodeStateIdle          NodeState = "idle"
-	NodeStateMaint         NodeState = "maint"
-	NodeStateMix           NodeState = "mix"
-	NodeStateResv          NodeState = "resv"
-	NodeStatePlanned       NodeState = "planned"
-	NodeStateNotResponding NodeState = "not_responding"
-	NodeStateInvalid       NodeState = "invalid"
-	NodeStateInvalidReg    NodeState = "invalid_reg"
-	NodeStateDynamicNorm   NodeState = "dynamic_norm"
-	NodeStateReboot        NodeState = "reboot"
-	NodeStateRebootIssued  NodeState = "reboot_issued"
-	NodeStateRebootCancel  NodeState = "reboot_cancel"
-	NodeStatePoweredDown   NodeState = "powered_down"
+	NodeStateAlloc   NodeState = "alloc"
+	NodeStateDown    NodeState = "down"
+	NodeStateErr     NodeState = "err"
+	NodeStateFuture  NodeState = "future"
+	NodeStateIdle    NodeState = "idle"
+	NodeStateMix     NodeState = "mix"
+	NodeStateUnknown NodeState = "unknown"
+)
+
+// NodeFlag is a flag set on the base state of a node
+type NodeFlag string
+
+const (
+	NodeFlagBlocked         NodeFlag = "blocked"
+	NodeFlagCloud           NodeFlag = "cloud"
+	NodeFlagCompleting      NodeFlag = "completing"
+	NodeFlagDrain           NodeFlag = "drain"
+	NodeFlagDynamicFuture   NodeFlag = "dynamic_future"
+	NodeFlagDynamicNorm     NodeFlag = "dynamic_norm"
+	NodeFlagExternal        NodeFlag = "external"
+	NodeFlagFail            NodeFlag = "fail"
+	NodeFlagInvalidReg      NodeFlag = "invalid_reg"
+	NodeFlagMaint           NodeFlag = "maint"
+	NodeFlagNotResponding   NodeFlag = "not_responding"
+	NodeFlagPlanned         NodeFlag = "planned"
+	NodeFlagPowerDown       NodeFlag = "power_down"
+	NodeFlagPowerDrain      NodeFlag = "power_drain"
+	NodeFlagPoweredDown     NodeFlag = "powered_down"
+	NodeFlagPoweringDown    NodeFlag = "powering_down"
+	NodeFlagPoweringUp      NodeFlag = "powering_up"
+	NodeFlagPowerUp         NodeFlag = "power_up"
+	NodeFlagRebootCanceled  NodeFlag = "reboot_canceled"
+	NodeFlagRebootIssued    NodeFlag = "reboot_issued"
+	NodeFlagRebootRequested NodeFlag = "reboot_requested"
+	NodeFlagReserved        NodeFlag = "reserved"
+	NodeFlagResume          NodeFlag = "resume"
+	NodeFlagUndrain         NodeFlag = "undrain"
 )
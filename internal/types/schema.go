@@ -0,0 +1,33 @@
+package types
+
+import "fmt"
+
+// MetricSchema selects which metric names the collectors export. The v1
+// schema keeps the names inherited from the vpenso exporter so existing
+// dashboards keep working, v2 follows the Prometheus naming conventions
+// (base units, _total counters and state labels).
+type MetricSchema string
+
+const (
+	MetricSchemaV1   MetricSchema = "v1"
+	MetricSchemaV2   MetricSchema = "v2"
+	MetricSchemaBoth MetricSchema = "both"
+)
+
+func ParseMetricSchema(s string) (MetricSchema, error) {
+	switch MetricSchema(s) {
+	case MetricSchemaV1, MetricSchemaV2, MetricSchemaBoth:
+		return MetricSchema(s), nil
+	}
+	return "", fmt.Errorf("invalid metric schema: %s", s)
+}
+
+// Legacy returns true if the v1 metric names should be exported
+func (s MetricSchema) Legacy() bool {
+	return s != MetricSchemaV2
+}
+
+// V2 returns true if the v2 metric names should be exported
+func (s MetricSchema) V2() bool {
+	return s == MetricSchemaV2 || s == MetricSchemaBoth
+}